@@ -0,0 +1,96 @@
+package smt
+
+import (
+	"bytes"
+)
+
+// AdoptRoot makes root, computed into source by another tree, the root of the
+// tree. The nodes reachable from root that are missing from the node store of
+// the tree are first copied from source, along with the values of their
+// leaves, and only then is the root of the tree set to root. Concurrent calls
+// to Root, and reads at the current root, thus see either the old or the new
+// root and never a partially copied tree.
+//
+// Source must hold both the nodes and the values of the computed tree, such
+// as a store used as both the node and the value store of the tree that
+// computed it.
+//
+// Nodes are copied children first, so that a node present in the store always
+// has its subtree present too. Subtrees whose root node is already in the
+// store are thus skipped: the copy costs one store lookup per node that is
+// shared with the current state of the tree, and a read and a write per node
+// that is not. On failure, the root of the tree is left unchanged.
+func (smt *SparseMerkleTree) AdoptRoot(root []byte, source MapStore) error {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	if err := smt.adoptNode(root, source); err != nil {
+		return err
+	}
+	smt.SetRoot(root)
+	return nil
+}
+
+// adoptNode copies the subtree at hash from source, unless it is already in
+// the node store.
+func (smt *SparseMerkleTree) adoptNode(hash []byte, source MapStore) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	if has, err := smt.nodes.Has(hash); err != nil || has {
+		return err
+	}
+
+	record, err := source.Get(hash)
+	if err != nil {
+		return err
+	}
+	data, inlineValue := smt.splitNode(record)
+	if err := smt.th.checkNode(hash, data); err != nil {
+		return err
+	}
+	if !bytes.Equal(smt.th.digest(data), hash) {
+		return ErrMalformedNode
+	}
+
+	if smt.th.isLeaf(data) {
+		value := inlineValue
+		if value == nil {
+			if value, err = source.Get(smt.th.valueKey(data)); err != nil {
+				return err
+			}
+		}
+		return smt.putLeaf(hash, data, value)
+	}
+
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.adoptNode(leftNode, source); err != nil {
+		return err
+	}
+	if err := smt.adoptNode(rightNode, source); err != nil {
+		return err
+	}
+	return smt.nodes.Put(hash, data)
+}
+
+// CopyToStore copies every node reachable from the current root, along with
+// the values of its leaves, to dst, such as to migrate the tree to another
+// store. dst is meant to be used as both the node and the value store of the
+// copy, e.g. with ImportSparseMerkleTree(dst, dst, hasher, root).
+//
+// Every node and value is put once, so stores keeping reference counts, like
+// SimpleMap, hold one reference for them per copy.
+func (smt *SparseMerkleTree) CopyToStore(dst MapStore) error {
+	return smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		if smt.th.isLeaf(data) {
+			value, err := smt.leafValue(data, inlineValue)
+			if err != nil {
+				return err
+			}
+			if err := dst.Put(smt.th.valueKey(data), value); err != nil {
+				return err
+			}
+		}
+		return dst.Put(hash, data)
+	})
+}
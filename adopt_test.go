@@ -0,0 +1,121 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestAdoptRoot(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	oldRoot := smt.Root()
+
+	// Compute a new state into a separate store.
+	source := NewSimpleMap()
+	worker := NewSparseMerkleTree(source, source, sha256.New())
+	for i := 10; i < 40; i++ {
+		worker.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("newValue%d", i)))
+	}
+
+	if err := smt.AdoptRoot(worker.Root(), source); err != nil {
+		t.Fatalf("returned error when adopting root: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), worker.Root()) {
+		t.Error("did not adopt root")
+	}
+	for i := 0; i < 40; i++ {
+		value, err := smt.Get([]byte(fmt.Sprintf("testKey%d", i)))
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		expected := []byte(fmt.Sprintf("newValue%d", i))
+		if i < 10 {
+			expected = defaultValue
+		}
+		if !bytes.Equal(value, expected) {
+			t.Errorf("did not get correct value for key %d", i)
+		}
+	}
+	value, _ := smt.GetFromRoot([]byte("testKey0"), oldRoot)
+	if !bytes.Equal(value, []byte("testValue0")) {
+		t.Error("did not keep old root")
+	}
+
+	// The tree can be updated further from the adopted root.
+	smt.Update([]byte("testKey0"), []byte("testValue0"))
+	value, _ = smt.Get([]byte("testKey0"))
+	if !bytes.Equal(value, []byte("testValue0")) {
+		t.Error("did not get correct value after updating adopted root")
+	}
+
+	// Adopting a root missing from the source fails and keeps the root.
+	root := smt.Root()
+	missing := sha256.Sum256([]byte("missing"))
+	if err := smt.AdoptRoot(missing[:], source); err == nil {
+		t.Error("did not return error when adopting missing root")
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("changed root after failing to adopt root")
+	}
+
+	// Adopting the empty root needs no nodes.
+	if err := smt.AdoptRoot(EmptyRoot(sha256.New()), NewSimpleMap()); err != nil {
+		t.Errorf("returned error when adopting empty root: %v", err)
+	}
+}
+
+func TestCopyToStore(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, append([]byte("testValue"), key...))
+	}
+	oldRoot := smt.Root()
+	smt.Delete([]byte("0"))
+
+	dst := NewSimpleMap()
+	if err := smt.CopyToStore(dst); err != nil {
+		t.Fatalf("returned error when copying tree: %v", err)
+	}
+	// Nodes of older roots are not copied.
+	if has, _ := dst.Has(oldRoot); has {
+		t.Error("copied the node of an older root")
+	}
+
+	copied := ImportSparseMerkleTree(dst, dst, sha256.New(), smt.Root())
+	for i := 0; i < 50; i++ {
+		key := []byte(strconv.Itoa(i))
+		expected := append([]byte("testValue"), key...)
+		if i == 0 {
+			expected = defaultValue
+		}
+		value, err := copied.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key from copy: %v", err)
+		}
+		if !bytes.Equal(value, expected) {
+			t.Error("did not get correct value from copy")
+		}
+		proof, err := copied.Prove(key)
+		if err != nil {
+			t.Errorf("returned error when proving key from copy: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), key, expected, sha256.New()) {
+			t.Error("proof from copy failed to verify")
+		}
+	}
+
+	// The copy can be updated like the original.
+	copied.Update([]byte("0"), []byte("testValue0"))
+	smt.Update([]byte("0"), []byte("testValue0"))
+	if !bytes.Equal(copied.Root(), smt.Root()) {
+		t.Error("copy diverged from the original after an update")
+	}
+}
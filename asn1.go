@@ -0,0 +1,72 @@
+package smt
+
+import (
+	"encoding/asn1"
+	"fmt"
+)
+
+// asn1Proof is the ASN.1 structure of a proof:
+//
+//	SparseMerkleProof ::= SEQUENCE {
+//	    root                  OCTET STRING,
+//	    keySize               INTEGER,
+//	    sideNodes             SEQUENCE OF OCTET STRING,
+//	    nonMembershipLeafData [0] IMPLICIT OCTET STRING OPTIONAL,
+//	    siblingData           [1] IMPLICIT OCTET STRING OPTIONAL
+//	}
+//
+// where keySize is the size in bytes of the paths and digests of the tree.
+type asn1Proof struct {
+	Root                  []byte
+	KeySize               int
+	SideNodes             [][]byte
+	NonMembershipLeafData []byte `asn1:"optional,tag:0"`
+	SiblingData           []byte `asn1:"optional,tag:1"`
+}
+
+// MarshalASN1 encodes the proof and the root it was generated against as a
+// DER encoded ASN.1 structure.
+func (proof *SparseMerkleProof) MarshalASN1(root []byte) ([]byte, error) {
+	sideNodes := proof.SideNodes
+	if sideNodes == nil {
+		sideNodes = [][]byte{}
+	}
+	return asn1.Marshal(asn1Proof{
+		Root:                  root,
+		KeySize:               len(root),
+		SideNodes:             sideNodes,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		SiblingData:           proof.SiblingData,
+	})
+}
+
+// UnmarshalASN1Proof decodes a proof encoded by MarshalASN1, returning the
+// proof and the root it was generated against.
+func UnmarshalASN1Proof(data []byte) (SparseMerkleProof, []byte, error) {
+	var decoded asn1Proof
+	rest, err := asn1.Unmarshal(data, &decoded)
+	if err != nil {
+		return SparseMerkleProof{}, nil, fmt.Errorf("%w: %v", ErrMalformedEncoding, err)
+	}
+	if len(rest) != 0 {
+		return SparseMerkleProof{}, nil, fmt.Errorf("%w: trailing data", ErrMalformedEncoding)
+	}
+
+	if decoded.KeySize <= 0 || len(decoded.Root) != decoded.KeySize || len(decoded.SideNodes) > decoded.KeySize*8 {
+		return SparseMerkleProof{}, nil, fmt.Errorf("%w: inconsistent key size", ErrMalformedEncoding)
+	}
+	for _, sideNode := range decoded.SideNodes {
+		if len(sideNode) != decoded.KeySize {
+			return SparseMerkleProof{}, nil, fmt.Errorf("%w: inconsistent key size", ErrMalformedEncoding)
+		}
+	}
+
+	proof := SparseMerkleProof{
+		NonMembershipLeafData: decoded.NonMembershipLeafData,
+		SiblingData:           decoded.SiblingData,
+	}
+	if len(decoded.SideNodes) > 0 {
+		proof.SideNodes = decoded.SideNodes
+	}
+	return proof, decoded.Root, nil
+}
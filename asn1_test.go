@@ -0,0 +1,92 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// DER encoded proof of testKey=testValue in a tree that also holds
+// testKey2=testValue2.
+const knownASN1Proof = "30490420e197bf412f9c87d77f96405e90dc7150b9390baff218c15bef7289aca8b61d37020120302204204e9d5498b7776bbb7d1dffe29d730aaee05f4727d187da3836727884d6f8f744"
+
+func TestProofASN1Known(t *testing.T) {
+	data, _ := hex.DecodeString(knownASN1Proof)
+	proof, root, err := UnmarshalASN1Proof(data)
+	if err != nil {
+		t.Fatalf("returned error when unmarshalling known proof: %v", err)
+	}
+	if !VerifyProof(proof, root, []byte("testKey"), []byte("testValue"), sha256.New()) {
+		t.Error("known proof failed to verify")
+	}
+
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	proof, _ = smt.Prove([]byte("testKey"))
+	encoded, err := proof.MarshalASN1(smt.Root())
+	if err != nil {
+		t.Errorf("returned error when marshalling proof: %v", err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Error("proof did not encode to the known DER blob")
+	}
+}
+
+func TestProofASN1RoundTrip(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		smt.Update([]byte(key), []byte("value of "+key))
+	}
+
+	for _, key := range []string{"testKey", "foo", "bar"} {
+		for _, proof := range []func([]byte) (SparseMerkleProof, error){smt.Prove, smt.ProveUpdatable} {
+			proof, _ := proof([]byte(key))
+			data, err := proof.MarshalASN1(smt.Root())
+			if err != nil {
+				t.Errorf("returned error when marshalling proof: %v", err)
+			}
+			decoded, root, err := UnmarshalASN1Proof(data)
+			if err != nil {
+				t.Errorf("returned error when unmarshalling proof: %v", err)
+			}
+			if !reflect.DeepEqual(proof, decoded) || !bytes.Equal(root, smt.Root()) {
+				t.Error("proof did not round trip")
+			}
+		}
+	}
+
+	// A proof on an empty tree has no side nodes.
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	proof, _ := empty.Prove([]byte("testKey"))
+	data, _ := proof.MarshalASN1(empty.Root())
+	decoded, root, err := UnmarshalASN1Proof(data)
+	if err != nil {
+		t.Errorf("returned error when unmarshalling proof: %v", err)
+	}
+	if !VerifyProof(decoded, root, []byte("testKey"), defaultValue, sha256.New()) {
+		t.Error("decoded proof on empty tree failed to verify")
+	}
+}
+
+func TestProofASN1Malformed(t *testing.T) {
+	data, _ := hex.DecodeString(knownASN1Proof)
+	for i := 0; i < len(data); i++ {
+		if _, _, err := UnmarshalASN1Proof(data[:i]); !errors.Is(err, ErrMalformedEncoding) {
+			t.Errorf("did not reject proof truncated to %d bytes", i)
+		}
+	}
+	if _, _, err := UnmarshalASN1Proof(append(data, 0)); !errors.Is(err, ErrMalformedEncoding) {
+		t.Error("did not reject proof with trailing data")
+	}
+
+	// Side nodes must match the key size.
+	proof := SparseMerkleProof{SideNodes: [][]byte{make([]byte, 31)}}
+	data, _ = proof.MarshalASN1(make([]byte, 32))
+	if _, _, err := UnmarshalASN1Proof(data); !errors.Is(err, ErrMalformedEncoding) {
+		t.Error("did not reject proof with a side node of the wrong size")
+	}
+}
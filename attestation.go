@@ -0,0 +1,80 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+)
+
+// AttestedProof is a Merkle proof bundled with the root it was generated
+// against and an attestation of that root, such as a signature or a timestamp.
+//
+// The attestation is opaque to this package: it is produced and checked by
+// callbacks supplied by the caller, so that clients can trust a root without
+// querying the tree.
+type AttestedProof struct {
+	// Root is the root the proof was generated against.
+	Root []byte
+
+	// Proof is the Merkle proof of the key against Root.
+	Proof SparseMerkleProof
+
+	// Attestation is the attestation of Root.
+	Attestation []byte
+}
+
+// ProveAttested generates a Merkle proof for a key against the current root,
+// and attaches the attestation returned by attest for that root.
+func (smt *SparseMerkleTree) ProveAttested(key []byte, attest func(root []byte) []byte) (*AttestedProof, error) {
+	root := smt.Root()
+	proof, err := smt.ProveForRoot(key, root)
+	if err != nil {
+		return nil, err
+	}
+	return &AttestedProof{
+		Root:        root,
+		Proof:       proof,
+		Attestation: attest(root),
+	}, nil
+}
+
+// VerifyAttestedProof verifies the Merkle proof of an attested proof against
+// its root, and its attestation with verifyAttestation. The options must match
+// the ones the tree was built with.
+func VerifyAttestedProof(proof *AttestedProof, key []byte, value []byte, hasher hash.Hash, verifyAttestation func(root []byte, attestation []byte) bool, options ...Option) bool {
+	if !VerifyProof(proof.Proof, proof.Root, key, value, hasher, options...) {
+		return false
+	}
+	return verifyAttestation(proof.Root, proof.Attestation)
+}
+
+// Marshal encodes the attested proof: the root and the attestation, prefixed
+// with their length, followed by the encoding of the proof by
+// SparseMerkleProof.Marshal. It returns ErrBadProof if the side nodes of the
+// proof do not all have the same size.
+func (proof *AttestedProof) Marshal() ([]byte, error) {
+	encoded, err := proof.Proof.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	buf = appendBytes(buf, proof.Root)
+	buf = appendBytes(buf, proof.Attestation)
+	return append(buf, encoded...), nil
+}
+
+// UnmarshalAttestedProof decodes an attested proof encoded by Marshal.
+func UnmarshalAttestedProof(data []byte) (*AttestedProof, error) {
+	var proof AttestedProof
+	var err error
+	r := bytes.NewReader(data)
+	if proof.Root, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if proof.Attestation, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if proof.Proof, err = UnmarshalProof(data[len(data)-r.Len():]); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
@@ -0,0 +1,86 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestAttestedProof(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attest := func(root []byte) []byte {
+		return ed25519.Sign(priv, root)
+	}
+	verifyAttestation := func(root []byte, attestation []byte) bool {
+		return ed25519.Verify(pub, root, attestation)
+	}
+
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	proof, err := smt.ProveAttested([]byte("testKey"), attest)
+	if err != nil {
+		t.Errorf("returned error when proving key: %v", err)
+	}
+	if !VerifyAttestedProof(proof, []byte("testKey"), []byte("testValue"), sha256.New(), verifyAttestation) {
+		t.Error("valid attested proof failed to verify")
+	}
+	if VerifyAttestedProof(proof, []byte("testKey"), []byte("badValue"), sha256.New(), verifyAttestation) {
+		t.Error("invalid attested proof verification returned true")
+	}
+
+	// The proof must be rejected if the attestation does not cover the root.
+	forged := *proof
+	forged.Attestation = attest([]byte("another root"))
+	if VerifyAttestedProof(&forged, []byte("testKey"), []byte("testValue"), sha256.New(), verifyAttestation) {
+		t.Error("attested proof with a bad attestation verified")
+	}
+
+	// Round trip through the binary encoding.
+	data, err := proof.Marshal()
+	if err != nil {
+		t.Errorf("returned error when marshalling proof: %v", err)
+	}
+	decoded, err := UnmarshalAttestedProof(data)
+	if err != nil {
+		t.Errorf("returned error when unmarshalling proof: %v", err)
+	}
+	if !VerifyAttestedProof(decoded, []byte("testKey"), []byte("testValue"), sha256.New(), verifyAttestation) {
+		t.Error("decoded attested proof failed to verify")
+	}
+
+	// Non-membership proofs survive the round trip too.
+	proof, _ = smt.ProveAttested([]byte("testKey3"), attest)
+	data, _ = proof.Marshal()
+	decoded, err = UnmarshalAttestedProof(data)
+	if err != nil {
+		t.Errorf("returned error when unmarshalling proof: %v", err)
+	}
+	if !VerifyAttestedProof(decoded, []byte("testKey3"), defaultValue, sha256.New(), verifyAttestation) {
+		t.Error("decoded non-membership attested proof failed to verify")
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := UnmarshalAttestedProof(data[:i]); err == nil {
+			t.Errorf("did not return an error when unmarshalling truncated proof of length %d", i)
+		}
+	}
+
+	// The proof is encoded like SparseMerkleProof.Marshal, which rejects side
+	// nodes of different sizes.
+	encoded, _ := proof.Proof.Marshal()
+	if !bytes.HasSuffix(data, encoded) {
+		t.Error("did not encode the proof with SparseMerkleProof.Marshal")
+	}
+	proof, _ = smt.ProveAttested([]byte("testKey"), attest)
+	proof.Proof.SideNodes = append(proof.Proof.SideNodes, proof.Proof.SideNodes[0][1:])
+	if _, err := proof.Marshal(); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof when marshalling side nodes of different sizes: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+// Package badgerstore provides an smt.MapStore backed by BadgerDB.
+//
+// The module requires Go 1.24, rather than the Go 1.19 of smt, as does
+// BadgerDB v4.9, which it is built and tested with.
+package badgerstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/memoio/smt"
+)
+
+// Store is an smt.MapStore backed by a BadgerDB database on disk, for trees
+// with a high write throughput.
+//
+// Like smt.SimpleMap, the store keeps a reference count per key: Put increments
+// it and Delete only removes the value once it drops to zero. The count is
+// stored in front of the value.
+//
+// Each call runs in its own Badger transaction unless a transaction was started
+// with Begin, in which case every call joins it until Commit or Discard.
+// Wrapping a tree operation in a transaction commits all of its nodes
+// atomically.
+type Store struct {
+	db *badger.DB
+
+	mu  sync.Mutex
+	txn *badger.Txn
+}
+
+// New opens the BadgerDB database in dir, creating it if it does not exist yet.
+func New(dir string) (*Store, error) {
+	db, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// run calls fn in the current transaction, or in a transaction of its own if
+// there is none.
+func (bs *Store) run(update bool, fn func(txn *badger.Txn) error) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.txn != nil {
+		return fn(bs.txn)
+	}
+	if update {
+		return bs.db.Update(fn)
+	}
+	return bs.db.View(fn)
+}
+
+// badgerRecord gets the record of a key, a reference count followed by the
+// value, or nil if the key does not exist.
+func badgerRecord(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// Get gets the value for a key.
+func (bs *Store) Get(key []byte) ([]byte, error) {
+	var record []byte
+	err := bs.run(false, func(txn *badger.Txn) (err error) {
+		record, err = badgerRecord(txn, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return record[4:], nil
+}
+
+// Put updates the value for a key.
+func (bs *Store) Put(key []byte, value []byte) error {
+	return bs.run(true, func(txn *badger.Txn) error {
+		record, err := badgerRecord(txn, key)
+		if err != nil {
+			return err
+		}
+		var count uint32
+		if record != nil {
+			count = binary.BigEndian.Uint32(record)
+		}
+		updated := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(value)), count+1)
+		return txn.Set(key, append(updated, value...))
+	})
+}
+
+// Has returns true if the key exists in the store.
+func (bs *Store) Has(key []byte) (bool, error) {
+	var has bool
+	err := bs.run(false, func(txn *badger.Txn) error {
+		_, err := txn.Get(key)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		has = err == nil
+		return err
+	})
+	return has, err
+}
+
+// Delete deletes a key.
+func (bs *Store) Delete(key []byte) error {
+	return bs.run(true, func(txn *badger.Txn) error {
+		record, err := badgerRecord(txn, key)
+		if err != nil {
+			return err
+		}
+		if record == nil {
+			return &smt.InvalidKeyError{Key: key}
+		}
+		count := binary.BigEndian.Uint32(record) - 1
+		if count == 0 {
+			return txn.Delete(key)
+		}
+		binary.BigEndian.PutUint32(record, count)
+		return txn.Set(key, record)
+	})
+}
+
+// Size returns the number of keys in the store, counting the keys written
+// and deleted by the transaction in progress, if any.
+func (bs *Store) Size() (int64, error) {
+	var size int64
+	err := bs.run(false, func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.PrefetchValues = false
+		iter := txn.NewIterator(options)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			size++
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ForEachKey calls fn for every key in the store, including the keys written
+// by the transaction in progress, if any, stopping at the first error returned
+// by fn.
+func (bs *Store) ForEachKey(fn func(key []byte) error) error {
+	var keys [][]byte
+	err := bs.run(false, func(txn *badger.Txn) error {
+		options := badger.DefaultIteratorOptions
+		options.PrefetchValues = false
+		iter := txn.NewIterator(options)
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, iter.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// fn is called once the store is released, so that it can read it.
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes the database to disk. Writes of a transaction in progress are
+// not flushed until it is committed.
+func (bs *Store) Sync() error {
+	return bs.db.Sync()
+}
+
+// Begin starts a transaction that all following calls join until Commit or
+// Discard.
+func (bs *Store) Begin() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.txn != nil {
+		return smt.ErrTransactionInProgress
+	}
+	bs.txn = bs.db.NewTransaction(true)
+	return nil
+}
+
+// Commit commits the transaction started by Begin.
+func (bs *Store) Commit() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.txn == nil {
+		return smt.ErrNoTransaction
+	}
+	err := bs.txn.Commit()
+	bs.txn = nil
+	return err
+}
+
+// Discard rolls back the transaction started by Begin, if any.
+func (bs *Store) Discard() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.txn != nil {
+		bs.txn.Discard()
+		bs.txn = nil
+	}
+}
+
+// Close discards any pending transaction and closes the database.
+func (bs *Store) Close() error {
+	bs.Discard()
+	return bs.db.Close()
+}
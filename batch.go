@@ -0,0 +1,311 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrBatchLengthMismatch is returned when a batch has a different number of
+// keys and values.
+var ErrBatchLengthMismatch = errors.New("batch has a different number of keys and values")
+
+// batchOp is the update of a leaf in a batch.
+type batchOp struct {
+	path  []byte
+	key   []byte
+	value []byte
+}
+
+// UpdateBatch sets the values of many keys at once, like calling Update for
+// each key in order, and sets and returns the new root of the tree. Default
+// values delete their keys, and if a key appears several times its last value
+// is kept.
+//
+// The updates are sorted by path and applied in a single traversal, so nodes
+// shared by several keys are read and written once instead of once per key.
+// All keys are checked before the tree is modified.
+func (smt *SparseMerkleTree) UpdateBatch(keys [][]byte, values [][]byte) ([]byte, error) {
+	return smt.UpdateBatchContext(context.Background(), keys, values)
+}
+
+// UpdateBatchContext is like UpdateBatch, but gives up with the error of ctx
+// once it is done, checking it before reading every node. The tree is left
+// unchanged if it gives up, although nodes already written for the batch stay
+// in the node store.
+func (smt *SparseMerkleTree) UpdateBatchContext(ctx context.Context, keys [][]byte, values [][]byte) ([]byte, error) {
+	span := smt.startSpan("smt.UpdateBatch", -1)
+	span.setAttribute(SpanAttrKeys, len(keys))
+	defer span.end()
+
+	if len(keys) != len(values) {
+		return nil, ErrBatchLengthMismatch
+	}
+	ops := make([]batchOp, len(keys))
+	for i, key := range keys {
+		path, err := smt.keyPath(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := smt.th.checkValueSize(values[i]); err != nil {
+			return nil, err
+		}
+		ops[i] = batchOp{path: path, key: key, value: values[i]}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return bytes.Compare(ops[i].path, ops[j].path) < 0
+	})
+	// Keep the last update of every path.
+	deduped := ops[:0]
+	for i, op := range ops {
+		if i+1 < len(ops) && bytes.Equal(op.path, ops[i+1].path) {
+			if smt.detectCollisions && !bytes.Equal(op.key, ops[i+1].key) {
+				return nil, fmt.Errorf("%w: %x and %x", ErrKeyCollision, ops[i+1].key, op.key)
+			}
+			continue
+		}
+		deduped = append(deduped, op)
+	}
+
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	defer smt.observeUpdate()()
+	defer smt.countUpdate(span)()
+
+	for _, op := range deduped {
+		if err := smt.checkCollision(op.path, op.key); err != nil {
+			return nil, err
+		}
+	}
+	newRoot, _, err := smt.updateBatch(ctx, smt.Root(), 0, deduped)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range deduped {
+		if err := smt.indexKey(op.path, op.key, op.value); err != nil {
+			return nil, err
+		}
+	}
+	paths := make([][]byte, len(deduped))
+	for i, op := range deduped {
+		paths[i] = op.path
+	}
+	smt.existence.moved(smt.Root(), newRoot, paths...)
+	smt.SetRoot(newRoot)
+	return newRoot, nil
+}
+
+// DeleteBatch deletes many keys at once, like UpdateBatch with the default
+// value for every key, and sets and returns the new root of the tree. Keys
+// without a value are skipped.
+func (smt *SparseMerkleTree) DeleteBatch(keys [][]byte) ([]byte, error) {
+	values := make([][]byte, len(keys))
+	for i := range values {
+		values[i] = smt.th.defaultValue
+	}
+	return smt.UpdateBatch(keys, values)
+}
+
+// UpdateFromMap sets the value of every key of kv, like UpdateBatch, and sets
+// and returns the new root of the tree. The keys are applied sorted by path,
+// then by key, so the same map always gives the same root and nodes whatever
+// its iteration order.
+func (smt *SparseMerkleTree) UpdateFromMap(kv map[string][]byte) ([]byte, error) {
+	type entry struct {
+		path []byte
+		key  string
+	}
+	entries := make([]entry, 0, len(kv))
+	for key := range kv {
+		path, err := smt.keyPath([]byte(key))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{path: path, key: key})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if c := bytes.Compare(entries[i].path, entries[j].path); c != 0 {
+			return c < 0
+		}
+		return entries[i].key < entries[j].key
+	})
+
+	keys := make([][]byte, len(entries))
+	values := make([][]byte, len(entries))
+	for i, e := range entries {
+		keys[i], values[i] = []byte(e.key), kv[e.key]
+	}
+	return smt.UpdateBatch(keys, values)
+}
+
+// updateBatch applies ops, sorted by path and sharing their first height bits,
+// to the subtree at hash. It returns the new root of the subtree, and whether
+// it is a leaf. There must be at least one op.
+func (smt *SparseMerkleTree) updateBatch(ctx context.Context, hash []byte, height int, ops []batchOp) ([]byte, bool, error) {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return smt.buildBatch(ops, nil, height)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return nil, false, err
+	}
+	if smt.th.isLeaf(data) {
+		// The leaf is kept unless an update of its path replaces it.
+		leafPath, valueHash, _ := smt.th.parseLeaf(data)
+		i := sort.Search(len(ops), func(i int) bool {
+			return bytes.Compare(ops[i].path, leafPath) >= 0
+		})
+		if i < len(ops) && bytes.Equal(ops[i].path, leafPath) {
+			op := ops[i]
+			if smt.th.isDefault(op.value) || !bytes.Equal(smt.th.digestValue(op.value), valueHash) {
+				return smt.buildBatch(ops, nil, height)
+			}
+			// Like Update, nothing is written if the key already has the
+			// value.
+			ops = append(append(make([]batchOp, 0, len(ops)-1), ops[:i]...), ops[i+1:]...)
+		}
+		return smt.buildBatch(ops, &batchLeaf{path: leafPath, hash: hash}, height)
+	}
+
+	split := sort.Search(len(ops), func(i int) bool {
+		return getBitAtFromMSB(ops[i].path, height) == right
+	})
+	leftNode, rightNode := smt.th.parseNode(data)
+	newLeft, leftIsLeaf, err := smt.updateBatchChild(ctx, leftNode, height+1, ops[:split], rightNode)
+	if err != nil {
+		return nil, false, err
+	}
+	newRight, rightIsLeaf, err := smt.updateBatchChild(ctx, rightNode, height+1, ops[split:], newLeft)
+	if err != nil {
+		return nil, false, err
+	}
+	if bytes.Equal(newLeft, leftNode) && bytes.Equal(newRight, rightNode) {
+		// The ops changed nothing, such as deletions of missing keys, so the
+		// node is not written again.
+		return hash, false, nil
+	}
+	if split == 0 && bytes.Equal(newRight, smt.th.placeholder()) {
+		// The untouched left child may now have to be bubbled up.
+		if leftIsLeaf, err = smt.isLeafNode(newLeft); err != nil {
+			return nil, false, err
+		}
+	}
+	return smt.joinBatch(newLeft, leftIsLeaf, newRight, rightIsLeaf)
+}
+
+// updateBatchChild applies ops to the child of a node at hash, like
+// updateBatch. If there are no ops, the child is unchanged, and it is only
+// read to tell whether it is a leaf if its sibling is empty.
+func (smt *SparseMerkleTree) updateBatchChild(ctx context.Context, hash []byte, height int, ops []batchOp, sibling []byte) ([]byte, bool, error) {
+	if len(ops) > 0 {
+		return smt.updateBatch(ctx, hash, height, ops)
+	}
+	if !bytes.Equal(sibling, smt.th.placeholder()) {
+		return hash, false, nil
+	}
+	isLeaf, err := smt.isLeafNode(hash)
+	return hash, isLeaf, err
+}
+
+// isLeafNode returns whether the node at hash is a leaf.
+func (smt *SparseMerkleTree) isLeafNode(hash []byte) (bool, error) {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return false, nil
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return false, err
+	}
+	return smt.th.isLeaf(data), nil
+}
+
+// batchLeaf is a leaf already in the tree that is kept by a batch.
+type batchLeaf struct {
+	path []byte
+	hash []byte
+}
+
+// buildBatch builds the subtree holding the leaves set by ops, sorted by path
+// and sharing their first height bits, and the existing leaf if not nil. It
+// returns the root of the subtree, and whether it is a leaf.
+func (smt *SparseMerkleTree) buildBatch(ops []batchOp, leaf *batchLeaf, height int) ([]byte, bool, error) {
+	// Deletions do not add leaves.
+	for i, op := range ops {
+		if smt.th.isDefault(op.value) {
+			ops = removeDeletions(ops, i, &smt.th)
+			break
+		}
+	}
+	switch {
+	case len(ops) == 0 && leaf == nil:
+		return smt.th.placeholder(), false, nil
+	case len(ops) == 0:
+		return leaf.hash, true, nil
+	case len(ops) == 1 && leaf == nil:
+		currentHash, currentData := smt.th.digestLeaf(ops[0].path, smt.th.digestValue(ops[0].value))
+		if err := smt.putLeaf(currentHash, currentData, ops[0].value); err != nil {
+			return nil, false, err
+		}
+		return currentHash, true, nil
+	}
+
+	split := sort.Search(len(ops), func(i int) bool {
+		return getBitAtFromMSB(ops[i].path, height) == right
+	})
+	leftLeaf, rightLeaf := leaf, leaf
+	if leaf != nil {
+		if getBitAtFromMSB(leaf.path, height) == right {
+			leftLeaf = nil
+		} else {
+			rightLeaf = nil
+		}
+	}
+	newLeft, leftIsLeaf, err := smt.buildBatch(ops[:split], leftLeaf, height+1)
+	if err != nil {
+		return nil, false, err
+	}
+	newRight, rightIsLeaf, err := smt.buildBatch(ops[split:], rightLeaf, height+1)
+	if err != nil {
+		return nil, false, err
+	}
+	return smt.joinBatch(newLeft, leftIsLeaf, newRight, rightIsLeaf)
+}
+
+// joinBatch returns the root of a subtree given the roots of its children, and
+// whether it is a leaf. Like Update, a subtree holding a single leaf has the
+// leaf as its root.
+func (smt *SparseMerkleTree) joinBatch(left []byte, leftIsLeaf bool, right []byte, rightIsLeaf bool) ([]byte, bool, error) {
+	leftEmpty := bytes.Equal(left, smt.th.placeholder())
+	rightEmpty := bytes.Equal(right, smt.th.placeholder())
+	switch {
+	case leftEmpty && rightEmpty:
+		return smt.th.placeholder(), false, nil
+	case leftEmpty && rightIsLeaf:
+		return right, true, nil
+	case rightEmpty && leftIsLeaf:
+		return left, true, nil
+	}
+	currentHash, currentData := smt.th.digestNode(left, right)
+	if err := smt.nodes.Put(currentHash, currentData); err != nil {
+		return nil, false, err
+	}
+	return currentHash, false, nil
+}
+
+// removeDeletions returns a copy of ops without deletions, which set a nil or
+// default value, the first of which is at index i.
+func removeDeletions(ops []batchOp, i int, th *treeHasher) []batchOp {
+	kept := append(make([]batchOp, 0, len(ops)-1), ops[:i]...)
+	for _, op := range ops[i+1:] {
+		if !th.isDefault(op.value) {
+			kept = append(kept, op)
+		}
+	}
+	return kept
+}
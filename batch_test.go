@@ -0,0 +1,262 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateBatch(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	sequential := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	var known [][]byte
+	for round := 0; round < 20; round++ {
+		var keys, values [][]byte
+		for i := 0; i < 1+rand.Intn(50); i++ {
+			var key []byte
+			if len(known) > 0 && rand.Intn(2) == 0 {
+				// Update or delete an existing key.
+				key = known[rand.Intn(len(known))]
+			} else {
+				key = make([]byte, 8)
+				rand.Read(key)
+				known = append(known, key)
+			}
+			value := make([]byte, 1+rand.Intn(16))
+			rand.Read(value)
+			if rand.Intn(4) == 0 {
+				value = defaultValue
+			}
+			keys, values = append(keys, key), append(values, value)
+		}
+
+		root, err := smt.UpdateBatch(keys, values)
+		if err != nil {
+			t.Fatalf("returned error when updating batch: %v", err)
+		}
+		for i := range keys {
+			sequential.Update(keys[i], values[i])
+		}
+		if !bytes.Equal(root, sequential.Root()) || !bytes.Equal(smt.Root(), root) {
+			t.Fatalf("batch root does not match sequential root in round %d", round)
+		}
+	}
+
+	for _, key := range known {
+		value, err := smt.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		expected, _ := sequential.Get(key)
+		if !bytes.Equal(value, expected) {
+			t.Error("did not get correct value after batch updates")
+		}
+	}
+
+	// Deleting every key in a batch empties the tree.
+	root, err := smt.UpdateBatch(known, make([][]byte, len(known)))
+	if err != nil {
+		t.Errorf("returned error when deleting batch: %v", err)
+	}
+	if !bytes.Equal(root, EmptyRoot(sha256.New())) {
+		t.Error("deleting every key in a batch did not empty the tree")
+	}
+
+	// Mismatched batches are rejected without modifying the tree.
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	root = smt.Root()
+	if _, err := smt.UpdateBatch([][]byte{[]byte("testKey2")}, nil); !errors.Is(err, ErrBatchLengthMismatch) {
+		t.Errorf("did not return ErrBatchLengthMismatch for mismatched batch: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("mismatched batch modified the tree")
+	}
+}
+
+// Test that batches changing nothing write nothing, which would add
+// references that are never released.
+func TestUpdateBatchUnchanged(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	keys, values := benchmarkKeys(100)
+	if _, err := smt.UpdateBatch(keys, values); err != nil {
+		t.Fatalf("returned error when updating batch: %v", err)
+	}
+	root := smt.Root()
+	counts := func(sm *SimpleMap) map[string]uint32 {
+		counts := make(map[string]uint32)
+		for key, value := range sm.m {
+			counts[key] = value.count
+		}
+		return counts
+	}
+	nodeCounts, valueCounts := counts(smn), counts(smv)
+
+	// The same values, deletions of missing keys, and both.
+	absent := [][]byte{[]byte("absent"), []byte("absent2")}
+	batches := []struct {
+		keys, values [][]byte
+	}{
+		{keys, values},
+		{absent, make([][]byte, len(absent))},
+		{append(append([][]byte{}, keys...), absent...), append(append([][]byte{}, values...), nil, nil)},
+	}
+	for i, batch := range batches {
+		newRoot, err := smt.UpdateBatch(batch.keys, batch.values)
+		if err != nil {
+			t.Fatalf("returned error when updating batch %d: %v", i, err)
+		}
+		if !bytes.Equal(newRoot, root) {
+			t.Errorf("batch %d changed the root", i)
+		}
+		if !reflect.DeepEqual(counts(smn), nodeCounts) || !reflect.DeepEqual(counts(smv), valueCounts) {
+			t.Errorf("batch %d changed the reference counts of the stores", i)
+		}
+	}
+}
+
+// cancelingStore is a MapStore canceling a context once it has been read from
+// a given number of times.
+type cancelingStore struct {
+	MapStore
+	reads  int
+	cancel context.CancelFunc
+}
+
+func (cs *cancelingStore) Get(key []byte) ([]byte, error) {
+	if cs.reads--; cs.reads == 0 {
+		cs.cancel()
+	}
+	return cs.MapStore.Get(key)
+}
+
+func TestDeleteBatch(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	keys, values := benchmarkKeys(200)
+	smt.Update([]byte("kept"), []byte("keptValue"))
+	keptRoot := smt.Root()
+	if _, err := smt.UpdateBatch(keys, values); err != nil {
+		t.Fatalf("returned error when updating batch: %v", err)
+	}
+
+	// Deleting half of the keys, with absent ones, matches deleting them
+	// one by one.
+	sequential := ImportSparseMerkleTree(smt.nodes, smt.values, sha256.New(), smt.Root())
+	half := append([][]byte{[]byte("absent")}, keys[:100]...)
+	for _, key := range half {
+		if _, err := sequential.Delete(key); err != nil {
+			t.Fatalf("returned error when deleting key: %v", err)
+		}
+	}
+	root, err := smt.DeleteBatch(half)
+	if err != nil {
+		t.Fatalf("returned error when deleting batch: %v", err)
+	}
+	if !bytes.Equal(root, sequential.Root()) || !bytes.Equal(smt.Root(), root) {
+		t.Error("batch root does not match sequential root")
+	}
+
+	// Deleting every key, again, leaves the tree as it was.
+	if root, err = smt.DeleteBatch(keys); err != nil {
+		t.Fatalf("returned error when deleting batch: %v", err)
+	}
+	if !bytes.Equal(root, keptRoot) {
+		t.Error("did not get the root before the batch after deleting it")
+	}
+	smt.Delete([]byte("kept"))
+	if !bytes.Equal(smt.Root(), EmptyRoot(sha256.New())) {
+		t.Error("did not get the empty root after deleting every key")
+	}
+}
+
+func TestUpdateBatchContext(t *testing.T) {
+	nodes := &cancelingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New())
+	keys, values := benchmarkKeys(1000)
+	if _, err := smt.UpdateBatch(keys, values); err != nil {
+		t.Fatalf("returned error when updating batch: %v", err)
+	}
+	root := smt.Root()
+
+	// Cancel the context in the middle of the batch.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes.reads, nodes.cancel = 100, cancel
+	for i := range values {
+		values[i] = []byte("testValue")
+	}
+	if _, err := smt.UpdateBatchContext(ctx, keys, values); !errors.Is(err, context.Canceled) {
+		t.Errorf("did not return context.Canceled when canceled mid-batch: %v", err)
+	}
+	if nodes.reads != 0 {
+		t.Error("batch did not stop reading once canceled")
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("canceled batch changed the root")
+	}
+
+	// Single updates and reads give up too.
+	if _, err := smt.UpdateContext(ctx, keys[0], values[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("did not return context.Canceled when updating key: %v", err)
+	}
+	if _, err := smt.GetContext(ctx, keys[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("did not return context.Canceled when getting key: %v", err)
+	}
+	if value, err := smt.GetContext(context.Background(), keys[0]); err != nil || !bytes.Equal(value, keys[0]) {
+		t.Error("did not get correct value after canceled updates")
+	}
+}
+
+func TestUpdateFromMap(t *testing.T) {
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "testKey" + string(rune('a'+i%26)) + string(rune('a'+i/26))
+	}
+	forward, backward := make(map[string][]byte), make(map[string][]byte)
+	for i := range keys {
+		forward[keys[i]] = []byte("testValue" + keys[i])
+		j := len(keys) - 1 - i
+		backward[keys[j]] = []byte("testValue" + keys[j])
+	}
+
+	smn, smn2 := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+	smt2 := NewSparseMerkleTree(smn2, NewSimpleMap(), sha256.New())
+	root, err := smt.UpdateFromMap(forward)
+	if err != nil {
+		t.Fatalf("returned error when updating from map: %v", err)
+	}
+	root2, err := smt2.UpdateFromMap(backward)
+	if err != nil {
+		t.Fatalf("returned error when updating from map: %v", err)
+	}
+	if !bytes.Equal(root, root2) || !bytes.Equal(root, smt.Root()) {
+		t.Error("insertion orders of the map gave different roots")
+	}
+	if smn.Size() != smn2.Size() {
+		t.Error("insertion orders of the map gave different nodes")
+	}
+
+	sequential := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for key, value := range forward {
+		sequential.Update([]byte(key), value)
+	}
+	if !bytes.Equal(root, sequential.Root()) {
+		t.Error("root differs from sequential updates")
+	}
+
+	// Default values delete their keys.
+	root, err = smt.UpdateFromMap(map[string][]byte{keys[0]: defaultValue})
+	if err != nil {
+		t.Fatalf("returned error when updating from map: %v", err)
+	}
+	sequential.Delete([]byte(keys[0]))
+	if !bytes.Equal(root, sequential.Root()) {
+		t.Error("did not delete key with default value")
+	}
+}
@@ -0,0 +1,187 @@
+package smt
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrNoTransaction is returned by the transactional stores when committing
+	// without a transaction in progress.
+	ErrNoTransaction = errors.New("no transaction in progress")
+	// ErrTransactionInProgress is returned by the transactional stores when
+	// beginning a transaction while another one is in progress.
+	ErrTransactionInProgress = errors.New("transaction already in progress")
+)
+
+// transactionalStore is a MapStore that can group writes in a transaction,
+// like the stores of the sqlstore, leveldbstore, badgerstore and boltstore
+// packages.
+type transactionalStore interface {
+	MapStore
+	Begin() error
+	Commit() error
+	Discard()
+}
+
+// batchStoreOp is a Put or Delete buffered by a BatchStore.
+type batchStoreOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// batchStoreEntry is the buffered state of a key in a BatchStore.
+type batchStoreEntry struct {
+	value []byte // Value of the last Put.
+	delta int    // Number of Puts minus number of Deletes.
+}
+
+// BatchStore is a MapStore buffering the writes to another MapStore in memory
+// until Commit applies them, or Discard drops them. Reads see the buffered
+// writes. Wrapping the stores of a tree in BatchStores and committing after
+// every Update or Delete writes all of its nodes at once.
+//
+// Writes are applied in the order they were made, so that the reference
+// counts of the underlying store end up as with direct writes. As the
+// reference counts of the underlying store are not known, a key deleted more
+// times than it was put since the last Commit reads as missing.
+type BatchStore struct {
+	store MapStore
+
+	mu      sync.RWMutex
+	ops     []batchStoreOp
+	entries map[string]*batchStoreEntry
+}
+
+// NewBatchStore creates a new BatchStore buffering the writes to store.
+func NewBatchStore(store MapStore) *BatchStore {
+	return &BatchStore{
+		store:   store,
+		entries: make(map[string]*batchStoreEntry),
+	}
+}
+
+// Get gets the value for a key.
+func (bs *BatchStore) Get(key []byte) ([]byte, error) {
+	bs.mu.RLock()
+	entry := bs.entries[string(key)]
+	bs.mu.RUnlock()
+
+	switch {
+	case entry == nil || entry.delta == 0:
+		return bs.store.Get(key)
+	case entry.delta > 0:
+		return entry.value, nil
+	}
+	return nil, &InvalidKeyError{Key: key}
+}
+
+// Put updates the value for a key.
+func (bs *BatchStore) Put(key []byte, value []byte) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	entry := bs.entry(key)
+	entry.value = value
+	entry.delta++
+	bs.ops = append(bs.ops, batchStoreOp{key: key, value: value})
+	return nil
+}
+
+// Has returns true if the key exists in the store.
+func (bs *BatchStore) Has(key []byte) (bool, error) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	return bs.has(key)
+}
+
+func (bs *BatchStore) has(key []byte) (bool, error) {
+	entry := bs.entries[string(key)]
+	if entry == nil || entry.delta == 0 {
+		return bs.store.Has(key)
+	}
+	return entry.delta > 0, nil
+}
+
+// Delete deletes a key.
+func (bs *BatchStore) Delete(key []byte) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if has, err := bs.has(key); err != nil {
+		return err
+	} else if !has {
+		return &InvalidKeyError{Key: key}
+	}
+	bs.entry(key).delta--
+	bs.ops = append(bs.ops, batchStoreOp{key: key, delete: true})
+	return nil
+}
+
+// entry returns the buffered state of a key, creating it if needed.
+func (bs *BatchStore) entry(key []byte) *batchStoreEntry {
+	entry, ok := bs.entries[string(key)]
+	if !ok {
+		entry = &batchStoreEntry{}
+		bs.entries[string(key)] = entry
+	}
+	return entry
+}
+
+// Commit applies the buffered writes to the underlying store. If the store
+// supports transactions, like sqlstore.Store, the writes are applied in a
+// single transaction, and on failure none of them are. Otherwise, the writes
+// made before a failure stay applied. The buffer is emptied either way.
+func (bs *BatchStore) Commit() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	ops := bs.ops
+	bs.discard()
+
+	ts, ok := bs.store.(transactionalStore)
+	if ok {
+		if err := ts.Begin(); err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		var err error
+		if op.delete {
+			err = bs.store.Delete(op.key)
+		} else {
+			err = bs.store.Put(op.key, op.value)
+		}
+		if err != nil {
+			if ok {
+				ts.Discard()
+			}
+			return err
+		}
+	}
+	if ok {
+		return ts.Commit()
+	}
+	return nil
+}
+
+// Discard drops the buffered writes.
+func (bs *BatchStore) Discard() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.discard()
+}
+
+func (bs *BatchStore) discard() {
+	bs.ops = nil
+	bs.entries = make(map[string]*batchStoreEntry)
+}
+
+// Close drops the buffered writes and closes the underlying store.
+func (bs *BatchStore) Close() error {
+	bs.Discard()
+	return bs.store.Close()
+}
@@ -0,0 +1,92 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestBatchStore(t *testing.T) {
+	sm := NewSimpleMap()
+	sm.Put([]byte("key"), []byte("hello"))
+	bs := NewBatchStore(sm)
+
+	// Reads see buffered writes.
+	if err := bs.Put([]byte("key2"), []byte("world")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	value, err := bs.Get([]byte("key2"))
+	if err != nil || !bytes.Equal(value, []byte("world")) {
+		t.Error("did not get buffered value")
+	}
+	if err := bs.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	_, err = bs.Get([]byte("key"))
+	var invalidKeyError *InvalidKeyError
+	if !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return an InvalidKeyError when getting a deleted key: %v", err)
+	}
+	if err := bs.Delete([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Error("deleting a key did not return an error on a deleted key")
+	}
+
+	// A discarded batch leaves the underlying store unchanged.
+	bs.Discard()
+	if has, _ := sm.Has([]byte("key2")); has {
+		t.Error("discarded write is visible in the underlying store")
+	}
+	if value, err := bs.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("hello")) {
+		t.Error("discarded delete is visible")
+	}
+}
+
+func TestBatchStoreCommit(t *testing.T) {
+	direct := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	nodes, values := NewSimpleMap(), NewSimpleMap()
+	batchNodes, batchValues := NewBatchStore(nodes), NewBatchStore(values)
+	smt := NewSparseMerkleTree(batchNodes, batchValues, sha256.New())
+
+	for _, key := range []string{"testKey", "testKey2", "testKey3", "testKey4"} {
+		direct.Update([]byte(key), []byte("testValue"))
+		if _, err := smt.Update([]byte(key), []byte("testValue")); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+	direct.Update([]byte("testKey"), []byte("testValue2"))
+	smt.Update([]byte("testKey"), []byte("testValue2"))
+	direct.Delete([]byte("testKey2"))
+	smt.Delete([]byte("testKey2"))
+
+	// Nothing reaches the underlying stores before commit.
+	if nodes.Size() != 0 || values.Size() != 0 {
+		t.Error("writes reached the underlying store before commit")
+	}
+	if err := batchNodes.Commit(); err != nil {
+		t.Errorf("failed to commit nodes: %v", err)
+	}
+	if err := batchValues.Commit(); err != nil {
+		t.Errorf("failed to commit values: %v", err)
+	}
+
+	// A committed batch matches direct writes.
+	if !bytes.Equal(smt.Root(), direct.Root()) {
+		t.Error("did not get the same root as with direct writes")
+	}
+	for _, pair := range []struct {
+		committed, direct *SimpleMap
+	}{
+		{nodes, direct.nodes.(*SimpleMap)},
+		{values, direct.values.(*SimpleMap)},
+	} {
+		if len(pair.committed.m) != len(pair.direct.m) {
+			t.Fatalf("committed store has %d keys, want %d", len(pair.committed.m), len(pair.direct.m))
+		}
+		for key, value := range pair.direct.m {
+			if committed := pair.committed.m[key]; !bytes.Equal(committed.data, value.data) || committed.count != value.count {
+				t.Error("committed store does not match direct writes")
+			}
+		}
+	}
+}
@@ -34,3 +34,110 @@ func BenchmarkSparseMerkleTree_Delete(b *testing.B) {
 		_, _ = smt.Delete([]byte(s))
 	}
 }
+
+func BenchmarkSparseMerkleTree_UpdateExisting(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	_, _ = smt.UpdateBatch(keys, values)
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		_, _ = smt.Update(key, []byte(strconv.Itoa(i)))
+	}
+}
+
+func BenchmarkSparseMerkleTree_Get(b *testing.B) {
+	for _, cache := range []int{0, 1024} {
+		b.Run("cache="+strconv.Itoa(cache), func(b *testing.B) {
+			keys, values := benchmarkKeys(10000)
+			nodes := &countingStore{MapStore: NewSimpleMap()}
+			smt := NewSparseMerkleTree(nodes, NewSimpleMap(), sha256.New(), WithNodeCache(cache))
+			_, _ = smt.UpdateBatch(keys, values)
+			nodes.accesses.Store(0)
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = smt.Get(keys[i%len(keys)])
+			}
+			b.ReportMetric(float64(nodes.accesses.Load())/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func BenchmarkSparseMerkleTree_DeleteSequential10k(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		_, _ = smt.UpdateBatch(keys, values)
+		b.StartTimer()
+		for j := range keys {
+			_, _ = smt.Delete(keys[j])
+		}
+	}
+}
+
+func BenchmarkSparseMerkleTree_DeleteBatch10k(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		_, _ = smt.UpdateBatch(keys, values)
+		b.StartTimer()
+		_, _ = smt.DeleteBatch(keys)
+	}
+}
+
+func BenchmarkSparseMerkleTree_Has(b *testing.B) {
+	for _, cache := range []int{0, 1024} {
+		b.Run("cache="+strconv.Itoa(cache), func(b *testing.B) {
+			keys, values := benchmarkKeys(10000)
+			smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithExistenceCache(cache))
+			_, _ = smt.UpdateBatch(keys, values)
+
+			b.ResetTimer()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = smt.Has(keys[i%1000])
+			}
+		})
+	}
+}
+
+func benchmarkKeys(n int) ([][]byte, [][]byte) {
+	keys, values := make([][]byte, n), make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(strconv.Itoa(i))
+		values[i] = keys[i]
+	}
+	return keys, values
+}
+
+func BenchmarkSparseMerkleTree_UpdateSequential10k(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		for j := range keys {
+			_, _ = smt.Update(keys[j], values[j])
+		}
+	}
+}
+
+func BenchmarkSparseMerkleTree_UpdateBatch10k(b *testing.B) {
+	keys, values := benchmarkKeys(10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		_, _ = smt.UpdateBatch(keys, values)
+	}
+}
@@ -0,0 +1,213 @@
+// Package boltstore provides an smt.MapStore backed by bbolt.
+package boltstore
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/memoio/smt"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store is an smt.MapStore backed by a single bucket of a bbolt database on
+// disk.
+//
+// Like smt.SimpleMap, the store keeps a reference count per key: Put increments
+// it and Delete only removes the value once it drops to zero. The count is
+// stored in front of the value.
+//
+// Each call runs in its own bbolt transaction, and each write thus syncs the
+// database to disk, unless a transaction was started with Begin, in which case
+// every call joins it until Commit or Discard. Wrapping a tree operation in a
+// transaction syncs all of its nodes at once.
+type Store struct {
+	db     *bolt.DB
+	bucket []byte
+
+	mu sync.Mutex
+	tx *bolt.Tx
+}
+
+// New opens the bbolt database at path, creating it if it does not exist yet,
+// and stores keys in the given bucket, also created if needed.
+func New(path, bucket string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db, bucket: []byte(bucket)}, nil
+}
+
+// run calls fn with the bucket in the current transaction, or in a
+// transaction of its own if there is none.
+func (bs *Store) run(update bool, fn func(b *bolt.Bucket) error) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.tx != nil {
+		return fn(bs.tx.Bucket(bs.bucket))
+	}
+	txFn := func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(bs.bucket))
+	}
+	if update {
+		return bs.db.Update(txFn)
+	}
+	return bs.db.View(txFn)
+}
+
+// Get gets the value for a key.
+func (bs *Store) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := bs.run(false, func(b *bolt.Bucket) error {
+		if record := b.Get(key); record != nil {
+			// The record is only valid during the transaction.
+			value = append([]byte{}, record[4:]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return value, nil
+}
+
+// Put updates the value for a key.
+func (bs *Store) Put(key []byte, value []byte) error {
+	return bs.run(true, func(b *bolt.Bucket) error {
+		var count uint32
+		if record := b.Get(key); record != nil {
+			count = binary.BigEndian.Uint32(record)
+		}
+		updated := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(value)), count+1)
+		return b.Put(key, append(updated, value...))
+	})
+}
+
+// Has returns true if the key exists in the store.
+func (bs *Store) Has(key []byte) (bool, error) {
+	var has bool
+	err := bs.run(false, func(b *bolt.Bucket) error {
+		has = b.Get(key) != nil
+		return nil
+	})
+	return has, err
+}
+
+// Delete deletes a key.
+func (bs *Store) Delete(key []byte) error {
+	return bs.run(true, func(b *bolt.Bucket) error {
+		record := b.Get(key)
+		if record == nil {
+			return &smt.InvalidKeyError{Key: key}
+		}
+		count := binary.BigEndian.Uint32(record) - 1
+		if count == 0 {
+			return b.Delete(key)
+		}
+		updated := binary.BigEndian.AppendUint32(make([]byte, 0, len(record)), count)
+		return b.Put(key, append(updated, record[4:]...))
+	})
+}
+
+// Size returns the number of keys in the store, counting the keys written
+// and deleted by the transaction in progress, if any.
+func (bs *Store) Size() (int64, error) {
+	var size int64
+	err := bs.run(false, func(b *bolt.Bucket) error {
+		c := b.Cursor()
+		for key, _ := c.First(); key != nil; key, _ = c.Next() {
+			size++
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ForEachKey calls fn for every key in the store, including the keys written
+// by the transaction in progress, if any, stopping at the first error returned
+// by fn.
+func (bs *Store) ForEachKey(fn func(key []byte) error) error {
+	var keys [][]byte
+	err := bs.run(false, func(b *bolt.Bucket) error {
+		c := b.Cursor()
+		for key, _ := c.First(); key != nil; key, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// fn is called once the store is released, so that it can read it.
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes the database to disk. Writes of a transaction in progress are
+// not flushed until it is committed.
+func (bs *Store) Sync() error {
+	return bs.db.Sync()
+}
+
+// Begin starts a transaction that all following calls join until Commit or
+// Discard.
+func (bs *Store) Begin() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.tx != nil {
+		return smt.ErrTransactionInProgress
+	}
+	tx, err := bs.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	bs.tx = tx
+	return nil
+}
+
+// Commit commits the transaction started by Begin.
+func (bs *Store) Commit() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.tx == nil {
+		return smt.ErrNoTransaction
+	}
+	err := bs.tx.Commit()
+	bs.tx = nil
+	return err
+}
+
+// Discard rolls back the transaction started by Begin, if any.
+func (bs *Store) Discard() {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.tx != nil {
+		bs.tx.Rollback()
+		bs.tx = nil
+	}
+}
+
+// Close discards any pending transaction and closes the database.
+func (bs *Store) Close() error {
+	bs.Discard()
+	return bs.db.Close()
+}
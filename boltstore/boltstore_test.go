@@ -0,0 +1,223 @@
+package boltstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/memoio/smt"
+)
+
+func openTestBoltStore(t *testing.T, path string) *Store {
+	bs, err := New(path, "nodes")
+	if err != nil {
+		t.Fatalf("failed to open bbolt store: %v", err)
+	}
+	return bs
+}
+
+func TestBoltStore(t *testing.T) {
+	bs := openTestBoltStore(t, filepath.Join(t.TempDir(), "tree.db"))
+	defer bs.Close()
+
+	// Tests for Get.
+	_, err := bs.Get([]byte("key"))
+	var invalidKeyError *smt.InvalidKeyError
+	if !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return an smt.InvalidKeyError when getting a non-existent key: %v", err)
+	}
+
+	// Tests for Put.
+	if err := bs.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	value, err := bs.Get([]byte("key"))
+	if err != nil {
+		t.Errorf("getting a key returned an error: %v", err)
+	}
+	if !bytes.Equal(value, []byte("hello")) {
+		t.Error("failed to update key")
+	}
+	has, err := bs.Has([]byte("key"))
+	if err != nil || !has {
+		t.Error("did not find an existing key")
+	}
+
+	// Tests for Delete with reference counting.
+	if err := bs.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if err := bs.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if value, err := bs.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("hello")) {
+		t.Error("key was deleted while still referenced")
+	}
+	if err := bs.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if has, _ := bs.Has([]byte("key")); has {
+		t.Error("failed to delete key")
+	}
+	if err := bs.Delete([]byte("nonexistent")); !errors.As(err, &invalidKeyError) {
+		t.Error("deleting a key did not return an error on a non-existent key")
+	}
+}
+
+func TestBoltStoreTransaction(t *testing.T) {
+	bs := openTestBoltStore(t, filepath.Join(t.TempDir(), "tree.db"))
+	defer bs.Close()
+
+	// A discarded transaction leaves the database untouched.
+	if err := bs.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	if err := bs.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if has, _ := bs.Has([]byte("key")); !has {
+		t.Error("transaction did not see its own write")
+	}
+	bs.Discard()
+	if has, _ := bs.Has([]byte("key")); has {
+		t.Error("discarded write is visible")
+	}
+
+	// A committed transaction applies all of its writes.
+	tree := smt.NewSparseMerkleTree(bs, bs, sha256.New())
+	if err := bs.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	if err := bs.Begin(); err == nil {
+		t.Error("did not return an error when beginning a transaction twice")
+	}
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		if _, err := tree.Update([]byte(key), []byte("testValue")); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+	if _, err := tree.Delete([]byte("testKey3")); err != nil {
+		t.Errorf("returned error when deleting key: %v", err)
+	}
+	if err := bs.Commit(); err != nil {
+		t.Errorf("failed to commit transaction: %v", err)
+	}
+	if err := bs.Commit(); err == nil {
+		t.Error("did not return an error when committing without a transaction")
+	}
+	value, err := tree.Get([]byte("testKey2"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get correct value after commit")
+	}
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree.db")
+	bs := openTestBoltStore(t, path)
+	tree := smt.NewSparseMerkleTree(bs, bs, sha256.New())
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		if _, err := tree.Update([]byte(key), []byte(key+"Value")); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+	root := tree.Root()
+	if err := bs.Close(); err != nil {
+		t.Fatalf("failed to close bbolt store: %v", err)
+	}
+
+	bs = openTestBoltStore(t, path)
+	defer bs.Close()
+	tree, err := smt.OpenSparseMerkleTree(bs, bs, sha256.New(), root)
+	if err != nil {
+		t.Fatalf("failed to reopen tree: %v", err)
+	}
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		value, err := tree.Get([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, []byte(key+"Value")) {
+			t.Error("did not get correct value after reopening the store")
+		}
+	}
+}
+
+func TestBoltStoreSize(t *testing.T) {
+	bs := openTestBoltStore(t, filepath.Join(t.TempDir(), "tree.db"))
+	defer bs.Close()
+
+	var store smt.Sizer = bs
+	checkSize := func(expected int64) {
+		t.Helper()
+		size, err := store.Size()
+		if err != nil {
+			t.Errorf("returned error when getting size: %v", err)
+		}
+		if size != expected {
+			t.Errorf("got size %d, expected %d", size, expected)
+		}
+	}
+	checkSize(0)
+	for _, key := range []string{"key", "key2", "key3"} {
+		bs.Put([]byte(key), []byte("hello"))
+	}
+	// A key put twice is counted once.
+	bs.Put([]byte("key"), []byte("hello"))
+	checkSize(3)
+	bs.Delete([]byte("key"))
+	checkSize(3)
+	bs.Delete([]byte("key"))
+	bs.Delete([]byte("key2"))
+	checkSize(1)
+
+	// Writes and deletes of a transaction are counted before it is committed.
+	if err := bs.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	bs.Put([]byte("key4"), []byte("hello"))
+	bs.Put([]byte("key5"), []byte("hello"))
+	bs.Delete([]byte("key3"))
+	checkSize(2)
+	if err := bs.Commit(); err != nil {
+		t.Errorf("failed to commit transaction: %v", err)
+	}
+	checkSize(2)
+}
+
+func TestBoltStoreGarbageCollect(t *testing.T) {
+	bs := openTestBoltStore(t, filepath.Join(t.TempDir(), "smt.db"))
+	defer bs.Close()
+	tree := smt.NewSparseMerkleTree(bs, bs, sha256.New())
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		tree.Update([]byte(key), []byte(key+"Value"))
+	}
+	tree.Update([]byte("testKey"), []byte("newValue"))
+
+	countKeys := func() int {
+		t.Helper()
+		count := 0
+		if err := bs.ForEachKey(func(key []byte) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatalf("returned error when iterating keys: %v", err)
+		}
+		return count
+	}
+	count := countKeys()
+	freed, err := tree.GarbageCollect([][]byte{tree.Root()})
+	if err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	if freed == 0 || countKeys() != count-freed {
+		t.Errorf("freed %d of %d keys, %d left", freed, count, countKeys())
+	}
+	if value, err := tree.Get([]byte("testKey")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Error("did not keep the current values")
+	}
+}
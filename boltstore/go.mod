@@ -0,0 +1,10 @@
+module github.com/memoio/smt/boltstore
+
+go 1.19
+
+require (
+	github.com/memoio/smt v0.0.0-20261016174908-670095a47cda
+	go.etcd.io/bbolt v1.3.6
+)
+
+require golang.org/x/sys v0.21.0 // indirect
@@ -2,7 +2,9 @@ package smt
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"math/rand"
 	"reflect"
 	"testing"
@@ -76,12 +78,23 @@ func bulkOperations(t *testing.T, operations int, insert int, update int, delete
 
 		bulkCheckAll(t, smt, &kv)
 	}
+
+	// Deleting every key must bring the tree back to the empty root.
+	for k := range kv {
+		_, err := smt.Delete([]byte(k))
+		if err != nil {
+			t.Errorf("error: %v", err)
+		}
+	}
+	if !bytes.Equal(smt.Root(), EmptyRoot(sha256.New())) {
+		t.Error("tree root is not the empty root after deleting all keys")
+	}
 }
 
 func bulkCheckAll(t *testing.T, smt *SparseMerkleTree, kv *map[string]string) {
 	for k, v := range *kv {
 		value, err := smt.Get([]byte(k))
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
 			t.Errorf("error: %v", err)
 		}
 		if !bytes.Equal([]byte(v), value) {
@@ -119,7 +132,7 @@ func bulkCheckAll(t *testing.T, smt *SparseMerkleTree, kv *map[string]string) {
 				largestCommonPrefix = commonPrefix
 			}
 		}
-		sideNodes, _, _, _, err := smt.sideNodesForRoot(smt.th.path([]byte(k)), smt.Root(), false)
+		sideNodes, _, _, _, err := smt.sideNodesForRoot(context.Background(), smt.th.path([]byte(k)), smt.Root(), false)
 		if err != nil {
 			t.Errorf("error: %v", err)
 		}
@@ -0,0 +1,100 @@
+package smt
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash"
+)
+
+// ProofBundle is a Merkle proof bundled with the root it was generated
+// against and the key and value it proves, so that it can be verified on its
+// own.
+//
+// It is encoded to JSON with hex strings:
+//
+//	{
+//	    "root": "...",
+//	    "key": "...",
+//	    "value": "...",
+//	    "sideNodes": ["...", ...],
+//	    "nonMembershipLeafData": "...",
+//	    "siblingData": "..."
+//	}
+//
+// where nonMembershipLeafData and siblingData are omitted when empty.
+type ProofBundle struct {
+	// Root is the root the proof was generated against.
+	Root []byte
+
+	// Key is the proven key.
+	Key []byte
+
+	// Value is the value of Key at Root, the default value if it has none.
+	Value []byte
+
+	// Proof is the Merkle proof of Key against Root.
+	Proof SparseMerkleProof
+}
+
+// ProveBundle generates a Merkle proof for a key against the current root,
+// bundled with the root and the key and its value.
+func (smt *SparseMerkleTree) ProveBundle(key []byte) (*ProofBundle, error) {
+	root := smt.Root()
+	proof, value, err := smt.proveForRoot(key, root, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofBundle{Root: root, Key: key, Value: value, Proof: proof}, nil
+}
+
+// VerifyBundle verifies that the proof of a bundle proves its key to have its
+// value against its root. The options must match the ones the tree was built
+// with.
+func VerifyBundle(bundle *ProofBundle, hasher hash.Hash, options ...Option) bool {
+	return VerifyProof(bundle.Proof, bundle.Root, bundle.Key, bundle.Value, hasher, options...)
+}
+
+// jsonProofBundle is the JSON structure of a ProofBundle.
+type jsonProofBundle struct {
+	Root  hexBytes `json:"root"`
+	Key   hexBytes `json:"key"`
+	Value hexBytes `json:"value"`
+	jsonProof
+}
+
+// MarshalJSON encodes the bundle as JSON.
+func (bundle *ProofBundle) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonProofBundle{
+		Root:      bundle.Root,
+		Key:       bundle.Key,
+		Value:     bundle.Value,
+		jsonProof: newJSONProof(bundle.Proof),
+	})
+}
+
+// UnmarshalJSON decodes a bundle encoded by MarshalJSON. It returns
+// ErrMalformedEncoding if a side node does not have the size of the root.
+//
+// An empty value is decoded as an empty slice rather than nil, as with a
+// custom default value, the empty value is a value of its own.
+func (bundle *ProofBundle) UnmarshalJSON(data []byte) error {
+	var decoded jsonProofBundle
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedEncoding, err)
+	}
+	size := len(decoded.Root)
+	if size == 0 {
+		return fmt.Errorf("%w: missing root", ErrMalformedEncoding)
+	}
+	proof, err := decoded.proof(size)
+	if err != nil {
+		return err
+	}
+	value := []byte(decoded.Value)
+	if value == nil {
+		value = []byte{}
+	}
+
+	*bundle = ProofBundle{Root: decoded.Root, Key: decoded.Key, Value: value, Proof: proof}
+	return nil
+}
@@ -0,0 +1,96 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestProofBundle(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey3"), []byte("testValue3"))
+
+	for _, key := range []string{"testKey", "testKey2", "testKey4"} {
+		bundle, err := smt.ProveBundle([]byte(key))
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		if !bytes.Equal(bundle.Root, smt.Root()) {
+			t.Error("bundle does not hold the current root")
+		}
+		if !VerifyBundle(bundle, sha256.New()) {
+			t.Errorf("bundle of %s failed to verify", key)
+		}
+
+		data, err := json.Marshal(bundle)
+		if err != nil {
+			t.Errorf("returned error when marshalling bundle: %v", err)
+		}
+		var decoded ProofBundle
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Errorf("returned error when unmarshalling bundle: %v", err)
+		}
+		if !bytes.Equal(decoded.Key, bundle.Key) || !bytes.Equal(decoded.Value, bundle.Value) || !bytes.Equal(decoded.Root, bundle.Root) {
+			t.Error("bundle changed through JSON")
+		}
+		if !VerifyBundle(&decoded, sha256.New()) {
+			t.Errorf("decoded bundle of %s failed to verify", key)
+		}
+
+		decoded.Value = []byte("wrongValue")
+		if VerifyBundle(&decoded, sha256.New()) {
+			t.Error("bundle verified with a wrong value")
+		}
+	}
+
+	// Bundles are not valid against later roots.
+	bundle, _ := smt.ProveBundle([]byte("testKey"))
+	smt.Update([]byte("testKey"), []byte("newValue"))
+	bundle.Root = smt.Root()
+	if VerifyBundle(bundle, sha256.New()) {
+		t.Error("bundle verified against a later root")
+	}
+
+	for _, data := range []string{
+		`{"root":"e197","key":"","value":"","sideNodes":["4e9d5498"]}`,
+		`{"root":"","key":"","value":"","sideNodes":[]}`,
+		`{"root":"zz","key":"","value":"","sideNodes":[]}`,
+		`[]`,
+	} {
+		var decoded ProofBundle
+		if err := json.Unmarshal([]byte(data), &decoded); !errors.Is(err, ErrMalformedEncoding) {
+			t.Errorf("did not return ErrMalformedEncoding for malformed JSON %s: %v", data, err)
+		}
+	}
+}
+
+// Test that an empty value, which is not the default value, survives the JSON
+// encoding of a bundle.
+func TestProofBundleEmptyValue(t *testing.T) {
+	defaultValue := WithDefaultValue([]byte("default"))
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), defaultValue)
+	smt.Update([]byte("testKey"), []byte{})
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	for _, key := range []string{"testKey", "testKey3"} {
+		bundle, err := smt.ProveBundle([]byte(key))
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		data, _ := json.Marshal(bundle)
+		var decoded ProofBundle
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Errorf("returned error when unmarshalling bundle: %v", err)
+		}
+		if decoded.Value == nil || !bytes.Equal(decoded.Value, bundle.Value) {
+			t.Errorf("value %q of %s changed to %q through JSON", bundle.Value, key, decoded.Value)
+		}
+		if !VerifyBundle(&decoded, sha256.New(), defaultValue) {
+			t.Errorf("decoded bundle of %s failed to verify", key)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package smt
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// WithNodeCache keeps up to n recently read or written nodes in memory, in
+// front of the node store. The upper levels of the tree are on the way to
+// every key, so reads and updates of many keys mostly find them in the cache
+// instead of reading them from the store.
+//
+// Nodes are keyed by their hash and never change, so the cache only needs to
+// forget the nodes deleted through the tree. Deletions by other trees over the
+// same node store are not seen, so nodes removed by them may still be read.
+func WithNodeCache(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.nodes = NewCachedStore(smt.nodes, n)
+	}
+}
+
+// CachedStore is a MapStore wrapping another one with a bounded least
+// recently used cache of the values read or written. Writes go through to the
+// wrapped store. Keys missing from the wrapped store are only cached if
+// enabled with CacheMisses, and otherwise always looked up again. It is safe
+// for concurrent use if the wrapped store is.
+//
+// The cache only sees the writes made through the CachedStore, so the wrapped
+// store must not be modified otherwise.
+type CachedStore struct {
+	inner    MapStore
+	capacity int
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List // Front is the most recently used.
+	cacheMiss bool
+}
+
+// cacheEntry is a key and its value in a CachedStore.
+type cacheEntry struct {
+	key     string
+	value   []byte
+	missing bool // The key is not in the wrapped store.
+}
+
+// NewCachedStore creates a CachedStore keeping up to capacity keys of inner
+// in memory.
+func NewCachedStore(inner MapStore, capacity int) *CachedStore {
+	return &CachedStore{
+		inner:    inner,
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// CacheMisses sets whether keys missing from the wrapped store are cached, so
+// that looking them up again does not reach the wrapped store. It is disabled
+// by default.
+func (cs *CachedStore) CacheMisses(enabled bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.cacheMiss = enabled
+}
+
+// Get gets the value for a key.
+func (cs *CachedStore) Get(key []byte) ([]byte, error) {
+	if entry, ok := cs.cached(key); ok {
+		if entry.missing {
+			return nil, &InvalidKeyError{Key: key}
+		}
+		return entry.value, nil
+	}
+	value, err := cs.inner.Get(key)
+	var invalidKey *InvalidKeyError
+	if errors.As(err, &invalidKey) {
+		cs.addMissing(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cs.add(key, value)
+	return value, nil
+}
+
+// Put updates the value for a key.
+func (cs *CachedStore) Put(key []byte, value []byte) error {
+	if err := cs.inner.Put(key, value); err != nil {
+		cs.remove(key)
+		return err
+	}
+	cs.add(key, value)
+	return nil
+}
+
+// Has returns whether a key has a value.
+func (cs *CachedStore) Has(key []byte) (bool, error) {
+	if entry, ok := cs.cached(key); ok {
+		return !entry.missing, nil
+	}
+	return cs.inner.Has(key)
+}
+
+// Delete deletes a key.
+func (cs *CachedStore) Delete(key []byte) error {
+	// The key may still be in the store after the deletion, if it was put
+	// several times, so it is read again from the store next time.
+	cs.remove(key)
+	return cs.inner.Delete(key)
+}
+
+// Sync syncs the wrapped store, if it is a Syncer.
+func (cs *CachedStore) Sync() error {
+	if syncer, ok := cs.inner.(Syncer); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// Unwrap returns the wrapped store.
+func (cs *CachedStore) Unwrap() MapStore {
+	return cs.inner
+}
+
+// Close closes the wrapped store.
+func (cs *CachedStore) Close() error {
+	return cs.inner.Close()
+}
+
+// cached returns the cache entry of key, if any, marking it as recently used.
+func (cs *CachedStore) cached(key []byte) (cacheEntry, bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	element, ok := cs.entries[string(key)]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	cs.order.MoveToFront(element)
+	return *element.Value.(*cacheEntry), true
+}
+
+// add caches the value of key.
+func (cs *CachedStore) add(key []byte, value []byte) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.addEntry(cacheEntry{key: string(key), value: value})
+}
+
+// addMissing caches that key is missing, if enabled.
+func (cs *CachedStore) addMissing(key []byte) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.cacheMiss {
+		cs.addEntry(cacheEntry{key: string(key), missing: true})
+	}
+}
+
+// addEntry caches entry, evicting the least recently used key if the cache is
+// full. cs.mu must be held.
+func (cs *CachedStore) addEntry(entry cacheEntry) {
+	if element, ok := cs.entries[entry.key]; ok {
+		*element.Value.(*cacheEntry) = entry
+		cs.order.MoveToFront(element)
+		return
+	}
+	if cs.capacity <= 0 {
+		return
+	}
+	if cs.order.Len() >= cs.capacity {
+		oldest := cs.order.Back()
+		cs.order.Remove(oldest)
+		delete(cs.entries, oldest.Value.(*cacheEntry).key)
+	}
+	cs.entries[entry.key] = cs.order.PushFront(&entry)
+}
+
+// remove forgets the cache entry of key, if any.
+func (cs *CachedStore) remove(key []byte) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if element, ok := cs.entries[string(key)]; ok {
+		cs.order.Remove(element)
+		delete(cs.entries, string(key))
+	}
+}
+
+// WithExistenceCache keeps whether up to n recently checked keys have a
+// value, so that Has answers repeated checks of the same keys without walking
+// the tree. The cache follows the current root: updates through the tree
+// forget the keys they set and delete, and any other change of the root, such
+// as SetRoot, empties it.
+func WithExistenceCache(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.existence = newExistenceCache(n)
+	}
+}
+
+// existenceCache caches whether paths have a leaf at a root. A nil
+// existenceCache caches nothing.
+type existenceCache struct {
+	capacity int
+
+	mu    sync.Mutex
+	root  []byte
+	paths *CachedStore // Paths with a leaf are cached, others missing.
+}
+
+func newExistenceCache(capacity int) *existenceCache {
+	return &existenceCache{
+		capacity: capacity,
+		paths:    NewCachedStore(NullStore{}, capacity),
+	}
+}
+
+// clone returns an empty cache of the same capacity.
+func (ec *existenceCache) clone() *existenceCache {
+	if ec == nil {
+		return nil
+	}
+	return newExistenceCache(ec.capacity)
+}
+
+// lookup returns whether path has a leaf at root, and whether it is cached.
+func (ec *existenceCache) lookup(root, path []byte) (bool, bool) {
+	if ec == nil {
+		return false, false
+	}
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if !bytes.Equal(ec.root, root) {
+		return false, false
+	}
+	entry, ok := ec.paths.cached(path)
+	return !entry.missing, ok
+}
+
+// add caches whether path has a leaf at root, emptying the cache first if it
+// is for another root.
+func (ec *existenceCache) add(root, path []byte, present bool) {
+	if ec == nil {
+		return
+	}
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if !bytes.Equal(ec.root, root) {
+		ec.reset(root)
+	}
+	ec.paths.mu.Lock()
+	defer ec.paths.mu.Unlock()
+	ec.paths.addEntry(cacheEntry{key: string(path), missing: !present})
+}
+
+// moved moves the cache from oldRoot to newRoot, set from it by updating the
+// leaves at paths, which are forgotten. The cache is emptied if it was not at
+// oldRoot.
+func (ec *existenceCache) moved(oldRoot, newRoot []byte, paths ...[]byte) {
+	if ec == nil {
+		return
+	}
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if !bytes.Equal(ec.root, oldRoot) {
+		ec.reset(newRoot)
+		return
+	}
+	for _, path := range paths {
+		ec.paths.remove(path)
+	}
+	ec.root = append([]byte(nil), newRoot...)
+}
+
+// reset empties the cache and sets its root. ec.mu must be held.
+func (ec *existenceCache) reset(root []byte) {
+	ec.root = append([]byte(nil), root...)
+	ec.paths = NewCachedStore(NullStore{}, ec.capacity)
+}
@@ -0,0 +1,199 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestWithNodeCache(t *testing.T) {
+	counted := &countingStore{MapStore: NewSimpleMap()}
+	cached := NewSparseMerkleTree(counted, NewSimpleMap(), sha256.New(), WithNodeCache(64))
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	var roots [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i % 70))
+		value := []byte("testValue" + strconv.Itoa(i))
+		if i%7 == 0 {
+			value = defaultValue
+		}
+		cached.Update(key, value)
+		plain.Update(key, value)
+		if !bytes.Equal(cached.Root(), plain.Root()) {
+			t.Fatalf("cached root differs after update %d", i)
+		}
+		roots = append(roots, cached.Root())
+	}
+
+	// Pruning deletes nodes from the cache too.
+	if err := cached.RemovePath([]byte("1"), roots[50], cached.Root()); err != nil {
+		t.Fatalf("returned error when removing path: %v", err)
+	}
+	plain.RemovePath([]byte("1"), roots[50], plain.Root())
+	if _, err := cached.GetFromRoot([]byte("1"), roots[50]); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return store error when getting key at pruned root: %v", err)
+	}
+
+	before := counted.accesses.Load()
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 70; i++ {
+			key := []byte(strconv.Itoa(i))
+			value, err := cached.Get(key)
+			expected, expectedErr := plain.Get(key)
+			if !errors.Is(err, expectedErr) || !bytes.Equal(value, expected) {
+				t.Errorf("got %q, %v from cached tree, expected %q, %v", value, err, expected, expectedErr)
+			}
+			proof, _ := cached.Prove(key)
+			expectedProof, _ := plain.Prove(key)
+			encoded, _ := proof.Marshal()
+			expectedEncoded, _ := expectedProof.Marshal()
+			if !bytes.Equal(encoded, expectedEncoded) {
+				t.Error("got different proofs from cached tree")
+			}
+		}
+	}
+	reads := counted.accesses.Load() - before
+
+	uncounted := &countingStore{MapStore: counted.MapStore}
+	uncached := ImportSparseMerkleTree(uncounted, cached.values, sha256.New(), cached.Root())
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 70; i++ {
+			key := []byte(strconv.Itoa(i))
+			uncached.Get(key)
+			uncached.Prove(key)
+		}
+	}
+	if reads*2 > uncounted.accesses.Load() {
+		t.Errorf("read %d nodes with cache, not much less than %d without", reads, uncounted.accesses.Load())
+	}
+}
+
+func TestWithExistenceCache(t *testing.T) {
+	counted := &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(counted, NewSimpleMap(), sha256.New(), WithExistenceCache(16))
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+
+	check := func(key string, expected bool) {
+		t.Helper()
+		has, err := smt.Has([]byte(key))
+		if err != nil || has != expected {
+			t.Errorf("got %v, %v for key %s, expected %v", has, err, key, expected)
+		}
+	}
+	check("1", true)
+	check("2", true)
+	check("20", false)
+	before := counted.accesses.Load()
+	check("1", true)
+	check("20", false)
+	if counted.accesses.Load() != before {
+		t.Error("read nodes to check cached keys")
+	}
+
+	// Updates forget the keys they touch and keep the others.
+	smt.Delete([]byte("1"))
+	smt.Update([]byte("20"), []byte("testValue"))
+	check("1", false)
+	check("20", true)
+	smt.UpdateBatch([][]byte{[]byte("1"), []byte("20")}, [][]byte{[]byte("testValue"), defaultValue})
+	check("1", true)
+	check("20", false)
+	smt.UpdateByPath(smt.Path([]byte("1")), defaultValue)
+	check("1", false)
+	before = counted.accesses.Load()
+	check("2", true)
+	if counted.accesses.Load() != before {
+		t.Error("read nodes to check key cached before unrelated updates")
+	}
+
+	// Other changes of the root empty the cache.
+	root := smt.Root()
+	smt.Update([]byte("2"), defaultValue)
+	check("2", false)
+	smt.SetRoot(root)
+	check("2", true)
+
+	clone := smt.Clone()
+	clone.Update([]byte("2"), defaultValue)
+	check("2", true)
+	if has, _ := clone.Has([]byte("2")); has {
+		t.Error("clone got key deleted from the clone")
+	}
+}
+
+func TestCachedStoreEviction(t *testing.T) {
+	inner := &countingStore{MapStore: NewSimpleMap()}
+	store := NewCachedStore(inner, 2)
+	for _, key := range []string{"a", "b", "c"} {
+		store.Put([]byte(key), []byte("value"+key))
+	}
+	// Only the two most recent keys are cached.
+	before := inner.accesses.Load()
+	store.Get([]byte("b"))
+	store.Get([]byte("c"))
+	if inner.accesses.Load() != before {
+		t.Error("read recent keys from the inner store")
+	}
+	if value, _ := store.Get([]byte("a")); !bytes.Equal(value, []byte("valuea")) || inner.accesses.Load() != before+1 {
+		t.Error("did not read evicted key from the inner store")
+	}
+
+	// Deleted keys are forgotten, even if still in the inner store.
+	store.Put([]byte("a"), []byte("valuea"))
+	store.Delete([]byte("a"))
+	if has, _ := store.Has([]byte("a")); !has {
+		t.Error("did not have key put twice and deleted once")
+	}
+	store.Delete([]byte("a"))
+	var invalidKey *InvalidKeyError
+	if _, err := store.Get([]byte("a")); !errors.As(err, &invalidKey) {
+		t.Errorf("did not return InvalidKeyError for deleted key: %v", err)
+	}
+}
+
+func TestCachedStoreMisses(t *testing.T) {
+	inner := &countingStore{MapStore: NewSimpleMap()}
+	store := NewCachedStore(inner, 8)
+	var invalidKey *InvalidKeyError
+
+	// Misses are looked up again by default.
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get([]byte("a")); !errors.As(err, &invalidKey) {
+			t.Errorf("did not return InvalidKeyError for missing key: %v", err)
+		}
+	}
+	if inner.accesses.Load() != 2 {
+		t.Error("cached a missing key")
+	}
+
+	store.CacheMisses(true)
+	for i := 0; i < 2; i++ {
+		if _, err := store.Get([]byte("a")); !errors.As(err, &invalidKey) {
+			t.Errorf("did not return InvalidKeyError for missing key: %v", err)
+		}
+		if has, _ := store.Has([]byte("a")); has {
+			t.Error("had missing key")
+		}
+	}
+	if inner.accesses.Load() != 3 {
+		t.Error("did not cache a missing key")
+	}
+
+	// Writes replace cached misses.
+	store.Put([]byte("a"), []byte("valuea"))
+	if value, err := store.Get([]byte("a")); err != nil || !bytes.Equal(value, []byte("valuea")) {
+		t.Error("did not get value put over a cached miss")
+	}
+	store.Delete([]byte("a"))
+	if _, err := store.Get([]byte("a")); !errors.As(err, &invalidKey) {
+		t.Errorf("did not return InvalidKeyError for deleted key: %v", err)
+	}
+	if has, _ := inner.Has([]byte("a")); has {
+		t.Error("did not delete key from the inner store")
+	}
+}
@@ -15,9 +15,9 @@ type DeepSparseMerkleSubTree struct {
 }
 
 // NewDeepSparseMerkleSubTree creates a new deep Sparse Merkle subtree on an empty MapStore.
-func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root []byte) *DeepSparseMerkleSubTree {
+func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) *DeepSparseMerkleSubTree {
 	return &DeepSparseMerkleSubTree{
-		SparseMerkleTree: ImportSparseMerkleTree(nodes, values, hasher, root),
+		SparseMerkleTree: ImportSparseMerkleTree(nodes, values, hasher, root, options...),
 	}
 }
 
@@ -28,15 +28,26 @@ func NewDeepSparseMerkleSubTree(nodes, values MapStore, hasher hash.Hash, root [
 // If the leaf may be updated (e.g. during a state transition fraud proof),
 // an updatable proof should be used. See SparseMerkleTree.ProveUpdatable.
 func (dsmst *DeepSparseMerkleSubTree) AddBranch(proof SparseMerkleProof, key []byte, value []byte) error {
-	result, updates := verifyProofWithUpdates(proof, dsmst.Root(), key, value, dsmst.th.hasher)
+	if _, err := dsmst.keyPath(key); err != nil {
+		return err
+	}
+	if err := dsmst.th.checkValueSize(value); err != nil {
+		return err
+	}
+	dsmst.mu.Lock()
+	defer dsmst.mu.Unlock()
+
+	result, updates := verifyProofWithUpdates(proof, dsmst.Root(), key, value, &dsmst.th)
 	if !result {
 		return ErrBadProof
 	}
 
-	if !bytes.Equal(value, defaultValue) { // Membership proof.
-		if err := dsmst.values.Put(dsmst.th.path(key), value); err != nil {
+	if !dsmst.th.isDefault(value) { // Membership proof.
+		// The first update is the leaf of the proven key.
+		if err := dsmst.putLeaf(updates[0][0], updates[0][1], value); err != nil {
 			return err
 		}
+		updates = updates[1:]
 	}
 
 	// Update nodes along branch
@@ -69,13 +80,20 @@ func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 
 	if bytes.Equal(root, smt.th.placeholder()) {
 		// The tree is empty, return the default value.
-		return defaultValue, nil
+		return smt.th.defaultValue, nil
 	}
 
-	path := smt.th.path(key)
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
 	currentHash := root
 	for i := 0; i < smt.depth(); i++ {
-		currentData, err := smt.nodes.Get(currentHash)
+		currentData, inlineValue, err := smt.getNodeWithValue(currentHash)
 		if err != nil {
 			return nil, err
 		} else if smt.th.isLeaf(currentData) {
@@ -83,14 +101,10 @@ func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 			p, _, _ := smt.th.parseLeaf(currentData)
 			if !bytes.Equal(path, p) {
 				// Nope. Therefore the key is actually empty.
-				return defaultValue, nil
+				return smt.th.defaultValue, nil
 			}
 			// Otherwise, yes. Return the value.
-			value, err := smt.values.Get(path)
-			if err != nil {
-				return nil, err
-			}
-			return value, nil
+			return smt.leafValue(currentData, inlineValue)
 		}
 
 		leftNode, rightNode := smt.th.parseNode(currentData)
@@ -102,18 +116,18 @@ func (smt *SparseMerkleTree) GetDescend(key []byte) ([]byte, error) {
 
 		if bytes.Equal(currentHash, smt.th.placeholder()) {
 			// We've hit a placeholder value; this is the end.
-			return defaultValue, nil
+			return smt.th.defaultValue, nil
 		}
 	}
 
 	// The following lines of code should only be reached if the path is 256
 	// nodes high, which should be very unlikely if the underlying hash function
 	// is collision-resistant.
-	value, err := smt.values.Get(path)
+	currentData, inlineValue, err := smt.getNodeWithValue(currentHash)
 	if err != nil {
 		return nil, err
 	}
-	return value, nil
+	return smt.leafValue(currentData, inlineValue)
 }
 
 // HasDescend returns true if the value at the given key is non-default, false
@@ -125,5 +139,5 @@ func (smt *SparseMerkleTree) HasDescend(key []byte) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return !bytes.Equal(defaultValue, val), nil
+	return !bytes.Equal(smt.th.defaultValue, val), nil
 }
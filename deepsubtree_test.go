@@ -65,12 +65,9 @@ func TestDeepSparseMerkleSubTreeBasic(t *testing.T) {
 	if !bytes.Equal(value, []byte("testValue2")) {
 		t.Error("did not get correct value in deep subtree")
 	}
-	value, err = dsmst.Get([]byte("testKey5"))
-	if err != nil {
-		t.Errorf("returned error when getting value in deep subtree: %v", err)
-	}
-	if !bytes.Equal(value, defaultValue) {
-		t.Error("did not get correct value in deep subtree")
+	_, err = dsmst.Get([]byte("testKey5"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting empty key in deep subtree: %v", err)
 	}
 	value, err = dsmst.GetDescend([]byte("testKey5"))
 	if err != nil {
@@ -104,12 +101,9 @@ func TestDeepSparseMerkleSubTreeBasic(t *testing.T) {
 	if !bytes.Equal(value, []byte("testValue3")) {
 		t.Error("did not get correct value in deep subtree")
 	}
-	value, err = dsmst.Get([]byte("testKey2"))
-	if err != nil {
-		t.Error("returned error when getting value in deep subtree")
-	}
-	if !bytes.Equal(value, defaultValue) {
-		t.Error("did not get correct value in deep subtree")
+	_, err = dsmst.Get([]byte("testKey2"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting empty key in deep subtree: %v", err)
 	}
 	value, err = dsmst.Get([]byte("testKey5"))
 	if err != nil {
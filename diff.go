@@ -0,0 +1,113 @@
+package smt
+
+import (
+	"bytes"
+)
+
+// Diff returns the paths of the keys whose values differ between rootA and
+// rootB, two roots of the tree, in path order. Keys set in only one of the
+// roots are included.
+//
+// Both roots are descended together, skipping the subtrees they share, so
+// only the nodes on the paths of the differing keys are read.
+func (smt *SparseMerkleTree) Diff(rootA, rootB []byte) ([][]byte, error) {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(rootA)()
+	defer smt.reads.read(rootB)()
+
+	var changed [][]byte
+	if err := smt.diff(rootA, rootB, &changed); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// diff appends the paths of the leaves that differ between the subtrees at
+// hashA and hashB, at the same height, to changed.
+func (smt *SparseMerkleTree) diff(hashA, hashB []byte, changed *[][]byte) error {
+	if bytes.Equal(hashA, hashB) {
+		return nil
+	}
+	dataA, err := smt.diffNode(hashA)
+	if err != nil {
+		return err
+	}
+	dataB, err := smt.diffNode(hashB)
+	if err != nil {
+		return err
+	}
+
+	if dataA != nil && dataB != nil && !smt.th.isLeaf(dataA) && !smt.th.isLeaf(dataB) {
+		leftA, rightA := smt.th.parseNode(dataA)
+		leftB, rightB := smt.th.parseNode(dataB)
+		if err := smt.diff(leftA, leftB, changed); err != nil {
+			return err
+		}
+		return smt.diff(rightA, rightB, changed)
+	}
+
+	// One of the subtrees is empty or a leaf, which may be deeper in the other
+	// subtree, so compare all of their leaves.
+	leavesA, err := smt.diffLeaves(dataA, nil)
+	if err != nil {
+		return err
+	}
+	leavesB, err := smt.diffLeaves(dataB, nil)
+	if err != nil {
+		return err
+	}
+	for len(leavesA) > 0 || len(leavesB) > 0 {
+		var pathA, pathB []byte
+		if len(leavesA) > 0 {
+			pathA, _, _ = smt.th.parseLeaf(leavesA[0])
+		}
+		if len(leavesB) > 0 {
+			pathB, _, _ = smt.th.parseLeaf(leavesB[0])
+		}
+		switch c := bytes.Compare(pathA, pathB); {
+		case pathB == nil || (pathA != nil && c < 0):
+			*changed = append(*changed, pathA)
+			leavesA = leavesA[1:]
+		case pathA == nil || c > 0:
+			*changed = append(*changed, pathB)
+			leavesB = leavesB[1:]
+		default:
+			if !bytes.Equal(leavesA[0], leavesB[0]) {
+				*changed = append(*changed, pathA)
+			}
+			leavesA, leavesB = leavesA[1:], leavesB[1:]
+		}
+	}
+	return nil
+}
+
+// diffNode returns the data of the node at hash, or nil for a placeholder.
+func (smt *SparseMerkleTree) diffNode(hash []byte) ([]byte, error) {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil, nil
+	}
+	return smt.getNode(hash)
+}
+
+// diffLeaves appends the data of the leaves of the subtree with root data data,
+// nil for an empty subtree, to leaves in path order.
+func (smt *SparseMerkleTree) diffLeaves(data []byte, leaves [][]byte) ([][]byte, error) {
+	if data == nil {
+		return leaves, nil
+	}
+	if smt.th.isLeaf(data) {
+		return append(leaves, data), nil
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	for _, child := range [][]byte{leftNode, rightNode} {
+		childData, err := smt.diffNode(child)
+		if err != nil {
+			return nil, err
+		}
+		if leaves, err = smt.diffLeaves(childData, leaves); err != nil {
+			return nil, err
+		}
+	}
+	return leaves, nil
+}
@@ -0,0 +1,67 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+	oldRoot := smt.Root()
+
+	changed, err := smt.Diff(oldRoot, oldRoot)
+	if err != nil {
+		t.Fatalf("returned error when diffing roots: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Error("found differences between a root and itself")
+	}
+
+	// Update, add and delete keys.
+	var expected [][]byte
+	for _, i := range []int{3, 17, 42, 99, 100, 150} {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, []byte("newValue"))
+		expected = append(expected, smt.th.path(key))
+	}
+	for _, i := range []int{0, 64} {
+		key := []byte(strconv.Itoa(i))
+		smt.Delete(key)
+		expected = append(expected, smt.th.path(key))
+	}
+	// Setting a key to its value changes nothing.
+	smt.Update([]byte("50"), []byte("testValue"))
+	sort.Slice(expected, func(i, j int) bool {
+		return bytes.Compare(expected[i], expected[j]) < 0
+	})
+
+	for _, roots := range [][2][]byte{{oldRoot, smt.Root()}, {smt.Root(), oldRoot}} {
+		changed, err = smt.Diff(roots[0], roots[1])
+		if err != nil {
+			t.Fatalf("returned error when diffing roots: %v", err)
+		}
+		if len(changed) != len(expected) {
+			t.Fatalf("found %d changed paths, expected %d", len(changed), len(expected))
+		}
+		for i := range changed {
+			if !bytes.Equal(changed[i], expected[i]) {
+				t.Errorf("got changed path %x, expected %x", changed[i], expected[i])
+			}
+		}
+	}
+
+	// Against the empty root, every key differs.
+	changed, err = smt.Diff(EmptyRoot(sha256.New()), smt.Root())
+	if err != nil {
+		t.Fatalf("returned error when diffing roots: %v", err)
+	}
+	if len(changed) != 100 {
+		t.Errorf("found %d changed paths against the empty root, expected 100", len(changed))
+	}
+}
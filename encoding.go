@@ -0,0 +1,99 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrMalformedEncoding is returned when decoding bytes that were not produced
+// by the matching encoder.
+var ErrMalformedEncoding = errors.New("malformed encoding")
+
+// appendBytes appends b to buf, prefixed with its length as a uvarint.
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// readBytes reads a length-prefixed byte slice written by appendBytes. Empty
+// slices are read back as nil.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readLength(r)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := r.Read(b); err != nil {
+		return nil, ErrMalformedEncoding
+	}
+	return b, nil
+}
+
+// readLength reads a uvarint length, rejecting lengths that exceed the
+// remaining input so that malformed input cannot cause large allocations.
+func readLength(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return 0, ErrMalformedEncoding
+	}
+	return int(n), nil
+}
+
+// Marshal encodes the proof with fixed-size side nodes: the size of the side
+// nodes and their number as uvarints, followed by the side nodes, then the
+// non-membership leaf data and the sibling data, prefixed with their length.
+// The size is zero if there are no side nodes. It returns ErrBadProof if the
+// side nodes do not all have the same size.
+func (proof *SparseMerkleProof) Marshal() ([]byte, error) {
+	var size int
+	if len(proof.SideNodes) > 0 {
+		size = len(proof.SideNodes[0])
+	}
+	buf := binary.AppendUvarint(nil, uint64(size))
+	buf = binary.AppendUvarint(buf, uint64(len(proof.SideNodes)))
+	for _, sideNode := range proof.SideNodes {
+		if len(sideNode) != size || size == 0 {
+			return nil, ErrBadProof
+		}
+		buf = append(buf, sideNode...)
+	}
+	buf = appendBytes(buf, proof.NonMembershipLeafData)
+	return appendBytes(buf, proof.SiblingData), nil
+}
+
+// UnmarshalProof decodes a proof encoded by SparseMerkleProof.Marshal,
+// returning ErrMalformedEncoding if it is malformed, e.g. if it has more side
+// nodes than a tree with their size can have.
+func UnmarshalProof(data []byte) (SparseMerkleProof, error) {
+	var proof SparseMerkleProof
+	r := bytes.NewReader(data)
+	size, err := readLength(r)
+	if err != nil {
+		return proof, err
+	}
+	numSideNodes, err := readLength(r)
+	if err != nil {
+		return proof, err
+	}
+	if numSideNodes > 0 {
+		if size == 0 || numSideNodes > 8*size || numSideNodes*size > r.Len() {
+			return proof, ErrMalformedEncoding
+		}
+		proof.SideNodes = make([][]byte, numSideNodes)
+	}
+	for i := range proof.SideNodes {
+		proof.SideNodes[i] = make([]byte, size)
+		r.Read(proof.SideNodes[i])
+	}
+	if proof.NonMembershipLeafData, err = readBytes(r); err != nil {
+		return proof, err
+	}
+	if proof.SiblingData, err = readBytes(r); err != nil {
+		return proof, err
+	}
+	if r.Len() != 0 {
+		return proof, ErrMalformedEncoding
+	}
+	return proof, nil
+}
@@ -0,0 +1,34 @@
+package smt
+
+// Flush makes the writes made to the stores of the tree so far durable, by
+// syncing the stores that are Syncers, under the wrappers added by options
+// like WithNodeCache. Each store is synced once, even if it holds both nodes
+// and values.
+func (smt *SparseMerkleTree) Flush() error {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	var synced []MapStore
+	for _, store := range []MapStore{smt.nodes, smt.values, smt.keyIndex} {
+		store = unwrapStore(store)
+		syncer, ok := store.(Syncer)
+		if !ok || containsStore(synced, store) {
+			continue
+		}
+		if err := syncer.Sync(); err != nil {
+			return err
+		}
+		synced = append(synced, store)
+	}
+	return nil
+}
+
+// containsStore reports whether stores contains store.
+func containsStore(stores []MapStore, store MapStore) bool {
+	for _, s := range stores {
+		if sameStore(s, store) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+// syncingStore is a MapStore counting the calls to Sync.
+type syncingStore struct {
+	MapStore
+	syncs int
+	err   error
+}
+
+func (ss *syncingStore) Sync() error {
+	ss.syncs++
+	return ss.err
+}
+
+func TestFlush(t *testing.T) {
+	store := &syncingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(store, store, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if store.syncs != 0 {
+		t.Error("synced the store before Flush")
+	}
+	if err := smt.Flush(); err != nil {
+		t.Errorf("returned error when flushing: %v", err)
+	}
+	if store.syncs != 1 {
+		t.Errorf("synced the store %d times, expected once", store.syncs)
+	}
+
+	// Separate stores are synced once each, through a node cache.
+	nodes, values := &syncingStore{MapStore: NewSimpleMap()}, &syncingStore{MapStore: NewSimpleMap()}
+	smt = NewSparseMerkleTree(nodes, values, sha256.New(), WithNodeCache(16), WithKeyIndex(NewSimpleMap()))
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	if err := smt.Flush(); err != nil {
+		t.Errorf("returned error when flushing: %v", err)
+	}
+	if nodes.syncs != 1 || values.syncs != 1 {
+		t.Errorf("synced the stores %d and %d times, expected once", nodes.syncs, values.syncs)
+	}
+
+	errSync := errors.New("sync failed")
+	values.err = errSync
+	if err := smt.Flush(); !errors.Is(err, errSync) {
+		t.Errorf("did not return the sync error: %v", err)
+	}
+}
+
+func TestFlushWrappedStores(t *testing.T) {
+	for name, opts := range wrappingOptions() {
+		t.Run(name, func(t *testing.T) {
+			store := &optionalStore{SimpleMap: NewSimpleMap()}
+			smt := NewSparseMerkleTree(store, store, sha256.New(), opts...)
+			smt.Update([]byte("testKey"), []byte("testValue"))
+			if err := smt.Flush(); err != nil {
+				t.Errorf("returned error when flushing: %v", err)
+			}
+			if store.syncs != 1 {
+				t.Errorf("synced the store %d times, expected once", store.syncs)
+			}
+		})
+	}
+}
+
+// uncomparableStore is a MapStore and Syncer of a type that cannot be
+// compared.
+type uncomparableStore struct {
+	*SimpleMap
+	syncs []int
+}
+
+func (us uncomparableStore) Sync() error {
+	us.syncs[0]++
+	return nil
+}
+
+func TestFlushUncomparableStores(t *testing.T) {
+	store := uncomparableStore{SimpleMap: NewSimpleMap(), syncs: make([]int, 1)}
+	smt := NewSparseMerkleTree(store, store, sha256.New())
+	if err := smt.Flush(); err != nil {
+		t.Fatalf("returned error when flushing: %v", err)
+	}
+	// Stores that cannot be compared are synced as distinct stores.
+	if store.syncs[0] != 2 {
+		t.Errorf("synced %d times, expected 2", store.syncs[0])
+	}
+}
@@ -0,0 +1,125 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrStoreNotIterable is returned by GarbageCollect when the node store does
+// not implement IterableMapStore.
+var ErrStoreNotIterable = errors.New("store keys cannot be iterated")
+
+// IterableMapStore is a MapStore whose keys can be iterated, as needed by
+// GarbageCollect.
+type IterableMapStore interface {
+	MapStore
+	// ForEachKey calls fn for every key in the store, stopping at the first
+	// error returned by fn. fn must not modify the store.
+	ForEachKey(fn func(key []byte) error) error
+}
+
+// GarbageCollect deletes every node that is not reachable from one of
+// liveRoots from the node store, and returns the number of keys deleted.
+// The values of the leaves that are not reachable are deleted from the value
+// store too if it is a different IterableMapStore. Stores that are not
+// pointers are taken to be different. Keys with several references are
+// deleted until none are left. Keys are listed from under the wrappers added
+// by options like WithNodeCache, and deleted through them, so that caches see
+// the deletions.
+//
+// Empty subtrees are placeholders that are never stored, so sparse traversal
+// needs no default nodes and none can be deleted. Roots not in liveRoots,
+// including the current root if it is not given, can no longer be read once
+// collected. Like pruning with RemovePath, collection waits for reads in
+// progress at the other roots to complete, and reads of them wait for the
+// collection. Nothing is deleted if a node reachable from a live root is
+// missing.
+func (smt *SparseMerkleTree) GarbageCollect(liveRoots [][]byte) (int, error) {
+	nodes, ok := unwrapStore(smt.nodes).(IterableMapStore)
+	if !ok {
+		return 0, ErrStoreNotIterable
+	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	defer smt.reads.collect(liveRoots)()
+
+	// The live set holds both nodes and value keys, so that it also applies
+	// to a node store used as the value store.
+	live := make(map[string]struct{})
+	for _, root := range liveRoots {
+		if err := smt.markLive(root, live); err != nil {
+			return 0, err
+		}
+	}
+
+	freed, err := sweep(smt.nodes, nodes, live)
+	if err != nil {
+		return freed, err
+	}
+	if values, ok := unwrapStore(smt.values).(IterableMapStore); ok && !sameStore(values, nodes) {
+		n, err := sweep(smt.values, values, live)
+		freed += n
+		if err != nil {
+			return freed, err
+		}
+	}
+	return freed, nil
+}
+
+// markLive adds the nodes of the subtree at hash, and the value keys of its
+// leaves, to live. Subtrees already in live are skipped.
+func (smt *SparseMerkleTree) markLive(hash []byte, live map[string]struct{}) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	if _, ok := live[string(hash)]; ok {
+		return nil
+	}
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	live[string(hash)] = struct{}{}
+
+	if smt.th.isLeaf(data) {
+		live[string(smt.th.valueKey(data))] = struct{}{}
+		return nil
+	}
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.markLive(leftNode, live); err != nil {
+		return err
+	}
+	return smt.markLive(rightNode, live)
+}
+
+// sweep deletes every key of keys that is not in live from store, the wrapper
+// of keys used by the tree, and returns the number of keys deleted.
+func sweep(store MapStore, keys IterableMapStore, live map[string]struct{}) (int, error) {
+	var dead [][]byte
+	err := keys.ForEachKey(func(key []byte) error {
+		if _, ok := live[string(key)]; !ok {
+			dead = append(dead, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, key := range dead {
+		// Drop every reference to the key.
+		for {
+			has, err := store.Has(key)
+			if err != nil {
+				return i, err
+			}
+			if !has {
+				break
+			}
+			if err := store.Delete(key); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(dead), nil
+}
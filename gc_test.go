@@ -0,0 +1,206 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 50; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, append([]byte("testValue"), key...))
+	}
+	oldRoot := smt.Root()
+	for i := 0; i < 10; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, append([]byte("newValue"), key...))
+	}
+	smt.Delete([]byte("49"))
+
+	freed, err := smt.GarbageCollect([][]byte{smt.Root()})
+	if err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	if freed == 0 {
+		t.Error("did not free any key")
+	}
+	if has, _ := smn.Has(oldRoot); has {
+		t.Error("did not delete the node of an older root")
+	}
+	if has, _ := smv.Has(smt.th.digestValue([]byte("testValue0"))); has {
+		t.Error("did not delete an overwritten value")
+	}
+
+	for i := 0; i < 50; i++ {
+		key := []byte(strconv.Itoa(i))
+		expected := append([]byte("testValue"), key...)
+		if i < 10 {
+			expected = append([]byte("newValue"), key...)
+		} else if i == 49 {
+			expected = defaultValue
+		}
+		value, err := smt.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, expected) {
+			t.Errorf("did not get correct value for key %d", i)
+		}
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), key, expected, sha256.New()) {
+			t.Error("proof failed to verify after collecting garbage")
+		}
+	}
+
+	// Nothing is left to collect, and the tree can still be updated.
+	if freed, _ := smt.GarbageCollect([][]byte{smt.Root()}); freed != 0 {
+		t.Errorf("freed %d keys again", freed)
+	}
+	if _, err := smt.Update([]byte("49"), []byte("testValue49")); err != nil {
+		t.Errorf("returned error when updating after collecting garbage: %v", err)
+	}
+
+	// An empty tree holds no nodes.
+	empty := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if freed, err := empty.GarbageCollect([][]byte{empty.Root()}); err != nil || freed != 0 {
+		t.Errorf("collecting an empty tree freed %d keys with error %v", freed, err)
+	}
+}
+
+func TestGarbageCollectSharedStore(t *testing.T) {
+	store := NewSimpleMap()
+	smt := NewSparseMerkleTree(store, store, sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	liveRoot := smt.Root()
+	smt.Update([]byte("testKey1"), []byte("testValue3"))
+
+	// Keys live in an older root are kept when it is given.
+	if _, err := smt.GarbageCollect([][]byte{smt.Root(), liveRoot}); err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	value, err := smt.GetFromRoot([]byte("testKey1"), liveRoot)
+	if err != nil || !bytes.Equal(value, []byte("testValue1")) {
+		t.Error("did not keep the value of a live root")
+	}
+	value, err = smt.Get([]byte("testKey1"))
+	if err != nil || !bytes.Equal(value, []byte("testValue3")) {
+		t.Error("did not keep the value of the current root")
+	}
+
+	// Missing live nodes fail without deleting anything.
+	size := store.Size()
+	missing := sha256.Sum256([]byte("missing"))
+	if _, err := smt.GarbageCollect([][]byte{smt.Root(), missing[:]}); err == nil {
+		t.Error("did not return an error for a missing live root")
+	}
+	if store.Size() != size {
+		t.Error("deleted keys when a live root is missing")
+	}
+
+	if _, err := NewSparseMerkleTree(failingStore{NewSimpleMap()}, NewSimpleMap(), sha256.New()).GarbageCollect(nil); err != ErrStoreNotIterable {
+		t.Errorf("did not return ErrStoreNotIterable: %v", err)
+	}
+}
+
+// Test collecting garbage from stores of a type that cannot be compared,
+// which are taken to be distinct even when shared.
+func TestGarbageCollectUncomparableStores(t *testing.T) {
+	nodes := uncomparableStore{SimpleMap: NewSimpleMap(), syncs: make([]int, 1)}
+	values := uncomparableStore{SimpleMap: NewSimpleMap(), syncs: make([]int, 1)}
+	for _, stores := range [][2]MapStore{{nodes, values}, {nodes, nodes}} {
+		smt := NewSparseMerkleTree(stores[0], stores[1], sha256.New())
+		smt.Update([]byte("testKey"), []byte("testValue"))
+		smt.Update([]byte("testKey"), []byte("newValue"))
+		if freed, err := smt.GarbageCollect([][]byte{smt.Root()}); err != nil || freed == 0 {
+			t.Errorf("freed %d keys: %v", freed, err)
+		}
+		if value, err := smt.Get([]byte("testKey")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+			t.Errorf("did not keep the current value: %v", err)
+		}
+	}
+}
+
+func TestGarbageCollectWrappedStores(t *testing.T) {
+	for name, opts := range wrappingOptions() {
+		t.Run(name, func(t *testing.T) {
+			store := &optionalStore{SimpleMap: NewSimpleMap()}
+			smt := NewSparseMerkleTree(store, store, sha256.New(), opts...)
+			smt.Update([]byte("testKey"), []byte("testValue"))
+			smt.Update([]byte("testKey2"), []byte("testValue2"))
+			smt.Update([]byte("testKey"), []byte("newValue"))
+
+			freed, err := smt.GarbageCollect([][]byte{smt.Root()})
+			if err != nil {
+				t.Fatalf("returned error when collecting garbage: %v", err)
+			}
+			if freed == 0 {
+				t.Error("did not free any key")
+			}
+			value, err := smt.Get([]byte("testKey"))
+			if err != nil || !bytes.Equal(value, []byte("newValue")) {
+				t.Errorf("did not keep the current value: %v", err)
+			}
+		})
+	}
+}
+
+func TestGarbageCollectVersions(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+
+	// Keep 5 versions, each updating a third of the keys.
+	var roots [][]byte
+	for v := 0; v < 5; v++ {
+		for i := v % 3; i < 30; i += 3 {
+			smt.Update([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(v)))
+		}
+		roots = append(roots, smt.Root())
+	}
+	expected := func(v int, i int) []byte {
+		for ; v >= 0; v-- {
+			if v%3 == i%3 {
+				return []byte(strconv.Itoa(v))
+			}
+		}
+		return nil
+	}
+
+	if _, err := smt.GarbageCollect([][]byte{roots[2], roots[4]}); err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	for v, root := range roots {
+		kept := v == 2 || v == 4
+		for i := 0; i < 30; i++ {
+			value, err := smt.GetFromRoot([]byte(strconv.Itoa(i)), root)
+			switch {
+			case kept && err != nil:
+				t.Errorf("returned error when getting key at kept version %d: %v", v, err)
+			case kept && !bytes.Equal(value, expected(v, i)):
+				t.Errorf("did not get correct value at kept version %d", v)
+			}
+		}
+		if _, err := smt.GetFromRoot([]byte("0"), root); !kept && err == nil {
+			t.Errorf("did not return an error when getting key at collected version %d", v)
+		}
+	}
+
+	// Collecting an empty tree frees everything.
+	for i := 0; i < 30; i++ {
+		smt.Delete([]byte(strconv.Itoa(i)))
+	}
+	if _, err := smt.GarbageCollect([][]byte{smt.Root()}); err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	if smn.Size() != 0 || smv.Size() != 0 {
+		t.Errorf("%d nodes and %d values left after collecting an empty tree", smn.Size(), smv.Size())
+	}
+}
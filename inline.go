@@ -0,0 +1,73 @@
+package smt
+
+// InlineSmallValues makes the tree store values of up to maxLen bytes inline,
+// after the data of their leaf in the node store, instead of in the value
+// store, so that getting them takes one read less. Larger values, and empty
+// ones, are stored in the value store as usual.
+//
+// Leaves are hashed the same either way, so roots and proofs do not change,
+// and trees over the same stores must be made with the option to read the
+// values inlined by one another. Nodes exported with CopyToStore or
+// ExportSnapshot are written without inlined values.
+func InlineSmallValues(maxLen int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.inlineValueSize = maxLen
+	}
+}
+
+// inlines returns whether value is inlined in its leaf.
+func (smt *SparseMerkleTree) inlines(value []byte) bool {
+	return len(value) > 0 && len(value) <= smt.inlineValueSize
+}
+
+// splitNode splits the data of a node, as kept in the node store, into the
+// data of the node and the value inlined after it if it is a leaf with an
+// inlined value, or nil.
+func (smt *SparseMerkleTree) splitNode(record []byte) ([]byte, []byte) {
+	leafSize := len(leafPrefix) + smt.th.pathSize() + smt.th.valueSize()
+	if smt.inlineValueSize <= 0 || len(record) <= leafSize || !smt.th.isLeaf(record) {
+		return record, nil
+	}
+	return record[:leafSize:leafSize], record[leafSize:]
+}
+
+// putLeaf writes the leaf at hash with data data and its value, inlined in the
+// leaf if small enough or in the value store otherwise.
+func (smt *SparseMerkleTree) putLeaf(hash, data, value []byte) error {
+	if smt.inlines(value) {
+		record := make([]byte, 0, len(data)+len(value))
+		record = append(record, data...)
+		return smt.nodes.Put(hash, append(record, value...))
+	}
+	// The value is written first, so that a leaf in the store has its value.
+	if err := smt.values.Put(smt.th.valueKey(data), value); err != nil {
+		return err
+	}
+	return smt.nodes.Put(hash, data)
+}
+
+// leafValue returns the value of the leaf with data leafData, given the value
+// inlined in the leaf as returned by getNodeWithValue, if any, or read from
+// the value store otherwise.
+func (smt *SparseMerkleTree) leafValue(leafData, inlineValue []byte) ([]byte, error) {
+	if inlineValue != nil {
+		return inlineValue, nil
+	}
+	return smt.values.Get(smt.th.valueKey(leafData))
+}
+
+// deleteLeafValue deletes the value of the leaf at hash with data leafData
+// from the value store, unless it is inlined in the leaf. The leaf must still
+// be in the node store.
+func (smt *SparseMerkleTree) deleteLeafValue(hash, leafData []byte) error {
+	if smt.inlineValueSize > 0 {
+		_, inlineValue, err := smt.getNodeWithValue(hash)
+		if err != nil {
+			return err
+		}
+		if inlineValue != nil {
+			return nil
+		}
+	}
+	return smt.values.Delete(smt.th.valueKey(leafData))
+}
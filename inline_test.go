@@ -0,0 +1,120 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestInlineSmallValues(t *testing.T) {
+	nodes, values := &countingStore{MapStore: NewSimpleMap()}, &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, values, sha256.New(), InlineSmallValues(8))
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	update := func(key string, value []byte) {
+		t.Helper()
+		smt.Update([]byte(key), value)
+		plain.Update([]byte(key), value)
+		if !bytes.Equal(smt.Root(), plain.Root()) {
+			t.Fatalf("root differs from plain tree after updating %s", key)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		update("small"+strconv.Itoa(i), []byte(strconv.Itoa(i)))
+	}
+	if values.accesses.Load() != 0 {
+		t.Error("used the value store for small values")
+	}
+
+	// Values of up to the maximum size are inlined, larger ones are not.
+	update("boundary", []byte("12345678"))
+	if values.accesses.Load() != 0 {
+		t.Error("used the value store for a value of the maximum size")
+	}
+	update("large", []byte("123456789"))
+	if values.accesses.Load() != 1 {
+		t.Errorf("accessed the value store %d times for a large value", values.accesses.Load())
+	}
+	values.accesses.Store(0)
+	for key, expected := range map[string][]byte{"small3": []byte("3"), "boundary": []byte("12345678"), "large": []byte("123456789")} {
+		value, err := smt.Get([]byte(key))
+		if err != nil || !bytes.Equal(value, expected) {
+			t.Errorf("got %q, %v for %s, expected %q", value, err, key, expected)
+		}
+		if value, _ := smt.GetDescend([]byte(key)); !bytes.Equal(value, expected) {
+			t.Errorf("got %q when descending to %s, expected %q", value, key, expected)
+		}
+		proof, _ := smt.Prove([]byte(key))
+		if !VerifyProof(proof, smt.Root(), []byte(key), expected, sha256.New()) {
+			t.Errorf("could not verify proof of %s", key)
+		}
+	}
+	if values.accesses.Load() != 2 {
+		t.Errorf("accessed the value store %d times to get one large value twice", values.accesses.Load())
+	}
+
+	values.accesses.Store(0)
+	smt.UpdateBatch([][]byte{[]byte("batch")}, [][]byte{[]byte("batch")})
+	plain.UpdateBatch([][]byte{[]byte("batch")}, [][]byte{[]byte("batch")})
+	if value, err := smt.Get([]byte("batch")); err != nil || !bytes.Equal(value, []byte("batch")) || values.accesses.Load() != 0 {
+		t.Errorf("got %q, %v for value set in batch, using the value store", value, err)
+	}
+
+	// Large values are stored once per leaf, as without the option.
+	values.accesses.Store(0)
+	update("large", []byte("123456789"))
+	update("large2", []byte("123456789"))
+	if values.accesses.Load() != 1 {
+		t.Errorf("accessed the value store %d times to store one new large value", values.accesses.Load())
+	}
+
+	// Deleting and pruning inlined values does not touch the value store.
+	root := smt.Root()
+	values.accesses.Store(0)
+	update("small1", nil)
+	if err := smt.RemovePath([]byte("small1"), root, smt.Root()); err != nil {
+		t.Errorf("returned error when removing path of inlined value: %v", err)
+	}
+	if values.accesses.Load() != 0 {
+		t.Error("used the value store to delete a small value")
+	}
+	if bad, err := smt.VerifyIntegrity(); err != nil || len(bad) != 0 {
+		t.Errorf("tree with inlined values failed the integrity check: %v %x", err, bad)
+	}
+
+	// Iteration and copies see the inlined values.
+	iterated := map[string][]byte{}
+	plain.Iterate(func(path, value []byte) error {
+		iterated[string(path)] = value
+		return nil
+	})
+	nodes.accesses.Store(0)
+	smt.Iterate(func(path, value []byte) error {
+		if !bytes.Equal(iterated[string(path)], value) {
+			t.Errorf("iterated %q, expected %q", value, iterated[string(path)])
+		}
+		delete(iterated, string(path))
+		return nil
+	})
+	if len(iterated) != 0 {
+		t.Errorf("did not iterate %d leaves", len(iterated))
+	}
+	// Inlined values are read along with their leaf.
+	reads, walked := nodes.accesses.Load(), int64(0)
+	smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		walked++
+		return nil
+	})
+	if reads != walked {
+		t.Errorf("read %d nodes to iterate %d", reads, walked)
+	}
+	dst := NewSimpleMap()
+	if err := smt.CopyToStore(dst); err != nil {
+		t.Fatalf("returned error when copying: %v", err)
+	}
+	copied := ImportSparseMerkleTree(dst, dst, sha256.New(), smt.Root())
+	if value, err := copied.Get([]byte("small3")); err != nil || !bytes.Equal(value, []byte("3")) {
+		t.Errorf("got %q, %v from copy without inlined values", value, err)
+	}
+}
@@ -0,0 +1,67 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// VerifyIntegrity checks the nodes of the tree reachable from its current
+// root, returning the hashes of the bad ones: nodes missing from the node
+// store, malformed or whose data does not digest to their hash, and leaves
+// whose value is missing from the value store or does not match its digest.
+// The subtrees below bad nodes are not checked. Errors of the stores other
+// than missing keys are returned as they are.
+func (smt *SparseMerkleTree) VerifyIntegrity() ([][]byte, error) {
+	root := smt.Root()
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	var bad [][]byte
+	if err := smt.verifyIntegrity(root, &bad); err != nil {
+		return nil, err
+	}
+	return bad, nil
+}
+
+// verifyIntegrity checks the subtree at hash, appending the hashes of its bad
+// nodes to bad.
+func (smt *SparseMerkleTree) verifyIntegrity(hash []byte, bad *[][]byte) error {
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		return nil
+	}
+	var invalidKeyError *InvalidKeyError
+	record, err := smt.nodes.Get(hash)
+	if errors.As(err, &invalidKeyError) {
+		*bad = append(*bad, hash)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, inlineValue := smt.splitNode(record)
+	if smt.th.checkNode(hash, data) != nil || !bytes.Equal(smt.th.digest(data), hash) {
+		*bad = append(*bad, hash)
+		return nil
+	}
+
+	if smt.th.isLeaf(data) {
+		_, valueHash, _ := smt.th.parseLeaf(data)
+		value, err := smt.leafValue(data, inlineValue)
+		if errors.As(err, &invalidKeyError) {
+			*bad = append(*bad, hash)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(smt.th.digestValue(value), valueHash) {
+			*bad = append(*bad, hash)
+		}
+		return nil
+	}
+
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.verifyIntegrity(leftNode, bad); err != nil {
+		return err
+	}
+	return smt.verifyIntegrity(rightNode, bad)
+}
@@ -0,0 +1,60 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestVerifyIntegrity(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	bad, err := smt.VerifyIntegrity()
+	if err != nil || len(bad) != 0 {
+		t.Errorf("empty tree failed the integrity check: %v %x", err, bad)
+	}
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"+strconv.Itoa(i)))
+	}
+	bad, err = smt.VerifyIntegrity()
+	if err != nil || len(bad) != 0 {
+		t.Errorf("healthy tree failed the integrity check: %v %x", err, bad)
+	}
+
+	// Poison the value of a leaf.
+	path := smt.Path([]byte("1"))
+	leafHash, leafData := smt.th.digestLeaf(path, smt.th.digestValue([]byte("testValue1")))
+	value := smv.m[string(smt.th.valueKey(leafData))]
+	value.data = []byte("poisoned")
+	smv.m[string(smt.th.valueKey(leafData))] = value
+	bad, err = smt.VerifyIntegrity()
+	if err != nil || len(bad) != 1 || !bytes.Equal(bad[0], leafHash) {
+		t.Errorf("did not report the leaf with a poisoned value: %v %x", err, bad)
+	}
+
+	// Poison an inner node with the data of another node.
+	proof, _ := smt.Prove([]byte("0"))
+	inner := proof.SideNodes[len(proof.SideNodes)-1]
+	node := smn.m[string(inner)]
+	node.data = smn.m[string(smt.Root())].data
+	smn.m[string(inner)] = node
+	bad, err = smt.VerifyIntegrity()
+	if err != nil {
+		t.Fatalf("returned error when checking integrity: %v", err)
+	}
+	found := false
+	for _, hash := range bad {
+		found = found || bytes.Equal(hash, inner)
+	}
+	if !found {
+		t.Errorf("did not report the poisoned node: %x", bad)
+	}
+
+	// Missing nodes are reported too.
+	delete(smn.m, string(smt.Root()))
+	bad, err = smt.VerifyIntegrity()
+	if err != nil || len(bad) != 1 || !bytes.Equal(bad[0], smt.Root()) {
+		t.Errorf("did not report the missing root: %v %x", err, bad)
+	}
+}
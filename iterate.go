@@ -0,0 +1,179 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+)
+
+// errStopWalk is returned by walk callbacks to end the traversal early.
+var errStopWalk = errors.New("stop walk")
+
+// walkFunc is called by walk for every node reached, with the value inlined in
+// the node if it is a leaf with an inlined value, or nil, and the side nodes
+// leading to it ordered from the root down.
+type walkFunc func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error
+
+// walk performs a depth-first traversal of the tree at root, calling fn for
+// every non-placeholder node, parents before children and left before right.
+// If fn returns errStopWalk, the traversal ends without error.
+func (smt *SparseMerkleTree) walk(root []byte, fn walkFunc) error {
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return nil
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	err := smt.walkNode(root, nil, fn)
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
+
+func (smt *SparseMerkleTree) walkNode(hash []byte, sideNodes [][]byte, fn walkFunc) error {
+	data, inlineValue, err := smt.getNodeWithValue(hash)
+	if err != nil {
+		return err
+	}
+	if err := fn(hash, data, inlineValue, sideNodes); err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		return nil
+	}
+
+	leftNode, rightNode := smt.th.parseNode(data)
+	// Cap the slice so that siblings do not share the appended element.
+	sideNodes = sideNodes[:len(sideNodes):len(sideNodes)]
+	if !bytes.Equal(leftNode, smt.th.placeholder()) {
+		if err := smt.walkNode(leftNode, append(sideNodes, rightNode), fn); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(rightNode, smt.th.placeholder()) {
+		if err := smt.walkNode(rightNode, append(sideNodes, leftNode), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IterateWithProofs calls fn for every leaf of the tree in path order, with
+// the path and value of the leaf and its Merkle proof against the root at the
+// time of the call. Proofs are built from the traversal itself, which is much
+// cheaper than proving every key separately. Iteration stops when fn returns
+// false. The tree is read-locked during the iteration, so fn must not call
+// methods of the tree.
+func (smt *SparseMerkleTree) IterateWithProofs(fn func(path []byte, value []byte, proof SparseMerkleProof) bool) error {
+	return smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		if !smt.th.isLeaf(data) {
+			return nil
+		}
+		value, err := smt.leafValue(data, inlineValue)
+		if err != nil {
+			return err
+		}
+
+		// Proofs list side nodes from the leaf up.
+		proofSideNodes := make([][]byte, len(sideNodes))
+		for i, sideNode := range sideNodes {
+			proofSideNodes[len(sideNodes)-1-i] = sideNode
+		}
+		path, _, _ := smt.th.parseLeaf(data)
+		if !fn(path, value, SparseMerkleProof{SideNodes: proofSideNodes}) {
+			return errStopWalk
+		}
+		return nil
+	})
+}
+
+// Iterate calls fn for every leaf of the tree at the current root in path
+// order, with the path and value of the leaf. Empty subtrees are skipped.
+// Iteration stops at the first error returned by fn, which Iterate returns.
+// Like IterateWithProofs, fn must not call methods of the tree.
+//
+// The tree only stores the path of a key, its digest by the path hasher, so
+// the keys themselves are not available unless they are kept elsewhere. With
+// WithKeyIndex, fn is given the key of the leaf instead of its path, or its
+// path if the leaf was set without its key, with UpdateByPath.
+func (smt *SparseMerkleTree) Iterate(fn func(path []byte, value []byte) error) error {
+	return smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		if !smt.th.isLeaf(data) {
+			return nil
+		}
+		value, err := smt.leafValue(data, inlineValue)
+		if err != nil {
+			return err
+		}
+		path, _, _ := smt.th.parseLeaf(data)
+		key, err := smt.indexedKey(path)
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
+
+// ErrInvalidPrefix is returned by RangeByPathPrefix when the number of bits of
+// the prefix is negative, exceeds the size of paths or of the prefix itself.
+var ErrInvalidPrefix = errors.New("invalid path prefix")
+
+// RangeByPathPrefix calls fn for every leaf of the tree at the current root
+// whose path starts with the first bits bits of prefix, in path order, with
+// the path and value of the leaf. Only the subtree of the prefix is walked, so
+// the paths can be partitioned by prefix to iterate the tree in shards. With
+// zero bits, every leaf is visited. Iteration stops at the first error
+// returned by fn, which RangeByPathPrefix returns. Like Iterate, fn must not
+// call methods of the tree.
+func (smt *SparseMerkleTree) RangeByPathPrefix(prefix []byte, bits int, fn func(path []byte, value []byte) error) error {
+	if bits < 0 || bits > smt.depth() || bits > len(prefix)*8 {
+		return ErrInvalidPrefix
+	}
+	root := smt.Root()
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return nil
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	// Descend to the subtree of the prefix, or to a leaf above it.
+	hash := root
+	for height := 0; height < bits; height++ {
+		data, err := smt.getNode(hash)
+		if err != nil {
+			return err
+		}
+		if smt.th.isLeaf(data) {
+			break
+		}
+		leftNode, rightNode := smt.th.parseNode(data)
+		if getBitAtFromMSB(prefix, height) == right {
+			hash = rightNode
+		} else {
+			hash = leftNode
+		}
+		if bytes.Equal(hash, smt.th.placeholder()) {
+			return nil
+		}
+	}
+
+	return smt.walkNode(hash, nil, func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		if !smt.th.isLeaf(data) {
+			return nil
+		}
+		path, _, _ := smt.th.parseLeaf(data)
+		for i := 0; i < bits; i++ {
+			// A leaf above the subtree of the prefix may lie outside of it.
+			if getBitAtFromMSB(path, i) != getBitAtFromMSB(prefix, i) {
+				return nil
+			}
+		}
+		value, err := smt.leafValue(data, inlineValue)
+		if err != nil {
+			return err
+		}
+		return fn(path, value)
+	})
+}
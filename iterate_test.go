@@ -0,0 +1,204 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIterateWithProofs(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	// An empty tree has no leaves.
+	err := smt.IterateWithProofs(func(path []byte, value []byte, proof SparseMerkleProof) bool {
+		t.Error("visited a leaf of an empty tree")
+		return true
+	})
+	if err != nil {
+		t.Errorf("returned error when iterating empty tree: %v", err)
+	}
+
+	kv := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		key, value := fmt.Sprintf("testKey%d", i), fmt.Sprintf("testValue%d", i)
+		kv[string(smt.th.path([]byte(key)))] = key
+		smt.Update([]byte(key), []byte(value))
+	}
+	smt.Delete([]byte("testKey7"))
+	delete(kv, string(smt.th.path([]byte("testKey7"))))
+
+	root := smt.Root()
+	visited := 0
+	var lastPath []byte
+	err = smt.IterateWithProofs(func(path []byte, value []byte, proof SparseMerkleProof) bool {
+		visited++
+		key, ok := kv[string(path)]
+		if !ok {
+			t.Errorf("visited unexpected leaf %x", path)
+			return true
+		}
+		if !bytes.Equal(value, []byte("testValue"+key[len("testKey"):])) {
+			t.Error("visited leaf with incorrect value")
+		}
+		if bytes.Compare(lastPath, path) >= 0 {
+			t.Error("leaves were not visited in path order")
+		}
+		lastPath = path
+		if !VerifyProof(proof, root, []byte(key), value, sha256.New()) {
+			t.Error("emitted proof failed to verify")
+		}
+		return true
+	})
+	if err != nil {
+		t.Errorf("returned error when iterating: %v", err)
+	}
+	if visited != len(kv) {
+		t.Errorf("visited %d leaves, expected %d", visited, len(kv))
+	}
+
+	// Returning false stops the iteration.
+	visited = 0
+	err = smt.IterateWithProofs(func(path []byte, value []byte, proof SparseMerkleProof) bool {
+		visited++
+		return visited < 3
+	})
+	if err != nil {
+		t.Errorf("returned error when iterating: %v", err)
+	}
+	if visited != 3 {
+		t.Errorf("visited %d leaves after stopping, expected 3", visited)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	kv := make(map[string]string)
+	for i := 0; i < 50; i++ {
+		key, value := fmt.Sprintf("testKey%d", i), fmt.Sprintf("testValue%d", i)
+		kv[string(smt.th.path([]byte(key)))] = value
+		smt.Update([]byte(key), []byte(value))
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("testKey%d", i)
+		smt.Delete([]byte(key))
+		delete(kv, string(smt.th.path([]byte(key))))
+	}
+
+	visited := make(map[string]bool)
+	err := smt.Iterate(func(path []byte, value []byte) error {
+		if expected, ok := kv[string(path)]; !ok || !bytes.Equal(value, []byte(expected)) {
+			t.Errorf("visited unexpected leaf %x", path)
+		}
+		if visited[string(path)] {
+			t.Errorf("visited leaf %x twice", path)
+		}
+		visited[string(path)] = true
+		return nil
+	})
+	if err != nil {
+		t.Errorf("returned error when iterating: %v", err)
+	}
+	if len(visited) != len(kv) {
+		t.Errorf("visited %d leaves, want %d", len(visited), len(kv))
+	}
+
+	// Errors returned by fn stop the iteration.
+	errStop := errors.New("stop")
+	visits := 0
+	err = smt.Iterate(func(path []byte, value []byte) error {
+		visits++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || visits != 1 {
+		t.Errorf("did not stop at the error returned by fn: %v", err)
+	}
+}
+
+func TestRangeByPathPrefix(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	err := smt.RangeByPathPrefix([]byte{0}, 1, func(path []byte, value []byte) error {
+		t.Error("visited a leaf of an empty tree")
+		return nil
+	})
+	if err != nil {
+		t.Errorf("returned error when ranging over empty tree: %v", err)
+	}
+
+	// A single leaf is the root, and only in the range of its own prefix.
+	smt.Update([]byte("testKey0"), []byte("testValue0"))
+	path := smt.th.path([]byte("testKey0"))
+	for _, bit := range []byte{0, 0x80} {
+		visited := 0
+		smt.RangeByPathPrefix([]byte{bit}, 1, func(path []byte, value []byte) error {
+			visited++
+			return nil
+		})
+		if expected := getBitAtFromMSB(path, 0) == int(bit>>7); expected != (visited == 1) {
+			t.Errorf("visited %d leaves for prefix bit %d", visited, bit>>7)
+		}
+	}
+
+	kv := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		key, value := fmt.Sprintf("testKey%d", i), fmt.Sprintf("testValue%d", i)
+		kv[string(smt.th.path([]byte(key)))] = value
+		smt.Update([]byte(key), []byte(value))
+	}
+
+	// Partitioning by one bit visits every leaf exactly once.
+	visited := make(map[string]int)
+	for _, prefix := range [][]byte{{0}, {0x80}} {
+		err := smt.RangeByPathPrefix(prefix, 1, func(path []byte, value []byte) error {
+			if getBitAtFromMSB(path, 0) != getBitAtFromMSB(prefix, 0) {
+				t.Error("visited leaf outside of the prefix")
+			}
+			if kv[string(path)] != string(value) {
+				t.Error("visited leaf with incorrect value")
+			}
+			visited[string(path)]++
+			return nil
+		})
+		if err != nil {
+			t.Errorf("returned error when ranging over prefix: %v", err)
+		}
+	}
+	if len(visited) != len(kv) {
+		t.Errorf("visited %d leaves, expected %d", len(visited), len(kv))
+	}
+	for _, n := range visited {
+		if n != 1 {
+			t.Error("visited a leaf several times")
+		}
+	}
+
+	// Zero bits visit everything, and a full path its own leaf only.
+	all := 0
+	smt.RangeByPathPrefix(nil, 0, func(path []byte, value []byte) error {
+		all++
+		return nil
+	})
+	if all != len(kv) {
+		t.Errorf("visited %d leaves with an empty prefix, expected %d", all, len(kv))
+	}
+	var got []byte
+	smt.RangeByPathPrefix(path, smt.depth(), func(path []byte, value []byte) error {
+		got = append(got, value...)
+		return nil
+	})
+	if !bytes.Equal(got, []byte("testValue0")) {
+		t.Error("did not visit only the leaf of a full path")
+	}
+
+	errStop := errors.New("stop")
+	if err := smt.RangeByPathPrefix(nil, 0, func(path []byte, value []byte) error { return errStop }); err != errStop {
+		t.Errorf("did not return error of fn: %v", err)
+	}
+	for _, bits := range []int{-1, smt.depth() + 1, 9} {
+		if err := smt.RangeByPathPrefix([]byte{0}, bits, nil); err != ErrInvalidPrefix {
+			t.Errorf("did not return ErrInvalidPrefix for %d bits: %v", bits, err)
+		}
+	}
+}
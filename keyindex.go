@@ -0,0 +1,95 @@
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyCollision is returned by trees made with WithCollisionDetection when a
+// key has the same path as another key in the tree.
+var ErrKeyCollision = errors.New("key collides with another key")
+
+// WithKeyIndex makes the tree keep the key of every leaf in store, indexed by
+// the path of the key, so that Iterate can return keys instead of paths.
+//
+// The index follows the current root: Update, Delete and UpdateBatch add and
+// remove the keys they set and delete. UpdateByPath only removes the keys it
+// deletes, as it does not know the keys it sets. Updates at other roots, with
+// UpdateForRoot or DeleteForRoot, do not change it.
+func WithKeyIndex(store MapStore) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.keyIndex = store
+	}
+}
+
+// WithCollisionDetection makes the tree keep the key of every leaf in store,
+// like WithKeyIndex, and check that the keys read or written have the same
+// path as no other key in the tree. Leaves only hold the path of their key, so
+// without it two keys with the same path are the same key to the tree; with
+// it, Get, Has, Update, Delete and UpdateBatch return ErrKeyCollision for the
+// second key instead of mixing up its data with the first.
+//
+// Only keys in the index are checked, so leaves set at other roots or by path
+// are not.
+func WithCollisionDetection(store MapStore) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.keyIndex = store
+		smt.detectCollisions = true
+	}
+}
+
+// checkCollision returns ErrKeyCollision if collisions are detected and the
+// leaf at path is in the key index with a key other than key.
+func (smt *SparseMerkleTree) checkCollision(path, key []byte) error {
+	if !smt.detectCollisions {
+		return nil
+	}
+	indexed, err := smt.keyIndex.Get(path)
+	var invalidKeyError *InvalidKeyError
+	if errors.As(err, &invalidKeyError) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(indexed, key) {
+		return fmt.Errorf("%w: %x and %x", ErrKeyCollision, key, indexed)
+	}
+	return nil
+}
+
+// indexKey records in the key index, if any, that the key at path was set to
+// value, adding the key if it was set and removing it if it was deleted. A nil
+// key, for updates by path, is only removed.
+func (smt *SparseMerkleTree) indexKey(path, key, value []byte) error {
+	if smt.keyIndex == nil {
+		return nil
+	}
+	has, err := smt.keyIndex.Has(path)
+	if err != nil {
+		return err
+	}
+	deleted := smt.th.isDefault(value)
+	switch {
+	case deleted && has:
+		return smt.keyIndex.Delete(path)
+	case !deleted && !has && key != nil:
+		return smt.keyIndex.Put(path, key)
+	}
+	return nil
+}
+
+// indexedKey returns the key at path from the key index, or path if there is
+// no key index or the key is not in it.
+func (smt *SparseMerkleTree) indexedKey(path []byte) ([]byte, error) {
+	if smt.keyIndex == nil {
+		return path, nil
+	}
+	key, err := smt.keyIndex.Get(path)
+	var invalidKeyError *InvalidKeyError
+	if errors.As(err, &invalidKeyError) {
+		return path, nil
+	}
+	return key, err
+}
@@ -0,0 +1,198 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"testing"
+)
+
+func TestWithKeyIndex(t *testing.T) {
+	index := NewSimpleMap()
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithKeyIndex(index))
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	kv := make(map[string]string)
+	var keys, values [][]byte
+	for i := 0; i < 30; i++ {
+		key, value := fmt.Sprintf("testKey%d", i), fmt.Sprintf("testValue%d", i)
+		kv[key] = value
+		if i%2 == 0 {
+			smt.Update([]byte(key), []byte(value))
+			plain.Update([]byte(key), []byte(value))
+		} else {
+			keys, values = append(keys, []byte(key)), append(values, []byte(value))
+		}
+	}
+	smt.UpdateBatch(keys, values)
+	plain.UpdateBatch(keys, values)
+	// Updating a key again keeps a single index entry.
+	smt.Update([]byte("testKey0"), []byte("testValue0"))
+	plain.Update([]byte("testKey0"), []byte("testValue0"))
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("testKey%d", i)
+		delete(kv, key)
+		smt.Delete([]byte(key))
+		plain.Delete([]byte(key))
+	}
+	smt.UpdateBatch([][]byte{[]byte("testKey10")}, [][]byte{defaultValue})
+	plain.UpdateBatch([][]byte{[]byte("testKey10")}, [][]byte{defaultValue})
+	delete(kv, "testKey10")
+	// Updates by path keep the keys of the paths they set, and remove the keys
+	// of the paths they delete.
+	smt.UpdateByPath(smt.Path([]byte("testKey11")), []byte("pathValue"))
+	plain.UpdateByPath(plain.Path([]byte("testKey11")), []byte("pathValue"))
+	kv["testKey11"] = "pathValue"
+	smt.UpdateByPath(smt.Path([]byte("testKey12")), defaultValue)
+	plain.UpdateByPath(plain.Path([]byte("testKey12")), defaultValue)
+	delete(kv, "testKey12")
+
+	if !bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("key index changed the root")
+	}
+	if index.Size() != int64(len(kv)) {
+		t.Errorf("key index has %d keys, want %d", index.Size(), len(kv))
+	}
+	for _, value := range index.m {
+		if value.count != 1 {
+			t.Error("key index has duplicate entries")
+		}
+	}
+
+	// Iterate returns the keys verbatim.
+	visited := 0
+	err := smt.Iterate(func(key []byte, value []byte) error {
+		visited++
+		if expected, ok := kv[string(key)]; !ok || expected != string(value) {
+			t.Errorf("visited unexpected key %q", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("returned error when iterating: %v", err)
+	}
+	if visited != len(kv) {
+		t.Errorf("visited %d keys, want %d", visited, len(kv))
+	}
+
+	// Without the index, Iterate still returns paths.
+	err = plain.Iterate(func(path []byte, value []byte) error {
+		if len(path) != sha256.Size {
+			t.Errorf("visited %q instead of a path", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("returned error when iterating: %v", err)
+	}
+}
+
+// prefixHasher is a hash.Hash digesting only the first 4 bytes written to it,
+// so that keys sharing them collide.
+type prefixHasher struct {
+	hash.Hash
+	written int
+}
+
+func (ph *prefixHasher) Write(p []byte) (int, error) {
+	if n := 4 - ph.written; n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		ph.Hash.Write(p[:n])
+		ph.written += n
+	}
+	return len(p), nil
+}
+
+func (ph *prefixHasher) Reset() {
+	ph.Hash.Reset()
+	ph.written = 0
+}
+
+func TestWithCollisionDetection(t *testing.T) {
+	// Without detection, colliding keys are the same key.
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathHasher(&prefixHasher{Hash: sha256.New()}))
+	plain.Update([]byte("testKey1"), []byte("testValue1"))
+	if value, _ := plain.Get([]byte("testKey2")); !bytes.Equal(value, []byte("testValue1")) {
+		t.Fatal("colliding keys did not collide")
+	}
+
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathHasher(&prefixHasher{Hash: sha256.New()}), WithCollisionDetection(NewSimpleMap()))
+	if _, err := smt.Update([]byte("testKey1"), []byte("testValue1")); err != nil {
+		t.Fatalf("returned error when updating key: %v", err)
+	}
+	root := smt.Root()
+	if _, err := smt.Get([]byte("testKey2")); !errors.Is(err, ErrKeyCollision) {
+		t.Errorf("did not return ErrKeyCollision when getting colliding key: %v", err)
+	}
+	if _, err := smt.Has([]byte("testKey2")); !errors.Is(err, ErrKeyCollision) {
+		t.Errorf("did not return ErrKeyCollision when checking colliding key: %v", err)
+	}
+	if _, err := smt.Update([]byte("testKey2"), []byte("testValue2")); !errors.Is(err, ErrKeyCollision) {
+		t.Errorf("did not return ErrKeyCollision when updating colliding key: %v", err)
+	}
+	if _, err := smt.Delete([]byte("testKey2")); !errors.Is(err, ErrKeyCollision) {
+		t.Errorf("did not return ErrKeyCollision when deleting colliding key: %v", err)
+	}
+	if _, err := smt.UpdateBatch([][]byte{[]byte("testKey2")}, [][]byte{[]byte("testValue2")}); !errors.Is(err, ErrKeyCollision) {
+		t.Errorf("did not return ErrKeyCollision when updating colliding key in batch: %v", err)
+	}
+	if _, err := smt.UpdateBatch([][]byte{[]byte("testKeyA"), []byte("testKeyB")}, [][]byte{[]byte("a"), []byte("b")}); !errors.Is(err, ErrKeyCollision) {
+		t.Errorf("did not return ErrKeyCollision for colliding keys in batch: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("colliding updates changed the root")
+	}
+
+	// The key itself and other keys are unaffected.
+	if value, err := smt.Get([]byte("testKey1")); err != nil || !bytes.Equal(value, []byte("testValue1")) {
+		t.Error("did not get value of key")
+	}
+	if _, err := smt.Update([]byte("otherKey"), []byte("otherValue")); err != nil {
+		t.Errorf("returned error when updating other key: %v", err)
+	}
+
+	// Once the key is deleted, a colliding key can be set.
+	smt.Delete([]byte("testKey1"))
+	if _, err := smt.Update([]byte("testKey2"), []byte("testValue2")); err != nil {
+		t.Errorf("returned error when updating key after deleting colliding key: %v", err)
+	}
+
+	// Keys are validated before they are checked for collisions.
+	fixed := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathHasher(&prefixHasher{Hash: sha256.New()}), WithCollisionDetection(NewSimpleMap()), WithFixedKeyLength(8))
+	fixed.Update([]byte("testKey1"), []byte("testValue1"))
+	var lengthErr *InvalidKeyLengthError
+	if _, err := fixed.Update([]byte("testKey12"), []byte("testValue2")); !errors.As(err, &lengthErr) {
+		t.Errorf("did not return InvalidKeyLengthError for colliding key of wrong length: %v", err)
+	}
+}
+
+// countingHasher is a hash.Hash counting the digests it computes.
+type countingHasher struct {
+	hash.Hash
+	sums int
+}
+
+func (ch *countingHasher) Sum(b []byte) []byte {
+	ch.sums++
+	return ch.Hash.Sum(b)
+}
+
+// Test that an update hashes the path of its key once, whatever the options
+// using the path.
+func TestUpdateHashesPathOnce(t *testing.T) {
+	hasher := &countingHasher{Hash: sha256.New()}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithPathHasher(hasher), WithCollisionDetection(NewSimpleMap()), WithExistenceCache(16))
+	for i, value := range []string{"testValue", "testValue2", ""} {
+		hasher.sums = 0
+		if _, err := smt.Update([]byte("testKey"), []byte(value)); err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+		if hasher.sums != 1 {
+			t.Errorf("update %d hashed the path %d times, expected once", i, hasher.sums)
+		}
+	}
+}
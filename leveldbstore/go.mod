@@ -0,0 +1,10 @@
+module github.com/memoio/smt/leveldbstore
+
+go 1.19
+
+require (
+	github.com/memoio/smt v0.0.0-20261016174908-670095a47cda
+	github.com/syndtr/goleveldb v1.0.0
+)
+
+require github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
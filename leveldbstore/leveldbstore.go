@@ -0,0 +1,264 @@
+// Package leveldbstore provides an smt.MapStore backed by LevelDB.
+package leveldbstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/memoio/smt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Store is an smt.MapStore backed by a LevelDB database on disk, for trees that
+// must persist or do not fit in memory.
+//
+// Like smt.SimpleMap, the store keeps a reference count per key: Put increments
+// it and Delete only removes the value once it drops to zero. The count is
+// stored in front of the value.
+//
+// Each call is written on its own unless a transaction was started with Begin,
+// in which case writes are buffered in memory, and visible to the following
+// calls, until Commit writes them in a single batch or Discard drops them.
+// Wrapping a tree operation in a transaction writes all of its nodes at once.
+type Store struct {
+	db *leveldb.DB
+
+	mu      sync.RWMutex
+	pending map[string][]byte // Records written in the transaction, nil if deleted.
+}
+
+// New opens the LevelDB database at path, creating it if it does not exist yet.
+func New(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// record gets the record of a key, a reference count followed by the value,
+// or nil if the key does not exist.
+func (ls *Store) record(key []byte) ([]byte, error) {
+	if ls.pending != nil {
+		if record, ok := ls.pending[string(key)]; ok {
+			return record, nil
+		}
+	}
+	record, err := ls.db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// write sets the record of a key, deleting the key if record is nil.
+func (ls *Store) write(key []byte, record []byte) error {
+	if ls.pending != nil {
+		ls.pending[string(key)] = record
+		return nil
+	}
+	if record == nil {
+		return ls.db.Delete(key, nil)
+	}
+	return ls.db.Put(key, record, nil)
+}
+
+// Get gets the value for a key.
+func (ls *Store) Get(key []byte) ([]byte, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	record, err := ls.record(key)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return record[4:], nil
+}
+
+// Put updates the value for a key.
+func (ls *Store) Put(key []byte, value []byte) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	record, err := ls.record(key)
+	if err != nil {
+		return err
+	}
+	var count uint32
+	if record != nil {
+		count = binary.BigEndian.Uint32(record)
+	}
+	updated := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(value)), count+1)
+	return ls.write(key, append(updated, value...))
+}
+
+// Has returns true if the key exists in the store.
+func (ls *Store) Has(key []byte) (bool, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	record, err := ls.record(key)
+	return record != nil, err
+}
+
+// Delete deletes a key.
+func (ls *Store) Delete(key []byte) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	record, err := ls.record(key)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return &smt.InvalidKeyError{Key: key}
+	}
+	count := binary.BigEndian.Uint32(record) - 1
+	if count == 0 {
+		return ls.write(key, nil)
+	}
+	updated := binary.BigEndian.AppendUint32(make([]byte, 0, len(record)), count)
+	return ls.write(key, append(updated, record[4:]...))
+}
+
+// Size returns the number of keys in the store, counting the keys written
+// and deleted by the transaction in progress, if any.
+func (ls *Store) Size() (int64, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var size int64
+	iter := ls.db.NewIterator(nil, nil)
+	for iter.Next() {
+		size++
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	for key, record := range ls.pending {
+		has, err := ls.db.Has([]byte(key), nil)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case has && record == nil:
+			size--
+		case !has && record != nil:
+			size++
+		}
+	}
+	return size, nil
+}
+
+// ForEachKey calls fn for every key in the store, including the keys written
+// and excluding the keys deleted by the transaction in progress, if any,
+// stopping at the first error returned by fn.
+func (ls *Store) ForEachKey(fn func(key []byte) error) error {
+	keys, err := ls.keys()
+	if err != nil {
+		return err
+	}
+	// fn is called once the store is released, so that it can read it.
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ls *Store) keys() ([][]byte, error) {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var keys [][]byte
+	iter := ls.db.NewIterator(nil, nil)
+	for iter.Next() {
+		if record, ok := ls.pending[string(iter.Key())]; ok && record == nil {
+			continue
+		}
+		keys = append(keys, append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	for key, record := range ls.pending {
+		if record == nil {
+			continue
+		}
+		has, err := ls.db.Has([]byte(key), nil)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys, nil
+}
+
+// Sync flushes the database to disk. Writes of a transaction in progress are
+// not flushed until it is committed.
+//
+// LevelDB only syncs its journal along with a write, so Sync writes the
+// deletion of the empty key, which trees never use, as a synced write.
+func (ls *Store) Sync() error {
+	batch := new(leveldb.Batch)
+	batch.Delete(nil)
+	return ls.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// Begin starts a transaction that all following calls join until Commit or
+// Discard.
+func (ls *Store) Begin() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.pending != nil {
+		return smt.ErrTransactionInProgress
+	}
+	ls.pending = make(map[string][]byte)
+	return nil
+}
+
+// Commit writes the writes of the transaction started by Begin in a single
+// batch.
+func (ls *Store) Commit() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if ls.pending == nil {
+		return smt.ErrNoTransaction
+	}
+	batch := new(leveldb.Batch)
+	for key, record := range ls.pending {
+		if record == nil {
+			batch.Delete([]byte(key))
+		} else {
+			batch.Put([]byte(key), record)
+		}
+	}
+	ls.pending = nil
+	return ls.db.Write(batch, nil)
+}
+
+// Discard drops the writes of the transaction started by Begin, if any.
+func (ls *Store) Discard() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.pending = nil
+}
+
+// Close discards any pending transaction and closes the database.
+func (ls *Store) Close() error {
+	ls.Discard()
+	return ls.db.Close()
+}
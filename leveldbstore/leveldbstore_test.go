@@ -0,0 +1,269 @@
+package leveldbstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/memoio/smt"
+)
+
+func openTestLevelDBStore(t *testing.T, path string) *Store {
+	ls, err := New(path)
+	if err != nil {
+		t.Fatalf("failed to open LevelDB store: %v", err)
+	}
+	return ls
+}
+
+func TestLevelDBStore(t *testing.T) {
+	ls := openTestLevelDBStore(t, t.TempDir())
+	defer ls.Close()
+
+	// Tests for Get.
+	_, err := ls.Get([]byte("key"))
+	var invalidKeyError *smt.InvalidKeyError
+	if !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return an smt.InvalidKeyError when getting a non-existent key: %v", err)
+	}
+
+	// Tests for Put.
+	if err := ls.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	value, err := ls.Get([]byte("key"))
+	if err != nil {
+		t.Errorf("getting a key returned an error: %v", err)
+	}
+	if !bytes.Equal(value, []byte("hello")) {
+		t.Error("failed to update key")
+	}
+	has, err := ls.Has([]byte("key"))
+	if err != nil || !has {
+		t.Error("did not find an existing key")
+	}
+
+	// Tests for Delete with reference counting.
+	if err := ls.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if err := ls.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if value, err := ls.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("hello")) {
+		t.Error("key was deleted while still referenced")
+	}
+	if err := ls.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if has, _ := ls.Has([]byte("key")); has {
+		t.Error("failed to delete key")
+	}
+	if err := ls.Delete([]byte("nonexistent")); !errors.As(err, &invalidKeyError) {
+		t.Error("deleting a key did not return an error on a non-existent key")
+	}
+}
+
+func TestLevelDBStoreTransaction(t *testing.T) {
+	ls := openTestLevelDBStore(t, t.TempDir())
+	defer ls.Close()
+
+	// A discarded transaction leaves the database untouched.
+	if err := ls.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	if err := ls.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if has, _ := ls.Has([]byte("key")); !has {
+		t.Error("transaction did not see its own write")
+	}
+	ls.Discard()
+	if has, _ := ls.Has([]byte("key")); has {
+		t.Error("discarded write is visible")
+	}
+
+	// A committed transaction applies all of its writes.
+	tree := smt.NewSparseMerkleTree(ls, ls, sha256.New())
+	if err := ls.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	if err := ls.Begin(); err == nil {
+		t.Error("did not return an error when beginning a transaction twice")
+	}
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		if _, err := tree.Update([]byte(key), []byte("testValue")); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+	if _, err := tree.Delete([]byte("testKey3")); err != nil {
+		t.Errorf("returned error when deleting key: %v", err)
+	}
+	if err := ls.Commit(); err != nil {
+		t.Errorf("failed to commit transaction: %v", err)
+	}
+	if err := ls.Commit(); err == nil {
+		t.Error("did not return an error when committing without a transaction")
+	}
+	value, err := tree.Get([]byte("testKey2"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get correct value after commit")
+	}
+}
+
+func TestLevelDBStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	ls := openTestLevelDBStore(t, path)
+	tree := smt.NewSparseMerkleTree(ls, ls, sha256.New())
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		if _, err := tree.Update([]byte(key), []byte(key+"Value")); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+	root := tree.Root()
+	if err := ls.Close(); err != nil {
+		t.Fatalf("failed to close LevelDB store: %v", err)
+	}
+
+	ls = openTestLevelDBStore(t, path)
+	defer ls.Close()
+	tree, err := smt.OpenSparseMerkleTree(ls, ls, sha256.New(), root)
+	if err != nil {
+		t.Fatalf("failed to reopen tree: %v", err)
+	}
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		value, err := tree.Get([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, []byte(key+"Value")) {
+			t.Error("did not get correct value after reopening the store")
+		}
+	}
+}
+
+func TestLevelDBStoreSize(t *testing.T) {
+	ls := openTestLevelDBStore(t, t.TempDir())
+	defer ls.Close()
+
+	var store smt.Sizer = ls
+	checkSize := func(expected int64) {
+		t.Helper()
+		size, err := store.Size()
+		if err != nil {
+			t.Errorf("returned error when getting size: %v", err)
+		}
+		if size != expected {
+			t.Errorf("got size %d, expected %d", size, expected)
+		}
+	}
+	checkSize(0)
+	for _, key := range []string{"key", "key2", "key3"} {
+		ls.Put([]byte(key), []byte("hello"))
+	}
+	// A key put twice is counted once.
+	ls.Put([]byte("key"), []byte("hello"))
+	checkSize(3)
+	ls.Delete([]byte("key"))
+	checkSize(3)
+	ls.Delete([]byte("key"))
+	ls.Delete([]byte("key2"))
+	checkSize(1)
+
+	// Writes and deletes of a transaction are counted before it is committed.
+	if err := ls.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	ls.Put([]byte("key4"), []byte("hello"))
+	ls.Put([]byte("key5"), []byte("hello"))
+	ls.Delete([]byte("key3"))
+	checkSize(2)
+	if err := ls.Commit(); err != nil {
+		t.Errorf("failed to commit transaction: %v", err)
+	}
+	checkSize(2)
+}
+
+func TestLevelDBStoreSync(t *testing.T) {
+	ls := openTestLevelDBStore(t, t.TempDir())
+	defer ls.Close()
+
+	var store smt.Syncer = ls
+	ls.Put([]byte("key"), []byte("hello"))
+	if err := store.Sync(); err != nil {
+		t.Errorf("returned error when syncing: %v", err)
+	}
+	if value, err := ls.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("hello")) {
+		t.Error("did not keep the key when syncing")
+	}
+	if size, _ := ls.Size(); size != 1 {
+		t.Errorf("got size %d after syncing, expected 1", size)
+	}
+}
+
+func TestLevelDBStoreGarbageCollect(t *testing.T) {
+	ls := openTestLevelDBStore(t, t.TempDir())
+	defer ls.Close()
+	tree := smt.NewSparseMerkleTree(ls, ls, sha256.New())
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		tree.Update([]byte(key), []byte(key+"Value"))
+	}
+	tree.Update([]byte("testKey"), []byte("newValue"))
+
+	countKeys := func() int {
+		t.Helper()
+		count := 0
+		if err := ls.ForEachKey(func(key []byte) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatalf("returned error when iterating keys: %v", err)
+		}
+		return count
+	}
+	count := countKeys()
+	freed, err := tree.GarbageCollect([][]byte{tree.Root()})
+	if err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	if freed == 0 || countKeys() != count-freed {
+		t.Errorf("freed %d of %d keys, %d left", freed, count, countKeys())
+	}
+	if value, err := tree.Get([]byte("testKey")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Error("did not keep the current values")
+	}
+}
+
+func TestLevelDBStoreForEachKey(t *testing.T) {
+	ls := openTestLevelDBStore(t, t.TempDir())
+	defer ls.Close()
+
+	ls.Put([]byte("key"), []byte("hello"))
+	ls.Put([]byte("key2"), []byte("hello"))
+	// Writes and deletes of a transaction are iterated before it is committed.
+	if err := ls.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer ls.Discard()
+	ls.Put([]byte("key3"), []byte("hello"))
+	ls.Delete([]byte("key"))
+
+	var keys []string
+	if err := ls.ForEachKey(func(key []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}); err != nil {
+		t.Fatalf("returned error when iterating keys: %v", err)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "key2,key3" {
+		t.Errorf("got keys %v, expected key2 and key3", keys)
+	}
+}
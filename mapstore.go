@@ -1,7 +1,9 @@
 package smt
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 )
 
 // MapStore is a key-value store.
@@ -13,6 +15,41 @@ type MapStore interface {
 	Close() error
 }
 
+// Sizer is implemented by MapStores that can count their keys, such as the
+// disk-backed stores of the boltstore, badgerstore and leveldbstore packages.
+// SimpleMap has a Size method of its own that cannot fail.
+type Sizer interface {
+	Size() (int64, error) // Size returns the number of keys in the store.
+}
+
+// Syncer is implemented by MapStores that buffer writes, such as the
+// disk-backed stores of the boltstore, badgerstore and leveldbstore packages,
+// to make them durable. Flush calls it on the stores of a tree.
+type Syncer interface {
+	Sync() error // Sync makes the writes made so far durable.
+}
+
+// unwrapStore returns the store wrapped by store, following the Unwrap methods
+// of wrappers like CachedStore down to a store that wraps none, so that the
+// optional interfaces of a store, like Sizer and Syncer, are found under the
+// wrappers added by the options of a tree.
+func unwrapStore(store MapStore) MapStore {
+	for {
+		wrapper, ok := store.(interface{ Unwrap() MapStore })
+		if !ok {
+			return store
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// sameStore reports whether a and b are the same store. Only pointers are
+// compared, as other stores may be of types that cannot be compared, and such
+// stores are taken to be distinct.
+func sameStore(a, b MapStore) bool {
+	return reflect.ValueOf(a).Kind() == reflect.Ptr && a == b
+}
+
 // InvalidKeyError is thrown when a key that does not exist is being accessed.
 type InvalidKeyError struct {
 	Key []byte
@@ -85,11 +122,152 @@ func (sm *SimpleMap) Delete(key []byte) error {
 	return &InvalidKeyError{Key: key}
 }
 
+// ForEachKey calls fn for every key in the map, stopping at the first error
+// returned by fn.
+func (sm *SimpleMap) ForEachKey(fn func(key []byte) error) error {
+	for key := range sm.m {
+		if err := fn([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (sm *SimpleMap) Size() int64 {
 	return int64(len(sm.m))
 }
 
+// Sync does nothing, as a SimpleMap is not durable.
+func (sm *SimpleMap) Sync() error {
+	return nil
+}
+
 func (sm *SimpleMap) Close() error {
 	sm.m = nil
 	return nil
 }
+
+// NullStore is a MapStore that holds nothing: Puts are discarded and every key
+// is missing. It is meant for trees only used for their hashing, such as to
+// compute paths or verify proofs, which never read back their nodes.
+type NullStore struct{}
+
+// Get returns an InvalidKeyError for every key.
+func (NullStore) Get(key []byte) ([]byte, error) {
+	return nil, &InvalidKeyError{Key: key}
+}
+
+// Put discards the value.
+func (NullStore) Put(key []byte, value []byte) error {
+	return nil
+}
+
+func (NullStore) Has(key []byte) (bool, error) {
+	return false, nil
+}
+
+// Delete returns an InvalidKeyError for every key.
+func (NullStore) Delete(key []byte) error {
+	return &InvalidKeyError{Key: key}
+}
+
+func (NullStore) Close() error {
+	return nil
+}
+
+// ErrReadOnly is returned by the writes to a ReadOnlyStore.
+var ErrReadOnly = errors.New("read-only store")
+
+// ReadOnlyStore is a MapStore passing reads through to another MapStore and
+// rejecting writes with ErrReadOnly, so that a tree over it can be queried but
+// not updated.
+type ReadOnlyStore struct {
+	store MapStore
+}
+
+// NewReadOnlyStore creates a new ReadOnlyStore reading from store.
+func NewReadOnlyStore(store MapStore) *ReadOnlyStore {
+	return &ReadOnlyStore{store: store}
+}
+
+// Get gets the value for a key.
+func (rs *ReadOnlyStore) Get(key []byte) ([]byte, error) {
+	return rs.store.Get(key)
+}
+
+// Put returns ErrReadOnly.
+func (rs *ReadOnlyStore) Put(key []byte, value []byte) error {
+	return ErrReadOnly
+}
+
+func (rs *ReadOnlyStore) Has(key []byte) (bool, error) {
+	return rs.store.Has(key)
+}
+
+// Delete returns ErrReadOnly.
+func (rs *ReadOnlyStore) Delete(key []byte) error {
+	return ErrReadOnly
+}
+
+// Close closes the wrapped store.
+func (rs *ReadOnlyStore) Close() error {
+	return rs.store.Close()
+}
+
+// PrefixStore is a MapStore prepending a prefix to the keys of another
+// MapStore, so that several trees can share one backend. Stores sharing a
+// backend must use prefixes none of which is a prefix of another, e.g. of the
+// same size, or their keys may collide.
+type PrefixStore struct {
+	store  MapStore
+	prefix []byte
+}
+
+// NewPrefixStore creates a new PrefixStore prepending prefix to the keys of
+// store.
+func NewPrefixStore(store MapStore, prefix []byte) *PrefixStore {
+	return &PrefixStore{store: store, prefix: append([]byte(nil), prefix...)}
+}
+
+// key returns the key of the underlying store for key.
+func (ps *PrefixStore) key(key []byte) []byte {
+	prefixed := make([]byte, 0, len(ps.prefix)+len(key))
+	prefixed = append(prefixed, ps.prefix...)
+	return append(prefixed, key...)
+}
+
+// Get gets the value for a key. Errors for missing keys hold the key without
+// the prefix.
+func (ps *PrefixStore) Get(key []byte) ([]byte, error) {
+	value, err := ps.store.Get(ps.key(key))
+	var invalidKeyError *InvalidKeyError
+	if errors.As(err, &invalidKeyError) {
+		return nil, &InvalidKeyError{Key: key}
+	}
+	return value, err
+}
+
+// Put updates the value for a key.
+func (ps *PrefixStore) Put(key []byte, value []byte) error {
+	return ps.store.Put(ps.key(key), value)
+}
+
+func (ps *PrefixStore) Has(key []byte) (bool, error) {
+	return ps.store.Has(ps.key(key))
+}
+
+// Delete deletes a key. Errors for missing keys hold the key without the
+// prefix.
+func (ps *PrefixStore) Delete(key []byte) error {
+	err := ps.store.Delete(ps.key(key))
+	var invalidKeyError *InvalidKeyError
+	if errors.As(err, &invalidKeyError) {
+		return &InvalidKeyError{Key: key}
+	}
+	return err
+}
+
+// Close does nothing, as the underlying store is shared; close it directly.
+func (ps *PrefixStore) Close() error {
+	return nil
+}
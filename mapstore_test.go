@@ -3,6 +3,7 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"testing"
 )
 
@@ -47,3 +48,217 @@ func TestSimpleMap(t *testing.T) {
 		t.Error("deleting a key did not return an error on a non-existent key")
 	}
 }
+
+// Test that every Put adds a reference, even with the same value, so that a
+// node shared by several versions of a tree is kept until every version
+// deleting it is pruned.
+func TestSimpleMapReferences(t *testing.T) {
+	sm := NewSimpleMap()
+	sm.Put([]byte("key"), []byte("value"))
+	sm.Put([]byte("key"), []byte("value"))
+	if count := sm.m["key"].count; count != 2 {
+		t.Errorf("got count %d after putting the same value twice, expected 2", count)
+	}
+
+	sm.Delete([]byte("key"))
+	if value, err := sm.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("value")) {
+		t.Error("did not keep the key after deleting one of its references")
+	}
+	sm.Delete([]byte("key"))
+	if has, _ := sm.Has([]byte("key")); has {
+		t.Error("did not free the key after deleting every reference")
+	}
+	if err := sm.Delete([]byte("key")); err == nil {
+		t.Error("did not return an error when deleting a freed key")
+	}
+}
+
+// Test that deleting a key more times than it was put does not underflow its
+// count: the extra Delete fails, and the key starts afresh when put again.
+func TestSimpleMapOverDelete(t *testing.T) {
+	sm := NewSimpleMap()
+	sm.Put([]byte("key"), []byte("value"))
+	if err := sm.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	var invalidKeyError *InvalidKeyError
+	if err := sm.Delete([]byte("key")); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return an InvalidKeyError when deleting a key twice: %v", err)
+	}
+	if sm.Size() != 0 {
+		t.Error("kept a key deleted twice")
+	}
+
+	sm.Put([]byte("key"), []byte("value"))
+	if count := sm.m["key"].count; count != 1 {
+		t.Errorf("got count %d after putting a key deleted twice, expected 1", count)
+	}
+}
+
+func TestNullStore(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	proof, _ := smt.Prove([]byte("testKey"))
+
+	null := NewSparseMerkleTree(NullStore{}, NullStore{}, sha256.New())
+	if !bytes.Equal(null.Path([]byte("testKey")), smt.Path([]byte("testKey"))) {
+		t.Error("got a different path from a NullStore tree")
+	}
+	if !VerifyProof(proof, smt.Root(), []byte("testKey"), []byte("testValue"), null.th.hasher) {
+		t.Error("proof failed to verify with the hasher of a NullStore tree")
+	}
+
+	// The first update of a tree reads nothing, so it computes the right root.
+	root, err := null.Update([]byte("testKey"), []byte("testValue"))
+	if err != nil {
+		t.Errorf("returned error when updating NullStore tree: %v", err)
+	}
+	single := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	single.Update([]byte("testKey"), []byte("testValue"))
+	if !bytes.Equal(root, single.Root()) {
+		t.Error("got a different root from a NullStore tree")
+	}
+
+	var invalidKeyError *InvalidKeyError
+	if _, err := (NullStore{}).Get(root); !errors.As(err, &invalidKeyError) {
+		t.Error("NullStore kept a node")
+	}
+	if has, _ := (NullStore{}).Has(root); has {
+		t.Error("NullStore has a node")
+	}
+}
+
+func TestReadOnlyStore(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	root := smt.Root()
+	nodes, values := smn.Size(), smv.Size()
+
+	readOnly := ImportSparseMerkleTree(NewReadOnlyStore(smn), NewReadOnlyStore(smv), sha256.New(), root)
+	value, err := readOnly.Get([]byte("testKey"))
+	if err != nil || !bytes.Equal(value, []byte("testValue")) {
+		t.Errorf("did not get correct value from read-only tree: %v", err)
+	}
+	proof, err := readOnly.Prove([]byte("testKey2"))
+	if err != nil {
+		t.Errorf("returned error when proving key of read-only tree: %v", err)
+	}
+	if !VerifyProof(proof, root, []byte("testKey2"), []byte("testValue2"), sha256.New()) {
+		t.Error("proof from read-only tree failed to verify")
+	}
+
+	if _, err := readOnly.Update([]byte("testKey3"), []byte("testValue3")); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("did not return ErrReadOnly when updating read-only tree: %v", err)
+	}
+	if !bytes.Equal(readOnly.Root(), root) {
+		t.Error("failed update changed the root of read-only tree")
+	}
+	if smn.Size() != nodes || smv.Size() != values {
+		t.Error("read-only tree wrote to the underlying stores")
+	}
+}
+
+func TestPrefixStore(t *testing.T) {
+	backend := NewSimpleMap()
+	storeA, storeB := NewPrefixStore(backend, []byte("a")), NewPrefixStore(backend, []byte("b"))
+	smtA := NewSparseMerkleTree(storeA, storeA, sha256.New())
+	smtB := NewSparseMerkleTree(storeB, storeB, sha256.New())
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	// Both trees hold the same key, with a different value.
+	smtA.Update([]byte("testKey"), []byte("testValueA"))
+	smtB.Update([]byte("testKey"), []byte("testValueB"))
+	smtA.Update([]byte("testKey2"), []byte("testValue2"))
+	plain.Update([]byte("testKey"), []byte("testValueA"))
+	plain.Update([]byte("testKey2"), []byte("testValue2"))
+	if !bytes.Equal(smtA.Root(), plain.Root()) {
+		t.Error("tree over a PrefixStore has a different root")
+	}
+	if bytes.Equal(smtA.Root(), smtB.Root()) {
+		t.Error("trees over different prefixes have the same root")
+	}
+
+	for _, c := range []struct {
+		smt   *SparseMerkleTree
+		value string
+	}{{smtA, "testValueA"}, {smtB, "testValueB"}} {
+		value, err := c.smt.Get([]byte("testKey"))
+		if err != nil || !bytes.Equal(value, []byte(c.value)) {
+			t.Errorf("did not get correct value through PrefixStore: %v", err)
+		}
+	}
+	if has, _ := smtB.Has([]byte("testKey2")); has {
+		t.Error("tree over a PrefixStore sees a key of another prefix")
+	}
+
+	// Deleting from one tree leaves the other alone.
+	smtB.Delete([]byte("testKey"))
+	if value, err := smtA.Get([]byte("testKey")); err != nil || !bytes.Equal(value, []byte("testValueA")) {
+		t.Error("deleting from one prefix changed another")
+	}
+
+	var invalidKeyError *InvalidKeyError
+	if _, err := storeA.Get([]byte("missing")); !errors.As(err, &invalidKeyError) || !bytes.Equal(invalidKeyError.Key, []byte("missing")) {
+		t.Errorf("did not return an InvalidKeyError with the unprefixed key: %v", err)
+	}
+}
+
+// optionalStore is a SimpleMap implementing all the optional interfaces of
+// MapStores.
+type optionalStore struct {
+	*SimpleMap
+	syncs int
+}
+
+func (os *optionalStore) Size() (int64, error) {
+	return os.SimpleMap.Size(), nil
+}
+
+func (os *optionalStore) Sync() error {
+	os.syncs++
+	return nil
+}
+
+// wrappingOptions returns the options wrapping the stores of a tree, alone and
+// all together.
+func wrappingOptions() map[string][]Option {
+	options := map[string][]Option{
+		"WithNodeCache": {WithNodeCache(16)},
+		"WithObserver":  {WithObserver(&recordingObserver{})},
+		"WithTracer":    {WithTracer(&recordingTracer{})},
+	}
+	var all []Option
+	for _, opts := range options {
+		all = append(all, opts...)
+	}
+	options["all"] = all
+	return options
+}
+
+func TestUnwrapStore(t *testing.T) {
+	for name, opts := range wrappingOptions() {
+		t.Run(name, func(t *testing.T) {
+			store := &optionalStore{SimpleMap: NewSimpleMap()}
+			smt := NewSparseMerkleTree(store, store, sha256.New(), opts...)
+			if smt.nodes == MapStore(store) {
+				t.Fatal("did not wrap the node store")
+			}
+			if unwrapStore(smt.nodes) != store || unwrapStore(smt.values) != store {
+				t.Error("did not unwrap the stores")
+			}
+
+			// The Sizer is found under the wrappers.
+			smt.Update([]byte("testKey"), []byte("testValue"))
+			stats, err := smt.Stats()
+			if err != nil {
+				t.Fatalf("returned error when getting stats: %v", err)
+			}
+			if size, _ := store.Size(); stats.NodeCount != size || stats.NodeCountWalked {
+				t.Error("did not take the node count from the Sizer")
+			}
+		})
+	}
+}
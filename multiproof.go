@@ -0,0 +1,321 @@
+package smt
+
+import (
+	"bytes"
+	"hash"
+	"sort"
+)
+
+// MultiProof is a Merkle proof for several keys of a SparseMerkleTree at once.
+// Unlike separate proofs, it holds every node needed to verify the keys only
+// once, and leaves out the nodes that can be computed from the keys
+// themselves, so the side nodes shared by keys near the root appear once.
+//
+// The proof describes the part of the tree on the paths of the keys, visited
+// depth-first from the root with the keys sorted by path.
+type MultiProof struct {
+	// SideNodes are the roots of the subtrees next to the paths of the keys
+	// that hold none of the keys, in the order they are visited.
+	SideNodes [][]byte
+
+	// NonMembershipLeafData are the data of the unrelated leaves where the
+	// paths of keys without a value end, in the order they are visited. Paths
+	// ending in an empty subtree have a nil entry.
+	NonMembershipLeafData [][]byte
+
+	// Shape has a bit for every node visited on the paths of the keys, set if
+	// the node is an inner node rather than a leaf or an empty subtree. Bits
+	// are numbered like in PresenceBitmap.
+	Shape []byte
+}
+
+// ProveMulti generates a MultiProof for keys against the current root. Each
+// key may have a value or not, as with Prove.
+func (smt *SparseMerkleTree) ProveMulti(keys [][]byte) (*MultiProof, error) {
+	paths := make([][]byte, len(keys))
+	for i, key := range keys {
+		path, err := smt.keyPath(key)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = path
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return bytes.Compare(paths[i], paths[j]) < 0
+	})
+
+	root := smt.Root()
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	proof := &MultiProof{}
+	var shape []bool
+	if err := smt.proveMulti(root, 0, paths, proof, &shape); err != nil {
+		return nil, err
+	}
+	proof.Shape = emptyBytes((len(shape) + 7) / 8)
+	for i, inner := range shape {
+		if inner {
+			setBitAtFromMSB(proof.Shape, i)
+		}
+	}
+	return proof, nil
+}
+
+// proveMulti adds the subtree at hash, on the paths of paths, sorted and
+// sharing their first height bits, to proof, and its bits to shape.
+func (smt *SparseMerkleTree) proveMulti(hash []byte, height int, paths [][]byte, proof *MultiProof, shape *[]bool) error {
+	if len(paths) == 0 {
+		proof.SideNodes = append(proof.SideNodes, hash)
+		return nil
+	}
+	if bytes.Equal(hash, smt.th.placeholder()) {
+		*shape = append(*shape, false)
+		proof.NonMembershipLeafData = append(proof.NonMembershipLeafData, nil)
+		return nil
+	}
+
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+	if smt.th.isLeaf(data) {
+		*shape = append(*shape, false)
+		leafPath, _, _ := smt.th.parseLeaf(data)
+		i := sort.Search(len(paths), func(i int) bool {
+			return bytes.Compare(paths[i], leafPath) >= 0
+		})
+		if i == len(paths) || !bytes.Equal(paths[i], leafPath) {
+			proof.NonMembershipLeafData = append(proof.NonMembershipLeafData, data)
+		}
+		return nil
+	}
+
+	*shape = append(*shape, true)
+	split := sort.Search(len(paths), func(i int) bool {
+		return getBitAtFromMSB(paths[i], height) == right
+	})
+	leftNode, rightNode := smt.th.parseNode(data)
+	if err := smt.proveMulti(leftNode, height+1, paths[:split], proof, shape); err != nil {
+		return err
+	}
+	return smt.proveMulti(rightNode, height+1, paths[split:], proof, shape)
+}
+
+// VerifyMultiProof verifies a MultiProof that each of keys has the value at
+// the same index of values, the default value for keys without a value. The
+// options must match the ones the tree was built with.
+func VerifyMultiProof(proof *MultiProof, root []byte, keys [][]byte, values [][]byte, hasher hash.Hash, options ...Option) bool {
+	th := treeHasherWithOptions(hasher, options)
+	if len(keys) != len(values) {
+		return false
+	}
+	ops := make([]batchOp, len(keys))
+	for i, key := range keys {
+		if th.checkValueSize(values[i]) != nil {
+			return false
+		}
+		ops[i] = batchOp{path: th.path(key), key: key, value: values[i]}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return bytes.Compare(ops[i].path, ops[j].path) < 0
+	})
+	// A key given several times must be given the same value.
+	for i := 1; i < len(ops); i++ {
+		if bytes.Equal(ops[i-1].path, ops[i].path) && !bytes.Equal(ops[i-1].value, ops[i].value) {
+			return false
+		}
+	}
+
+	v := multiProofVerifier{proof: proof, th: th}
+	computedRoot, ok := v.subtree(0, ops)
+	if !ok || v.sideNodes != len(proof.SideNodes) || v.leaves != len(proof.NonMembershipLeafData) {
+		return false
+	}
+	// Unused shape bits must be unset.
+	for i := v.shape; i < len(proof.Shape)*8; i++ {
+		if getBitAtFromMSB(proof.Shape, i) == 1 {
+			return false
+		}
+	}
+	return len(proof.Shape) == (v.shape+7)/8 && bytes.Equal(computedRoot, root)
+}
+
+// multiProofVerifier computes the root of a MultiProof, keeping track of the
+// parts of the proof already used.
+type multiProofVerifier struct {
+	proof     *MultiProof
+	th        *treeHasher
+	sideNodes int
+	leaves    int
+	shape     int
+}
+
+// subtree returns the root of the subtree on the paths of ops, sorted by path
+// and sharing their first height bits. It returns false if the proof is
+// malformed.
+func (v *multiProofVerifier) subtree(height int, ops []batchOp) ([]byte, bool) {
+	th := v.th
+	if len(ops) == 0 {
+		if v.sideNodes == len(v.proof.SideNodes) {
+			return nil, false
+		}
+		node := v.proof.SideNodes[v.sideNodes]
+		v.sideNodes++
+		return node, len(node) == th.pathSize()
+	}
+
+	if v.shape == len(v.proof.Shape)*8 {
+		return nil, false
+	}
+	inner := getBitAtFromMSB(v.proof.Shape, v.shape) == 1
+	v.shape++
+	if inner {
+		if height == th.pathSize()*8 {
+			return nil, false
+		}
+		split := sort.Search(len(ops), func(i int) bool {
+			return getBitAtFromMSB(ops[i].path, height) == right
+		})
+		leftNode, ok := v.subtree(height+1, ops[:split])
+		if !ok {
+			return nil, false
+		}
+		rightNode, ok := v.subtree(height+1, ops[split:])
+		if !ok {
+			return nil, false
+		}
+		hash, _ := th.digestNode(leftNode, rightNode)
+		return hash, true
+	}
+
+	// The subtree is a leaf or empty. At most one key, given once or more,
+	// has a value and is the leaf.
+	var member *batchOp
+	for i := range ops {
+		if th.isDefault(ops[i].value) {
+			continue
+		}
+		if member != nil && !bytes.Equal(member.path, ops[i].path) {
+			return nil, false
+		}
+		member = &ops[i]
+	}
+	if member != nil {
+		hash, _ := th.digestLeaf(member.path, th.digestValue(member.value))
+		return hash, true
+	}
+
+	if v.leaves == len(v.proof.NonMembershipLeafData) {
+		return nil, false
+	}
+	data := v.proof.NonMembershipLeafData[v.leaves]
+	v.leaves++
+	if data == nil {
+		return th.placeholder(), true
+	}
+	if len(data) != len(leafPrefix)+th.pathSize()+th.valueSize() || !th.isLeaf(data) {
+		return nil, false
+	}
+	leafPath, valueHash, _ := th.parseLeaf(data)
+	for _, op := range ops {
+		if bytes.Equal(op.path, leafPath) {
+			// This is not an unrelated leaf.
+			return nil, false
+		}
+	}
+	hash, _ := th.digestLeaf(leafPath, valueHash)
+	return hash, true
+}
+
+// CompactMultiProof is a MultiProof with the placeholders among its side
+// nodes left out, like a SparseCompactMerkleProof.
+type CompactMultiProof struct {
+	// SideNodes are the side nodes of the MultiProof that are not
+	// placeholders.
+	SideNodes [][]byte
+
+	// NonMembershipLeafData are as in MultiProof.
+	NonMembershipLeafData [][]byte
+
+	// Shape is as in MultiProof.
+	Shape []byte
+
+	// BitMask is a bit mask of the side nodes of the MultiProof where an
+	// on-bit indicates that the side node at the bit's index is a placeholder.
+	BitMask []byte
+
+	// NumSideNodes is the number of side nodes of the MultiProof.
+	NumSideNodes int
+}
+
+// ProveCompactMulti generates a CompactMultiProof for keys against the
+// current root.
+func (smt *SparseMerkleTree) ProveCompactMulti(keys [][]byte) (*CompactMultiProof, error) {
+	proof, err := smt.ProveMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	return compactMultiProof(proof, &smt.th), nil
+}
+
+func compactMultiProof(proof *MultiProof, th *treeHasher) *CompactMultiProof {
+	bitMask := emptyBytes((len(proof.SideNodes) + 7) / 8)
+	var compactedSideNodes [][]byte
+	for i, node := range proof.SideNodes {
+		if bytes.Equal(node, th.placeholder()) {
+			setBitAtFromMSB(bitMask, i)
+		} else {
+			compactedSideNodes = append(compactedSideNodes, node)
+		}
+	}
+	return &CompactMultiProof{
+		SideNodes:             compactedSideNodes,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		Shape:                 proof.Shape,
+		BitMask:               bitMask,
+		NumSideNodes:          len(proof.SideNodes),
+	}
+}
+
+// DecompactMultiProof decompacts a CompactMultiProof, so that it can be used
+// for VerifyMultiProof.
+func DecompactMultiProof(proof *CompactMultiProof, hasher hash.Hash, options ...Option) (*MultiProof, error) {
+	th := treeHasherWithOptions(hasher, options)
+	if proof.NumSideNodes < 0 || len(proof.BitMask) != (proof.NumSideNodes+7)/8 ||
+		len(proof.SideNodes) != proof.NumSideNodes-countSetBits(proof.BitMask) {
+		return nil, ErrBadProof
+	}
+	// Unused bits must be unset, or they would be counted above.
+	for i := proof.NumSideNodes; i < len(proof.BitMask)*8; i++ {
+		if getBitAtFromMSB(proof.BitMask, i) == 1 {
+			return nil, ErrBadProof
+		}
+	}
+
+	sideNodes := make([][]byte, proof.NumSideNodes)
+	position := 0
+	for i := range sideNodes {
+		if getBitAtFromMSB(proof.BitMask, i) == 1 {
+			sideNodes[i] = th.placeholder()
+		} else {
+			sideNodes[i] = proof.SideNodes[position]
+			position++
+		}
+	}
+	return &MultiProof{
+		SideNodes:             sideNodes,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		Shape:                 proof.Shape,
+	}, nil
+}
+
+// VerifyCompactMultiProof verifies a CompactMultiProof like VerifyMultiProof.
+func VerifyCompactMultiProof(proof *CompactMultiProof, root []byte, keys [][]byte, values [][]byte, hasher hash.Hash, options ...Option) bool {
+	decompacted, err := DecompactMultiProof(proof, hasher, options...)
+	if err != nil {
+		return false
+	}
+	return VerifyMultiProof(decompacted, root, keys, values, hasher, options...)
+}
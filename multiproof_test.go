@@ -0,0 +1,201 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestMultiProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 200; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, append([]byte("testValue"), key...))
+	}
+
+	// Prove 50 keys, 10 of which have no value.
+	var keys, values [][]byte
+	for i := 0; i < 50; i++ {
+		key := []byte(strconv.Itoa(i * 4))
+		value := append([]byte("testValue"), key...)
+		if i%5 == 0 {
+			key = []byte("absent" + strconv.Itoa(i))
+			value = defaultValue
+		}
+		keys, values = append(keys, key), append(values, value)
+	}
+	proof, err := smt.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys: %v", err)
+	}
+	if !VerifyMultiProof(proof, smt.Root(), keys, values, sha256.New()) {
+		t.Fatal("valid multiproof failed to verify")
+	}
+
+	// The multiproof agrees with single proofs and is smaller.
+	singleSize := 0
+	for i, key := range keys {
+		single, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(single, smt.Root(), key, values[i], sha256.New()) {
+			t.Error("valid single proof failed to verify")
+		}
+		singleSize += len(single.SideNodes)*sha256.Size + len(single.NonMembershipLeafData)
+	}
+	multiSize := len(proof.SideNodes)*sha256.Size + len(proof.Shape)
+	for _, data := range proof.NonMembershipLeafData {
+		multiSize += len(data)
+	}
+	if multiSize*2 > singleSize {
+		t.Errorf("multiproof of %d bytes is not much smaller than single proofs of %d bytes", multiSize, singleSize)
+	}
+	t.Logf("multiproof of %d bytes instead of %d bytes of single proofs", multiSize, singleSize)
+
+	// Keys may be given in any order, and several times.
+	reversed, reversedValues := make([][]byte, len(keys)), make([][]byte, len(keys))
+	for i := range keys {
+		reversed[len(keys)-1-i], reversedValues[len(keys)-1-i] = keys[i], values[i]
+	}
+	if !VerifyMultiProof(proof, smt.Root(), append(reversed, keys[1]), append(reversedValues, values[1]), sha256.New()) {
+		t.Error("valid multiproof failed to verify with reordered keys")
+	}
+
+	// Wrong values, keys and roots fail to verify.
+	wrong := append([][]byte(nil), values...)
+	wrong[1] = []byte("wrongValue")
+	if VerifyMultiProof(proof, smt.Root(), keys, wrong, sha256.New()) {
+		t.Error("multiproof verified with a wrong value")
+	}
+	wrong[1], wrong[0] = values[1], []byte("testValue0")
+	if VerifyMultiProof(proof, smt.Root(), keys, wrong, sha256.New()) {
+		t.Error("multiproof verified a value for an absent key")
+	}
+	wrong[0], wrong[1] = values[0], defaultValue
+	if VerifyMultiProof(proof, smt.Root(), keys, wrong, sha256.New()) {
+		t.Error("multiproof verified a present key as absent")
+	}
+	if VerifyMultiProof(proof, smt.Root(), keys[1:], values[1:], sha256.New()) {
+		t.Error("multiproof verified with a missing key")
+	}
+	if VerifyMultiProof(proof, smt.Root(), append(keys, keys[1]), append(values, []byte("wrongValue")), sha256.New()) {
+		t.Error("multiproof verified with a key given different values")
+	}
+	if VerifyMultiProof(proof, sha256.New().Sum(nil), keys, values, sha256.New()) {
+		t.Error("multiproof verified against a wrong root")
+	}
+
+	// Malformed proofs fail to verify.
+	malformed := *proof
+	malformed.SideNodes = proof.SideNodes[1:]
+	if VerifyMultiProof(&malformed, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof verified with a missing side node")
+	}
+	malformed = *proof
+	malformed.Shape = append(proof.Shape, 0)
+	if VerifyMultiProof(&malformed, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof verified with extra shape bits")
+	}
+	malformed = *proof
+	malformed.Shape = make([]byte, len(proof.Shape))
+	if VerifyMultiProof(&malformed, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof verified with a wrong shape")
+	}
+	malformed = *proof
+	malformed.NonMembershipLeafData = [][]byte{[]byte("data")}
+	if VerifyMultiProof(&malformed, smt.Root(), keys, values, sha256.New()) {
+		t.Error("multiproof verified with malformed leaf data")
+	}
+
+	// A tree holding a single leaf, and the empty tree.
+	single := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, update := range []bool{false, true} {
+		if update {
+			single.Update([]byte("testKey"), []byte("testValue"))
+		}
+		keys := [][]byte{[]byte("testKey"), []byte("testKey2")}
+		proof, err := single.ProveMulti(keys)
+		if err != nil {
+			t.Fatalf("returned error when proving keys: %v", err)
+		}
+		value := defaultValue
+		if update {
+			value = []byte("testValue")
+		}
+		if !VerifyMultiProof(proof, single.Root(), keys, [][]byte{value, defaultValue}, sha256.New()) {
+			t.Error("valid multiproof of small tree failed to verify")
+		}
+	}
+}
+
+func TestCompactMultiProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 1000; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, append([]byte("testValue"), key...))
+	}
+
+	// Prove 100 keys, 20 of which have no value.
+	var keys, values [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i * 7))
+		value := append([]byte("testValue"), key...)
+		if i%5 == 0 {
+			key = []byte("absent" + strconv.Itoa(i))
+			value = defaultValue
+		}
+		keys, values = append(keys, key), append(values, value)
+	}
+	proof, err := smt.ProveCompactMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys: %v", err)
+	}
+	if !VerifyCompactMultiProof(proof, smt.Root(), keys, values, sha256.New()) {
+		t.Fatal("valid compact multiproof failed to verify")
+	}
+	wrong := append([][]byte(nil), values...)
+	wrong[1] = []byte("wrongValue")
+	if VerifyCompactMultiProof(proof, smt.Root(), keys, wrong, sha256.New()) {
+		t.Error("compact multiproof verified with a wrong value")
+	}
+
+	// The compact multiproof is the multiproof without its placeholders.
+	multi, err := smt.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("returned error when proving keys: %v", err)
+	}
+	placeholders := 0
+	for _, node := range multi.SideNodes {
+		if bytes.Equal(node, smt.th.placeholder()) {
+			placeholders++
+		}
+	}
+	if placeholders == 0 {
+		t.Fatal("multiproof has no placeholders to compact")
+	}
+	leafSize := 0
+	for _, data := range multi.NonMembershipLeafData {
+		leafSize += len(data)
+	}
+	multiSize := len(multi.SideNodes)*sha256.Size + len(multi.Shape) + leafSize
+	compactSize := len(proof.SideNodes)*sha256.Size + len(proof.Shape) + len(proof.BitMask) + leafSize
+	if compactSize != multiSize-placeholders*sha256.Size+len(proof.BitMask) {
+		t.Errorf("compact multiproof of %d bytes did not leave out the %d placeholders of %d bytes", compactSize, placeholders, multiSize)
+	}
+	t.Logf("compact multiproof of %d bytes instead of %d bytes", compactSize, multiSize)
+
+	// Malformed bit masks are rejected.
+	malformed := *proof
+	malformed.BitMask = append([]byte(nil), proof.BitMask...)
+	malformed.BitMask[len(malformed.BitMask)-1] ^= 1
+	if VerifyCompactMultiProof(&malformed, smt.Root(), keys, values, sha256.New()) {
+		t.Error("compact multiproof verified with a flipped bit")
+	}
+	malformed = *proof
+	malformed.NumSideNodes++
+	if VerifyCompactMultiProof(&malformed, smt.Root(), keys, values, sha256.New()) {
+		t.Error("compact multiproof verified with a wrong number of side nodes")
+	}
+}
@@ -0,0 +1,61 @@
+package smt
+
+import (
+	"time"
+)
+
+// Observer is notified of the store accesses and updates of a tree, e.g. to
+// export metrics. It is set with WithObserver, and must be safe for concurrent
+// use if the tree is used concurrently.
+type Observer interface {
+	// OnGet is called for every read from the node or value store.
+	OnGet(key []byte)
+	// OnPut is called for every write to the node or value store.
+	OnPut(key []byte)
+	// OnUpdate is called after every Update, Delete, UpdateByPath or
+	// UpdateBatch of the current root, successful or not, with the time it
+	// took.
+	OnUpdate(duration time.Duration)
+}
+
+// WithObserver makes the tree notify observer of its store accesses and
+// updates. Trees without an observer do not check the time of their updates.
+func WithObserver(observer Observer) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.nodes = &observedStore{MapStore: smt.nodes, observer: observer}
+		smt.values = &observedStore{MapStore: smt.values, observer: observer}
+		smt.observer = observer
+	}
+}
+
+// observedStore is a MapStore notifying an Observer of its reads and writes.
+type observedStore struct {
+	MapStore
+	observer Observer
+}
+
+func (obs *observedStore) Get(key []byte) ([]byte, error) {
+	obs.observer.OnGet(key)
+	return obs.MapStore.Get(key)
+}
+
+func (obs *observedStore) Put(key []byte, value []byte) error {
+	obs.observer.OnPut(key)
+	return obs.MapStore.Put(key, value)
+}
+
+func (obs *observedStore) Unwrap() MapStore {
+	return obs.MapStore
+}
+
+// observeUpdate returns a function notifying the observer of the tree, if any,
+// of an update that started when observeUpdate was called.
+func (smt *SparseMerkleTree) observeUpdate() func() {
+	if smt.observer == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		smt.observer.OnUpdate(time.Since(start))
+	}
+}
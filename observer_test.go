@@ -0,0 +1,62 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// recordingObserver is an Observer counting its notifications.
+type recordingObserver struct {
+	gets, puts, updates int
+}
+
+func (ro *recordingObserver) OnGet(key []byte) { ro.gets++ }
+
+func (ro *recordingObserver) OnPut(key []byte) { ro.puts++ }
+
+func (ro *recordingObserver) OnUpdate(duration time.Duration) { ro.updates++ }
+
+func TestWithObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithObserver(observer))
+	for i := 0; i < 50; i++ {
+		key := []byte(strconv.Itoa(i))
+		*observer = recordingObserver{}
+		if _, err := smt.Update(key, []byte("testValue"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+		proof, _ := smt.Prove(key)
+		// An update puts a node for every level down to the leaf, so the
+		// depth of the leaf plus one, and the value.
+		if observer.puts != len(proof.SideNodes)+2 {
+			t.Errorf("got %d puts for update %d, expected %d", observer.puts, i, len(proof.SideNodes)+2)
+		}
+		if observer.updates != 1 {
+			t.Errorf("got %d updates for update %d, expected 1", observer.updates, i)
+		}
+	}
+
+	proof, _ := smt.Prove([]byte("0"))
+	*observer = recordingObserver{}
+	if _, err := smt.Get([]byte("0")); err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	// A read gets every node down to the leaf, and the value.
+	if observer.gets != len(proof.SideNodes)+2 || observer.puts != 0 || observer.updates != 0 {
+		t.Errorf("got %d gets, %d puts and %d updates for a read", observer.gets, observer.puts, observer.updates)
+	}
+
+	*observer = recordingObserver{}
+	smt.UpdateBatch([][]byte{[]byte("0"), []byte("1")}, [][]byte{[]byte("newValue"), []byte("newValue")})
+	if observer.updates != 1 {
+		t.Errorf("got %d updates for a batch, expected 1", observer.updates)
+	}
+
+	*observer = recordingObserver{}
+	smt.UpdateByPath(smt.Path([]byte("0")), []byte("pathValue"))
+	if observer.updates != 1 {
+		t.Errorf("got %d updates for an update by path, expected 1", observer.updates)
+	}
+}
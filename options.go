@@ -1,4 +1,92 @@
 package smt
 
+import (
+	"hash"
+)
+
 // Option is a function that configures SMT.
 type Option func(*SparseMerkleTree)
+
+// WithPathHasher sets the hasher used to derive the path of a leaf from its
+// key, instead of the tree hasher. It must produce digests of the same size as
+// the tree hasher.
+//
+// The path hasher only decides where a key is placed, so it never weakens the
+// commitment to the values themselves. It does however identify keys: with a
+// hasher that is not collision resistant, two keys sharing a path overwrite
+// each other and a proof for one is also a proof for the other.
+func WithPathHasher(hasher hash.Hash) Option {
+	return func(smt *SparseMerkleTree) {
+		if hasher.Size() != smt.th.hasher.Size() {
+			panic("smt: path hasher size must match the tree hasher size")
+		}
+		smt.th.pathHasher = hasher
+	}
+}
+
+// WithValueHasher sets the hasher used to digest leaf values, instead of the
+// tree hasher.
+//
+// The value digest is what the root commits to, so the tree is only as binding
+// as the weaker of the value hasher and the tree hasher. Proofs must be
+// verified with the same option.
+func WithValueHasher(hasher hash.Hash) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.valueHasher = hasher
+	}
+}
+
+// WithFixedKeyLength makes the tree reject keys that are not exactly n bytes
+// long with an InvalidKeyLengthError, before any hashing or store access.
+func WithFixedKeyLength(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.keyLength = n
+	}
+}
+
+// WithMaxValueSize makes the tree reject values larger than n bytes with
+// ErrValueTooLarge, both when updating and when verifying proofs. When
+// verifying untrusted proofs, values are rejected before they are hashed, so
+// that oversized values cannot be used to waste CPU.
+func WithMaxValueSize(n int) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.maxValueSize = n
+	}
+}
+
+// WithDefaultValue sets the value of keys that are not set, instead of the
+// empty value. Updating a key to the default value, or to nil, deletes it, so
+// the default value itself cannot be stored while every other value, including
+// the empty one, can. Proofs must be verified with the same option.
+func WithDefaultValue(value []byte) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.th.defaultValue = value
+	}
+}
+
+// treeHasherWithOptions returns the tree hasher that a tree built with the
+// given hasher and options would use. This lets package-level functions, such
+// as proof verifiers, honour the same options as the tree.
+func treeHasherWithOptions(hasher hash.Hash, options []Option) *treeHasher {
+	smt := SparseMerkleTree{th: *newTreeHasher(hasher)}
+	for _, option := range options {
+		option(&smt)
+	}
+	return &smt.th
+}
+
+// WithReadGuard makes every tree configured with the same Option value share
+// the guard that keeps pruning of a root from running concurrently with reads
+// at that root. Each tree otherwise only guards against its own pruning, so
+// this is needed when a root is pruned by another tree over the same stores,
+// e.g.:
+//
+//	guard := smt.WithReadGuard()
+//	reader := smt.ImportSparseMerkleTree(nodes, values, sha256.New(), root, guard)
+//	pruner := smt.ImportSparseMerkleTree(nodes, values, sha256.New(), root, guard)
+func WithReadGuard() Option {
+	rg := newReadGuard()
+	return func(smt *SparseMerkleTree) {
+		smt.reads = rg
+	}
+}
@@ -0,0 +1,211 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"testing"
+)
+
+// Test a tree using separate hashers for paths and values.
+func TestSparseMerkleTreeSeparateHashers(t *testing.T) {
+	options := []Option{WithPathHasher(sha512.New512_256()), WithValueHasher(sha512.New())}
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New(), options...)
+
+	keys := []string{"testKey1", "testKey2", "testKey3", "foo"}
+	for _, key := range keys {
+		if _, err := smt.Update([]byte(key), []byte("value of "+key)); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+
+	expectedPath := sha512.Sum512_256([]byte("foo"))
+	if !bytes.Equal(smt.th.path([]byte("foo")), expectedPath[:]) {
+		t.Error("path was not derived with the path hasher")
+	}
+
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, key := range keys {
+		plain.Update([]byte(key), []byte("value of "+key))
+	}
+	if bytes.Equal(smt.Root(), plain.Root()) {
+		t.Error("separate hashers did not change the root")
+	}
+
+	for _, key := range append(keys, "absent") {
+		value := []byte("value of " + key)
+		if key == "absent" {
+			value = defaultValue
+		}
+		got, err := smt.Get([]byte(key))
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Error("did not get correct value")
+		}
+
+		proof, err := smt.Prove([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), []byte(key), value, sha256.New(), options...) {
+			t.Error("valid proof failed to verify")
+		}
+		if key != "absent" && VerifyProof(proof, smt.Root(), []byte(key), value, sha256.New()) {
+			t.Error("proof verified without the tree's hashers")
+		}
+
+		compactProof, err := smt.ProveCompact([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyCompactProof(compactProof, smt.Root(), []byte(key), value, sha256.New(), options...) {
+			t.Error("valid compact proof failed to verify")
+		}
+	}
+
+	// Deep subtrees honour the same options.
+	proof, _ := smt.ProveUpdatable([]byte("foo"))
+	dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), smt.Root(), options...)
+	if err := dsmst.AddBranch(proof, []byte("foo"), []byte("value of foo")); err != nil {
+		t.Errorf("returned error when adding branch to deep subtree: %v", err)
+	}
+	value, err := dsmst.Get([]byte("foo"))
+	if err != nil {
+		t.Errorf("returned error when getting value in deep subtree: %v", err)
+	}
+	if !bytes.Equal(value, []byte("value of foo")) {
+		t.Error("did not get correct value in deep subtree")
+	}
+}
+
+func TestWithFixedKeyLength(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithFixedKeyLength(8))
+
+	if _, err := smt.Update([]byte("testKey1"), []byte("testValue")); err != nil {
+		t.Errorf("returned error when updating key of correct length: %v", err)
+	}
+	value, err := smt.Get([]byte("testKey1"))
+	if err != nil {
+		t.Errorf("returned error when getting key of correct length: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get correct value")
+	}
+
+	root := smt.Root()
+	for _, key := range []string{"", "testKey", "testKey12"} {
+		var lengthErr *InvalidKeyLengthError
+		if _, err := smt.Update([]byte(key), []byte("testValue")); !errors.As(err, &lengthErr) {
+			t.Errorf("did not reject update of key %q: %v", key, err)
+		} else if lengthErr.Length != 8 || !bytes.Equal(lengthErr.Key, []byte(key)) {
+			t.Error("error did not describe the rejected key")
+		}
+		if _, err := smt.Get([]byte(key)); !errors.As(err, &lengthErr) {
+			t.Errorf("did not reject get of key %q: %v", key, err)
+		}
+		if _, err := smt.Delete([]byte(key)); !errors.As(err, &lengthErr) {
+			t.Errorf("did not reject delete of key %q: %v", key, err)
+		}
+		if _, err := smt.Prove([]byte(key)); !errors.As(err, &lengthErr) {
+			t.Errorf("did not reject proof of key %q: %v", key, err)
+		}
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("rejected keys changed the root")
+	}
+}
+
+func TestWithMaxValueSize(t *testing.T) {
+	limit := WithMaxValueSize(8)
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), limit)
+
+	if _, err := smt.Update([]byte("testKey"), []byte("testValue")); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("did not return ErrValueTooLarge when updating large value: %v", err)
+	}
+	if _, err := smt.Update([]byte("testKey"), []byte("value")); err != nil {
+		t.Errorf("returned error when updating value: %v", err)
+	}
+
+	// Proofs from a tree without the limit.
+	plain := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	plain.Update([]byte("testKey"), []byte("testValue"))
+	plain.Update([]byte("testKey2"), []byte("value"))
+	proof, _ := plain.Prove([]byte("testKey"))
+
+	if err := CheckProof(proof, plain.Root(), []byte("testKey"), []byte("testValue"), sha256.New()); err != nil {
+		t.Errorf("valid proof failed to verify: %v", err)
+	}
+	if err := CheckProof(proof, plain.Root(), []byte("testKey"), []byte("testValue"), sha256.New(), limit); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("did not return ErrValueTooLarge when verifying large value: %v", err)
+	}
+	if VerifyProof(proof, plain.Root(), []byte("testKey"), []byte("testValue"), sha256.New(), limit) {
+		t.Error("proof of large value verified")
+	}
+	if err := CheckProof(proof, plain.Root(), []byte("testKey"), []byte("wrong"), sha256.New(), limit); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof when verifying wrong value: %v", err)
+	}
+	proof, _ = plain.Prove([]byte("testKey2"))
+	if err := CheckProof(proof, plain.Root(), []byte("testKey2"), []byte("value"), sha256.New(), limit); err != nil {
+		t.Errorf("valid proof of small value failed to verify: %v", err)
+	}
+
+	proof, _ = plain.ProveUpdatable([]byte("testKey"))
+	dsmst := NewDeepSparseMerkleSubTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), plain.Root(), limit)
+	if err := dsmst.AddBranch(proof, []byte("testKey"), []byte("testValue")); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("did not return ErrValueTooLarge when adding branch with large value: %v", err)
+	}
+}
+
+func TestWithDefaultValue(t *testing.T) {
+	// With the empty default value, a zero byte is a value like any other.
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte{0})
+	smt.Update([]byte("testKey2"), []byte("testValue"))
+	smt.Delete([]byte("testKey2"))
+	if has, _ := smt.Has([]byte("testKey")); !has {
+		t.Error("did not have key set to a zero byte")
+	}
+	if has, _ := smt.Has([]byte("testKey2")); has {
+		t.Error("had deleted key")
+	}
+
+	empty := WithDefaultValue([]byte{0})
+	smt = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), empty)
+	smt.Update([]byte("testKey"), []byte{})
+	smt.Update([]byte("testKey2"), []byte("testValue"))
+	smt.Delete([]byte("testKey2"))
+	if has, _ := smt.Has([]byte("testKey")); !has {
+		t.Error("did not have key set to the empty value")
+	}
+	value, _ := smt.Get([]byte("testKey"))
+	if value == nil || len(value) != 0 {
+		t.Error("did not get the empty value")
+	}
+	if has, _ := smt.Has([]byte("testKey2")); has {
+		t.Error("had deleted key")
+	}
+	if value, _ := smt.GetDescend([]byte("testKey2")); !bytes.Equal(value, []byte{0}) {
+		t.Error("did not get the default value for a deleted key")
+	}
+
+	// Setting the default value deletes the key.
+	root := smt.Root()
+	smt.Update([]byte("testKey3"), []byte("testValue"))
+	smt.Update([]byte("testKey3"), []byte{0})
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("did not delete the key set to the default value")
+	}
+
+	proof, _ := smt.Prove([]byte("testKey"))
+	if !VerifyProof(proof, root, []byte("testKey"), []byte{}, sha256.New(), empty) {
+		t.Error("proof of the empty value failed to verify")
+	}
+	proof, _ = smt.ProveNonMembership([]byte("testKey2"))
+	if !VerifyNonMembershipProof(proof, root, []byte("testKey2"), sha256.New(), empty) {
+		t.Error("non-membership proof failed to verify")
+	}
+}
@@ -0,0 +1,156 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+)
+
+// PathProof is a Merkle proof bundled with the root it was generated against
+// and the path of the proven key, so that it can be handed to clients that do
+// not know the tree, such as web clients.
+//
+// It is encoded to JSON with hex strings:
+//
+//	{
+//	    "root": "...",
+//	    "path": "...",
+//	    "sideNodes": ["...", ...],
+//	    "nonMembershipLeafData": "...",
+//	    "siblingData": "..."
+//	}
+//
+// where nonMembershipLeafData and siblingData are omitted when empty.
+type PathProof struct {
+	// Root is the root the proof was generated against.
+	Root []byte
+
+	// Path is the path of the proven key.
+	Path []byte
+
+	// Proof is the Merkle proof of the key against Root.
+	Proof SparseMerkleProof
+}
+
+// ProvePath generates a Merkle proof for a key against the current root,
+// bundled with the root and the path of the key.
+func (smt *SparseMerkleTree) ProvePath(key []byte) (*PathProof, error) {
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	root := smt.Root()
+	proof, err := smt.ProveForRoot(key, root)
+	if err != nil {
+		return nil, err
+	}
+	return &PathProof{Root: root, Path: path, Proof: proof}, nil
+}
+
+// VerifyPathProof verifies the Merkle proof of a path proof against its root,
+// and that its path is the path of key. The options must match the ones the
+// tree was built with.
+func VerifyPathProof(proof *PathProof, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	th := treeHasherWithOptions(hasher, options)
+	if !bytes.Equal(proof.Path, th.path(key)) {
+		return false
+	}
+	return VerifyProof(proof.Proof, proof.Root, key, value, hasher, options...)
+}
+
+// jsonPathProof is the JSON structure of a PathProof.
+type jsonPathProof struct {
+	Root hexBytes `json:"root"`
+	Path hexBytes `json:"path"`
+	jsonProof
+}
+
+// MarshalJSON encodes the proof as JSON.
+func (proof *PathProof) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPathProof{
+		Root:      proof.Root,
+		Path:      proof.Path,
+		jsonProof: newJSONProof(proof.Proof),
+	})
+}
+
+// UnmarshalJSON decodes a proof encoded by MarshalJSON. It returns
+// ErrMalformedEncoding if the path or a side node does not have the size of
+// the root.
+func (proof *PathProof) UnmarshalJSON(data []byte) error {
+	var decoded jsonPathProof
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedEncoding, err)
+	}
+	size := len(decoded.Root)
+	if size == 0 || len(decoded.Path) != size {
+		return fmt.Errorf("%w: inconsistent key size", ErrMalformedEncoding)
+	}
+	merkleProof, err := decoded.proof(size)
+	if err != nil {
+		return err
+	}
+
+	*proof = PathProof{Root: decoded.Root, Path: decoded.Path, Proof: merkleProof}
+	return nil
+}
+
+// jsonProof is the JSON structure of a SparseMerkleProof, embedded in the JSON
+// structures of PathProof and ProofBundle.
+type jsonProof struct {
+	SideNodes             []hexBytes `json:"sideNodes"`
+	NonMembershipLeafData hexBytes   `json:"nonMembershipLeafData,omitempty"`
+	SiblingData           hexBytes   `json:"siblingData,omitempty"`
+}
+
+func newJSONProof(proof SparseMerkleProof) jsonProof {
+	sideNodes := make([]hexBytes, len(proof.SideNodes))
+	for i, sideNode := range proof.SideNodes {
+		sideNodes[i] = sideNode
+	}
+	return jsonProof{
+		SideNodes:             sideNodes,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		SiblingData:           proof.SiblingData,
+	}
+}
+
+// proof returns the decoded proof. It returns ErrMalformedEncoding if a side
+// node does not have the given size.
+func (decoded jsonProof) proof(size int) (SparseMerkleProof, error) {
+	var sideNodes [][]byte
+	for _, sideNode := range decoded.SideNodes {
+		if len(sideNode) != size {
+			return SparseMerkleProof{}, fmt.Errorf("%w: inconsistent key size", ErrMalformedEncoding)
+		}
+		sideNodes = append(sideNodes, sideNode)
+	}
+	return SparseMerkleProof{
+		SideNodes:             sideNodes,
+		NonMembershipLeafData: decoded.NonMembershipLeafData,
+		SiblingData:           decoded.SiblingData,
+	}, nil
+}
+
+// hexBytes is a byte slice encoded to JSON as a hex string. Empty strings are
+// decoded as nil.
+type hexBytes []byte
+
+func (b hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func (b *hexBytes) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*b = nil
+		return nil
+	}
+	decoded, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
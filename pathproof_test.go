@@ -0,0 +1,63 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// goldenPathProof is the JSON encoding of the proof of testKey in a tree
+// holding testKey and testKey2.
+const goldenPathProof = `{"root":"e197bf412f9c87d77f96405e90dc7150b9390baff218c15bef7289aca8b61d37","path":"15291f67d99ea7bc578c3544dadfbb991e66fa69cb36ff70fe30e798e111ff5f","sideNodes":["4e9d5498b7776bbb7d1dffe29d730aaee05f4727d187da3836727884d6f8f744"]}`
+
+func TestPathProofJSON(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	proof, err := smt.ProvePath([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when proving key: %v", err)
+	}
+	data, err := json.Marshal(proof)
+	if err != nil {
+		t.Errorf("returned error when marshalling proof: %v", err)
+	}
+	if string(data) != goldenPathProof {
+		t.Errorf("got JSON %s, want %s", data, goldenPathProof)
+	}
+
+	var decoded PathProof
+	if err := json.Unmarshal([]byte(goldenPathProof), &decoded); err != nil {
+		t.Errorf("returned error when unmarshalling proof: %v", err)
+	}
+	if !VerifyPathProof(&decoded, []byte("testKey"), []byte("testValue"), sha256.New()) {
+		t.Error("decoded proof failed to verify")
+	}
+	if VerifyPathProof(&decoded, []byte("testKey2"), []byte("testValue"), sha256.New()) {
+		t.Error("decoded proof verified for another key")
+	}
+
+	// Non-membership proofs survive the round trip too.
+	proof, _ = smt.ProvePath([]byte("testKey3"))
+	data, _ = json.Marshal(proof)
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Errorf("returned error when unmarshalling proof: %v", err)
+	}
+	if !VerifyPathProof(&decoded, []byte("testKey3"), defaultValue, sha256.New()) {
+		t.Error("decoded non-membership proof failed to verify")
+	}
+
+	for _, data := range []string{
+		`{"root":"e197","path":"1529","sideNodes":["4e9d5498"]}`,
+		`{"root":"e197","path":"152901","sideNodes":[]}`,
+		`{"root":"","path":"","sideNodes":[]}`,
+		`{"root":"zz","path":"15","sideNodes":[]}`,
+		`[]`,
+	} {
+		if err := json.Unmarshal([]byte(data), &decoded); !errors.Is(err, ErrMalformedEncoding) {
+			t.Errorf("did not return ErrMalformedEncoding for malformed JSON %s: %v", data, err)
+		}
+	}
+}
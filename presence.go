@@ -0,0 +1,85 @@
+package smt
+
+import (
+	"bytes"
+	"sort"
+)
+
+// PresenceBitmap checks which of the given keys have a leaf, and therefore a
+// non-default value, in the tree, like calling Has for each key. It returns a bitmap of
+// ceil(len(keys)/8) bytes where bit i is set iff keys[i] is present, bits
+// being numbered from the most significant bit of the first byte: bit i is
+// bit 7-i%8 of byte i/8.
+//
+// The keys are looked up together, so every node on the way to several keys
+// is read from the store only once, which is much faster than separate Has
+// calls for large lists of keys.
+func (smt *SparseMerkleTree) PresenceBitmap(keys [][]byte) ([]byte, error) {
+	bitmap := emptyBytes((len(keys) + 7) / 8)
+	root := smt.Root()
+	if len(keys) == 0 || bytes.Equal(root, smt.th.placeholder()) {
+		return bitmap, nil
+	}
+
+	lookups := make([]presenceLookup, len(keys))
+	for i, key := range keys {
+		path, err := smt.keyPath(key)
+		if err != nil {
+			return nil, err
+		}
+		lookups[i] = presenceLookup{path: path, index: i}
+	}
+	sort.Slice(lookups, func(i, j int) bool {
+		return bytes.Compare(lookups[i].path, lookups[j].path) < 0
+	})
+
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+	if err := smt.presence(root, 0, lookups, bitmap); err != nil {
+		return nil, err
+	}
+	return bitmap, nil
+}
+
+// presenceLookup is the lookup of a key by PresenceBitmap.
+type presenceLookup struct {
+	path  []byte
+	index int
+}
+
+// presence looks up the paths of lookups, sorted by path and sharing their
+// first height bits, in the subtree at hash, setting the bits of the ones with
+// a leaf in bitmap.
+func (smt *SparseMerkleTree) presence(hash []byte, height int, lookups []presenceLookup, bitmap []byte) error {
+	data, err := smt.getNode(hash)
+	if err != nil {
+		return err
+	}
+
+	if smt.th.isLeaf(data) {
+		path, _, _ := smt.th.parseLeaf(data)
+		for _, lookup := range lookups {
+			if bytes.Equal(lookup.path, path) {
+				setBitAtFromMSB(bitmap, lookup.index)
+			}
+		}
+		return nil
+	}
+
+	split := sort.Search(len(lookups), func(i int) bool {
+		return getBitAtFromMSB(lookups[i].path, height) == right
+	})
+	leftNode, rightNode := smt.th.parseNode(data)
+	if split > 0 && !bytes.Equal(leftNode, smt.th.placeholder()) {
+		if err := smt.presence(leftNode, height+1, lookups[:split], bitmap); err != nil {
+			return err
+		}
+	}
+	if split < len(lookups) && !bytes.Equal(rightNode, smt.th.placeholder()) {
+		if err := smt.presence(rightNode, height+1, lookups[split:], bitmap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestPresenceBitmap(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	bitmap, err := smt.PresenceBitmap([][]byte{[]byte("testKey0")})
+	if err != nil {
+		t.Errorf("returned error when checking presence in empty tree: %v", err)
+	}
+	if !bytes.Equal(bitmap, []byte{0}) {
+		t.Errorf("got bitmap %x for empty tree", bitmap)
+	}
+
+	for i := 0; i < 50; i += 2 {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	smt.Delete([]byte("testKey4"))
+
+	var keys [][]byte
+	for i := 0; i < 50; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("testKey%d", i)))
+	}
+	keys = append(keys, []byte("testKey0"))
+	bitmap, err = smt.PresenceBitmap(keys)
+	if err != nil {
+		t.Errorf("returned error when checking presence: %v", err)
+	}
+	if len(bitmap) != 7 {
+		t.Errorf("got bitmap of %d bytes, expected 7", len(bitmap))
+	}
+	for i, key := range keys {
+		has, _ := smt.Has(key)
+		if present := getBitAtFromMSB(bitmap, i) == 1; present != has {
+			t.Errorf("bit %d is %v, expected %v", i, present, has)
+		}
+	}
+	if bitmap[0] != 0b10100010 {
+		t.Errorf("got first bitmap byte %08b, expected 10100010", bitmap[0])
+	}
+}
@@ -2,6 +2,7 @@ package smt
 
 import (
 	"bytes"
+	"errors"
 	"hash"
 	"math"
 )
@@ -30,7 +31,7 @@ func (proof *SparseMerkleProof) sanityCheck(th *treeHasher) bool {
 	if len(proof.SideNodes) > th.pathSize()*8 ||
 
 		// Check that leaf data for non-membership proofs is the correct size.
-		(proof.NonMembershipLeafData != nil && len(proof.NonMembershipLeafData) != len(leafPrefix)+th.pathSize()+th.hasher.Size()) {
+		(proof.NonMembershipLeafData != nil && len(proof.NonMembershipLeafData) != len(leafPrefix)+th.pathSize()+th.valueSize()) {
 		return false
 	}
 
@@ -97,32 +98,69 @@ func (proof *SparseCompactMerkleProof) sanityCheck(th *treeHasher) bool {
 	return true
 }
 
-// VerifyProof verifies a Merkle proof.
-func VerifyProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) bool {
-	result, _ := verifyProofWithUpdates(proof, root, key, value, hasher)
-	return result
+// VerifyProof verifies a Merkle proof. The options must match the ones the
+// tree was built with.
+func VerifyProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	return CheckProof(proof, root, key, value, hasher, options...) == nil
 }
 
-func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) (bool, [][][]byte) {
-	th := newTreeHasher(hasher)
-	path := th.path(key)
+// VerifyNonMembershipProof verifies a Merkle proof that a key has no value,
+// that is that the leaf slot at the path of the key is empty or holds the leaf
+// of another key. The options must match the ones the tree was built with.
+func VerifyNonMembershipProof(proof SparseMerkleProof, root []byte, key []byte, hasher hash.Hash, options ...Option) bool {
+	return VerifyProof(proof, root, key, treeHasherWithOptions(hasher, options).defaultValue, hasher, options...)
+}
 
-	if !proof.sanityCheck(th) {
+// CheckProof verifies a Merkle proof like VerifyProof, but returns why it
+// failed: ErrValueTooLarge if the value exceeds the size set with
+// WithMaxValueSize, or ErrBadProof if the proof does not verify.
+func CheckProof(proof SparseMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) error {
+	th := treeHasherWithOptions(hasher, options)
+	if err := th.checkValueSize(value); err != nil {
+		return err
+	}
+	if result, _ := verifyProofWithUpdates(proof, root, key, value, th); !result {
+		return ErrBadProof
+	}
+	return nil
+}
+
+func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, value []byte, th *treeHasher) (bool, [][][]byte) {
+	computedRoot, updates, ok := rootFromProof(proof, key, value, th)
+	if !ok {
 		return false, nil
 	}
+	return bytes.Equal(computedRoot, root), updates
+}
+
+// rootFromProof computes the root that a proof proves key to have value
+// against, and the nodes along the way. It returns false if the proof is
+// malformed.
+func rootFromProof(proof SparseMerkleProof, key []byte, value []byte, th *treeHasher) ([]byte, [][][]byte, bool) {
+	return rootFromProofAt(proof, key, value, 0, th)
+}
+
+// rootFromProofAt is like rootFromProof for a proof against the root of the
+// subtree at depth in the path of key, whose side nodes stop at that depth.
+func rootFromProofAt(proof SparseMerkleProof, key []byte, value []byte, depth int, th *treeHasher) ([]byte, [][][]byte, bool) {
+	path := th.path(key)
+
+	if !proof.sanityCheck(th) || len(proof.SideNodes) > th.pathSize()*8-depth {
+		return nil, nil, false
+	}
 
 	var updates [][][]byte
 
 	// Determine what the leaf hash should be.
 	var currentHash, currentData []byte
-	if bytes.Equal(value, defaultValue) { // Non-membership proof.
+	if th.isDefault(value) { // Non-membership proof.
 		if proof.NonMembershipLeafData == nil { // Leaf is a placeholder value.
 			currentHash = th.placeholder()
 		} else { // Leaf is an unrelated leaf.
 			actualPath, valueHash, _ := th.parseLeaf(proof.NonMembershipLeafData)
 			if bytes.Equal(actualPath, path) {
 				// This is not an unrelated leaf; non-membership proof failed.
-				return false, nil
+				return nil, nil, false
 			}
 			currentHash, currentData = th.digestLeaf(actualPath, valueHash)
 
@@ -131,7 +169,7 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 			updates = append(updates, update)
 		}
 	} else { // Membership proof.
-		valueHash := th.digest(value)
+		valueHash := th.digestValue(value)
 		currentHash, currentData = th.digestLeaf(path, valueHash)
 		update := make([][]byte, 2)
 		update[0], update[1] = currentHash, currentData
@@ -143,7 +181,7 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 		node := make([]byte, th.pathSize())
 		copy(node, proof.SideNodes[i])
 
-		if getBitAtFromMSB(path, len(proof.SideNodes)-1-i) == right {
+		if getBitAtFromMSB(path, depth+len(proof.SideNodes)-1-i) == right {
 			currentHash, currentData = th.digestNode(node, currentHash)
 		} else {
 			currentHash, currentData = th.digestNode(currentHash, node)
@@ -154,22 +192,85 @@ func verifyProofWithUpdates(proof SparseMerkleProof, root []byte, key []byte, va
 		updates = append(updates, update)
 	}
 
-	return bytes.Equal(currentHash, root), updates
+	return currentHash, updates, true
+}
+
+// ErrDeletionFromProof is returned by ComputeRootFromProof for deletions.
+var ErrDeletionFromProof = errors.New("cannot compute the root of a deletion from a proof")
+
+// ComputeRootFromProof computes the root that a tree would have after setting
+// key to newValue, from a proof for key against the current root of the tree,
+// without the tree itself. The proof must have been verified against the
+// current root first, as the result is meaningless otherwise. The options must
+// match the ones the tree was built with.
+//
+// Deletions, with the default value, return ErrDeletionFromProof: whether the
+// key has a value cannot be told from the proof alone. Use a
+// DeepSparseMerkleSubTree for them instead.
+func ComputeRootFromProof(proof SparseMerkleProof, key []byte, newValue []byte, hasher hash.Hash, options ...Option) ([]byte, error) {
+	th := treeHasherWithOptions(hasher, options)
+	if err := th.checkValueSize(newValue); err != nil {
+		return nil, err
+	}
+	if th.isDefault(newValue) {
+		return nil, ErrDeletionFromProof
+	}
+	if !proof.sanityCheck(th) {
+		return nil, ErrBadProof
+	}
+	path := th.path(key)
+	currentHash, _ := th.digestLeaf(path, th.digestValue(newValue))
+
+	if proof.NonMembershipLeafData != nil {
+		// The unrelated leaf in the slot of the key and the new leaf become
+		// the children of a node at the first height where their paths
+		// differ, with placeholders up to the slot.
+		actualPath, valueHash, _ := th.parseLeaf(proof.NonMembershipLeafData)
+		commonPrefixCount := countCommonPrefix(path, actualPath)
+		if commonPrefixCount == th.pathSize()*8 || commonPrefixCount < len(proof.SideNodes) {
+			return nil, ErrBadProof
+		}
+		leafHash, _ := th.digestLeaf(actualPath, valueHash)
+		if getBitAtFromMSB(path, commonPrefixCount) == right {
+			currentHash, _ = th.digestNode(leafHash, currentHash)
+		} else {
+			currentHash, _ = th.digestNode(currentHash, leafHash)
+		}
+		for height := commonPrefixCount - 1; height >= len(proof.SideNodes); height-- {
+			if getBitAtFromMSB(path, height) == right {
+				currentHash, _ = th.digestNode(th.placeholder(), currentHash)
+			} else {
+				currentHash, _ = th.digestNode(currentHash, th.placeholder())
+			}
+		}
+	}
+
+	for i, sideNode := range proof.SideNodes {
+		if getBitAtFromMSB(path, len(proof.SideNodes)-1-i) == right {
+			currentHash, _ = th.digestNode(sideNode, currentHash)
+		} else {
+			currentHash, _ = th.digestNode(currentHash, sideNode)
+		}
+	}
+	return currentHash, nil
 }
 
-// VerifyCompactProof verifies a compacted Merkle proof.
-func VerifyCompactProof(proof SparseCompactMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash) bool {
-	decompactedProof, err := DecompactProof(proof, hasher)
+// VerifyCompactProof verifies a compacted Merkle proof. The options must match
+// the ones the tree was built with.
+func VerifyCompactProof(proof SparseCompactMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	decompactedProof, err := DecompactProof(proof, hasher, options...)
 	if err != nil {
 		return false
 	}
-	return VerifyProof(decompactedProof, root, key, value, hasher)
+	return VerifyProof(decompactedProof, root, key, value, hasher, options...)
 }
 
 // CompactProof compacts a proof, to reduce its size.
-func CompactProof(proof SparseMerkleProof, hasher hash.Hash) (SparseCompactMerkleProof, error) {
-	th := newTreeHasher(hasher)
+func CompactProof(proof SparseMerkleProof, hasher hash.Hash, options ...Option) (SparseCompactMerkleProof, error) {
+	return compactProof(proof, treeHasherWithOptions(hasher, options))
+}
 
+func compactProof(proof SparseMerkleProof, th *treeHasher) (SparseCompactMerkleProof, error) {
 	if !proof.sanityCheck(th) {
 		return SparseCompactMerkleProof{}, ErrBadProof
 	}
@@ -196,8 +297,8 @@ func CompactProof(proof SparseMerkleProof, hasher hash.Hash) (SparseCompactMerkl
 }
 
 // DecompactProof decompacts a proof, so that it can be used for VerifyProof.
-func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash) (SparseMerkleProof, error) {
-	th := newTreeHasher(hasher)
+func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash, options ...Option) (SparseMerkleProof, error) {
+	th := treeHasherWithOptions(hasher, options)
 
 	if !proof.sanityCheck(th) {
 		return SparseMerkleProof{}, ErrBadProof
@@ -220,3 +321,107 @@ func DecompactProof(proof SparseCompactMerkleProof, hasher hash.Hash) (SparseMer
 		SiblingData:           proof.SiblingData,
 	}, nil
 }
+
+// SparseDirectionalMerkleProof is a Merkle proof for an element in a
+// SparseMerkleTree that records on which side of the path each side node is,
+// so that it can be verified without deriving the directions from the path of
+// the key.
+type SparseDirectionalMerkleProof struct {
+	// SideNodes is an array of the sibling nodes leading up to the leaf of the proof.
+	SideNodes [][]byte
+
+	// Directions is a bit mask of the sidenodes of the proof where an on-bit
+	// indicates that the sidenode at the bit's index is the left sibling.
+	Directions []byte
+
+	// NonMembershipLeafData is the data of the unrelated leaf at the position
+	// of the key being proven, in the case of a non-membership proof. For
+	// membership proofs, is nil.
+	NonMembershipLeafData []byte
+
+	// SiblingData is the data of the sibling node to the leaf being proven,
+	// required for updatable proofs. For unupdatable proofs, is nil.
+	SiblingData []byte
+}
+
+// DirectionalProof adds the directions of the side nodes of a proof for key.
+func DirectionalProof(proof SparseMerkleProof, key []byte, hasher hash.Hash, options ...Option) (SparseDirectionalMerkleProof, error) {
+	th := treeHasherWithOptions(hasher, options)
+	if !proof.sanityCheck(th) {
+		return SparseDirectionalMerkleProof{}, ErrBadProof
+	}
+
+	path := th.path(key)
+	directions := emptyBytes((len(proof.SideNodes) + 7) / 8)
+	for i := range proof.SideNodes {
+		if getBitAtFromMSB(path, len(proof.SideNodes)-1-i) == right {
+			setBitAtFromMSB(directions, i)
+		}
+	}
+	return SparseDirectionalMerkleProof{
+		SideNodes:             proof.SideNodes,
+		Directions:            directions,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		SiblingData:           proof.SiblingData,
+	}, nil
+}
+
+// VerifyDirectionalProof verifies a directional Merkle proof, folding the side
+// nodes on the sides given by the proof. The options must match the ones the
+// tree was built with.
+//
+// The leaf of a membership proof commits to the path of the key, so the
+// directions need not be checked against it. The empty slot or unrelated leaf
+// of a non-membership proof does not, so the directions of non-membership
+// proofs must match the path of the key.
+func VerifyDirectionalProof(proof SparseDirectionalMerkleProof, root []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	th := treeHasherWithOptions(hasher, options)
+	if th.checkValueSize(value) != nil {
+		return false
+	}
+	undirected := SparseMerkleProof{
+		SideNodes:             proof.SideNodes,
+		NonMembershipLeafData: proof.NonMembershipLeafData,
+		SiblingData:           proof.SiblingData,
+	}
+	if !undirected.sanityCheck(th) || len(proof.Directions) != (len(proof.SideNodes)+7)/8 {
+		return false
+	}
+	// Unused direction bits must be unset.
+	for i := len(proof.SideNodes); i < len(proof.Directions)*8; i++ {
+		if getBitAtFromMSB(proof.Directions, i) == 1 {
+			return false
+		}
+	}
+
+	path := th.path(key)
+	var currentHash []byte
+	if th.isDefault(value) { // Non-membership proof.
+		for i := range proof.SideNodes {
+			if getBitAtFromMSB(proof.Directions, i) != getBitAtFromMSB(path, len(proof.SideNodes)-1-i) {
+				return false
+			}
+		}
+		if proof.NonMembershipLeafData == nil {
+			currentHash = th.placeholder()
+		} else {
+			actualPath, valueHash, _ := th.parseLeaf(proof.NonMembershipLeafData)
+			if bytes.Equal(actualPath, path) {
+				// This is not an unrelated leaf.
+				return false
+			}
+			currentHash, _ = th.digestLeaf(actualPath, valueHash)
+		}
+	} else { // Membership proof.
+		currentHash, _ = th.digestLeaf(path, th.digestValue(value))
+	}
+
+	for i, sideNode := range proof.SideNodes {
+		if getBitAtFromMSB(proof.Directions, i) == 1 {
+			currentHash, _ = th.digestNode(sideNode, currentHash)
+		} else {
+			currentHash, _ = th.digestNode(currentHash, sideNode)
+		}
+	}
+	return bytes.Equal(currentHash, root)
+}
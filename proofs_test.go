@@ -2,9 +2,13 @@ package smt
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
 	"hash"
 	"math/rand"
+	"strconv"
 	"testing"
 )
 
@@ -279,3 +283,292 @@ func checkCompactEquivalence(t *testing.T, proof SparseMerkleProof, hasher hash.
 		t.Error("de-compacted proof does not match original proof")
 	}
 }
+
+func TestProveNonMembership(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	// Absence can be proven in the empty tree too.
+	for i := 0; i < 50; i++ {
+		if i%2 == 0 {
+			key := make([]byte, 8)
+			rand.Read(key)
+			smt.Update(key, []byte("testValue"))
+		}
+		key := make([]byte, 8)
+		rand.Read(key)
+		proof, err := smt.ProveNonMembership(key)
+		if err != nil {
+			t.Fatalf("returned error when proving absent key: %v", err)
+		}
+		if !VerifyNonMembershipProof(proof, smt.Root(), key, sha256.New()) {
+			t.Error("valid non-membership proof failed to verify")
+		}
+
+		// The proof no longer holds once the key is inserted.
+		smt.Update(key, []byte("testValue"))
+		if VerifyNonMembershipProof(proof, smt.Root(), key, sha256.New()) {
+			t.Error("non-membership proof verified for a present key")
+		}
+		if _, err := smt.ProveNonMembership(key); !errors.Is(err, ErrKeyPresent) {
+			t.Errorf("did not return ErrKeyPresent when proving a present key: %v", err)
+		}
+		proof, _ = smt.Prove(key)
+		if VerifyNonMembershipProof(proof, smt.Root(), key, sha256.New()) {
+			t.Error("membership proof verified as a non-membership proof")
+		}
+	}
+}
+
+func TestProofMarshal(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	// The proof of the empty tree has no side nodes.
+	proof, _ := smt.ProveUpdatable([]byte("testKey"))
+	checkProofMarshal(t, proof)
+
+	for i := 0; i < 20; i++ {
+		key := make([]byte, 8)
+		rand.Read(key)
+		smt.Update(key, []byte("testValue"))
+	}
+	for i := 0; i < 20; i++ {
+		key := make([]byte, 8)
+		rand.Read(key)
+		proof, _ := smt.ProveUpdatable(key)
+		decoded := checkProofMarshal(t, proof)
+		if !VerifyProof(decoded, smt.Root(), key, defaultValue, sha256.New()) {
+			t.Error("decoded proof failed to verify")
+		}
+	}
+
+	// Side nodes of different sizes cannot be encoded.
+	proof = SparseMerkleProof{SideNodes: [][]byte{make([]byte, 32), make([]byte, 31)}}
+	if _, err := proof.Marshal(); !errors.Is(err, ErrBadProof) {
+		t.Errorf("did not return ErrBadProof when encoding side nodes of different sizes: %v", err)
+	}
+
+	// Malformed encodings return errors instead of panicking.
+	proof, _ = smt.ProveUpdatable([]byte("testKey"))
+	data, _ := proof.Marshal()
+	for i := 0; i < len(data); i++ {
+		if _, err := UnmarshalProof(data[:i]); !errors.Is(err, ErrMalformedEncoding) {
+			t.Errorf("did not return ErrMalformedEncoding for truncated input: %v", err)
+		}
+	}
+	for _, data := range [][]byte{
+		{32, 1},                                 // Side node missing.
+		{0, 1, 0, 0},                            // Side nodes without size.
+		{1, 9, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 0}, // More side nodes than levels.
+		append(data, 0),                         // Trailing data.
+	} {
+		if _, err := UnmarshalProof(data); !errors.Is(err, ErrMalformedEncoding) {
+			t.Errorf("did not return ErrMalformedEncoding for malformed input: %v", err)
+		}
+	}
+}
+
+func checkProofMarshal(t *testing.T, proof SparseMerkleProof) SparseMerkleProof {
+	data, err := proof.Marshal()
+	if err != nil {
+		t.Fatalf("failed to encode proof: %v", err)
+	}
+	decoded, err := UnmarshalProof(data)
+	if err != nil {
+		t.Fatalf("failed to decode proof: %v", err)
+	}
+	if len(decoded.SideNodes) != len(proof.SideNodes) ||
+		!bytes.Equal(decoded.NonMembershipLeafData, proof.NonMembershipLeafData) ||
+		!bytes.Equal(decoded.SiblingData, proof.SiblingData) {
+		t.Fatal("decoded proof does not match original proof")
+	}
+	for i := range proof.SideNodes {
+		if !bytes.Equal(decoded.SideNodes[i], proof.SideNodes[i]) {
+			t.Fatal("decoded proof does not match original proof")
+		}
+	}
+	return decoded
+}
+
+func TestProveUpdatableWithValue(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	for _, key := range [][]byte{[]byte("testKey"), []byte("testKey2"), []byte("testKey3")} {
+		proof, value, err := smt.ProveUpdatableWithValue(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		expected, err := smt.Get(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			expected = defaultValue
+		}
+		if !bytes.Equal(value, expected) {
+			t.Errorf("got value %q, expected %q", value, expected)
+		}
+		if !VerifyProof(proof, smt.Root(), key, value, sha256.New()) {
+			t.Error("proof failed to verify against the returned value")
+		}
+	}
+}
+
+func TestComputeRootFromProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+
+	// Update present keys, and insert keys in empty slots and in slots of
+	// unrelated leaves.
+	for i := 0; i < 100; i += 3 {
+		key := []byte(strconv.Itoa(i))
+		newValue := []byte("newValue" + strconv.Itoa(i))
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		root, err := ComputeRootFromProof(proof, key, newValue, sha256.New())
+		if err != nil {
+			t.Errorf("returned error when computing root: %v", err)
+		}
+		smt.Update(key, newValue)
+		if !bytes.Equal(root, smt.Root()) {
+			t.Errorf("computed root differs from the root after updating key %d", i)
+		}
+	}
+
+	proof, _ := smt.Prove([]byte("0"))
+	if _, err := ComputeRootFromProof(proof, []byte("0"), defaultValue, sha256.New()); err != ErrDeletionFromProof {
+		t.Errorf("did not return ErrDeletionFromProof: %v", err)
+	}
+	proof.SideNodes = append(proof.SideNodes, []byte("malformed"))
+	if _, err := ComputeRootFromProof(proof, []byte("0"), []byte("newValue"), sha256.New()); err != ErrBadProof {
+		t.Errorf("did not return ErrBadProof for malformed proof: %v", err)
+	}
+}
+
+// Test that an inner node cannot be passed off as a leaf, as leaves and inner
+// nodes are hashed with different prefixes.
+func TestProofDomainSeparation(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+
+	// Take the inner node at the end of the side nodes of a key, and craft
+	// leaf data with the same children, which has the size of leaf data.
+	key := []byte("absent")
+	proof, _ := smt.Prove(key)
+	_, pathNodes, _, _, err := smt.sideNodesForRoot(context.Background(), smt.th.path(key), smt.Root(), false)
+	if err != nil {
+		t.Fatalf("returned error when getting side nodes: %v", err)
+	}
+	node := pathNodes[1]
+	data, _ := smn.Get(node)
+	if smt.th.isLeaf(data) {
+		t.Fatal("did not get an inner node")
+	}
+	crafted := append(append([]byte{}, leafPrefix...), data[len(nodePrefix):]...)
+	if len(crafted) != len(data) {
+		t.Fatal("crafted leaf data does not have the size of inner node data")
+	}
+
+	// Proving the absence of the key with the inner node as a leaf fails,
+	// whatever its children.
+	forged := SparseMerkleProof{
+		SideNodes:             proof.SideNodes[1:],
+		NonMembershipLeafData: crafted,
+	}
+	if VerifyProof(forged, smt.Root(), key, defaultValue, sha256.New()) {
+		t.Error("forged proof with an inner node as a leaf verified")
+	}
+	craftedPath, craftedValueHash, _ := smt.th.parseLeaf(crafted)
+	if hash, _ := smt.th.digestLeaf(craftedPath, craftedValueHash); bytes.Equal(hash, node) {
+		t.Error("crafted leaf has the hash of the inner node")
+	}
+}
+
+func TestDirectionalProof(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"+strconv.Itoa(i)))
+	}
+	root := smt.Root()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		value := defaultValue
+		if i < 50 {
+			value = []byte("testValue" + strconv.Itoa(i))
+		}
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		directional, err := DirectionalProof(proof, key, sha256.New())
+		if err != nil {
+			t.Fatalf("returned error when adding directions to proof: %v", err)
+		}
+		if VerifyDirectionalProof(directional, root, key, value, sha256.New()) != VerifyProof(proof, root, key, value, sha256.New()) {
+			t.Errorf("directional verification differs for key %d", i)
+		}
+		if !VerifyDirectionalProof(directional, root, key, value, sha256.New()) {
+			t.Errorf("directional proof failed to verify for key %d", i)
+		}
+		if VerifyDirectionalProof(directional, root, key, []byte("badValue"), sha256.New()) {
+			t.Error("directional proof verified with a wrong value")
+		}
+
+		// Flipping a direction breaks the proof.
+		if len(directional.SideNodes) > 0 {
+			flipped := directional
+			flipped.Directions = append([]byte(nil), directional.Directions...)
+			flipped.Directions[0] ^= 0x80
+			if VerifyDirectionalProof(flipped, root, key, value, sha256.New()) {
+				t.Errorf("directional proof verified with a flipped direction for key %d", i)
+			}
+		}
+	}
+
+	// Unused direction bits must be unset.
+	key := []byte("0")
+	proof, _ := smt.Prove(key)
+	directional, _ := DirectionalProof(proof, key, sha256.New())
+	directional.Directions[len(directional.Directions)-1] |= 1
+	if VerifyDirectionalProof(directional, root, key, []byte("testValue0"), sha256.New()) {
+		t.Error("directional proof verified with unused direction bits set")
+	}
+}
+
+func TestProofCrossHasher(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+	wide := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha512.New())
+	for i := 0; i < 10; i++ {
+		wide.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+
+	for _, key := range [][]byte{[]byte("1"), []byte("absent")} {
+		value := []byte("testValue")
+		if bytes.Equal(key, []byte("absent")) {
+			value = defaultValue
+		}
+		proof, _ := smt.Prove(key)
+		if err := CheckProof(proof, smt.Root(), key, value, sha512.New()); !errors.Is(err, ErrBadProof) {
+			t.Errorf("did not reject a sha256 proof with a sha512 verifier: %v", err)
+		}
+		if err := CheckProof(proof, wide.Root(), key, value, sha512.New()); !errors.Is(err, ErrBadProof) {
+			t.Errorf("did not reject a sha256 proof against a sha512 root: %v", err)
+		}
+		wideProof, _ := wide.Prove(key)
+		if err := CheckProof(wideProof, smt.Root(), key, value, sha256.New()); !errors.Is(err, ErrBadProof) {
+			t.Errorf("did not reject a sha512 proof with a sha256 verifier: %v", err)
+		}
+		if _, err := CompactProof(proof, sha512.New()); !errors.Is(err, ErrBadProof) {
+			t.Errorf("compacted a sha256 proof with a sha512 hasher: %v", err)
+		}
+	}
+}
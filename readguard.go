@@ -0,0 +1,118 @@
+package smt
+
+import (
+	"sync"
+)
+
+// readGuard keeps pruning of a root from running concurrently with reads at
+// that root. Reads hold the root for the duration of the call, and pruning
+// waits until no read holds it; reads that start while a root is being pruned
+// wait for the pruning to complete.
+//
+// Garbage collection, which drops every root but the live ones, holds all the
+// other roots at once.
+//
+// This only protects reads of a root from pruning of the same root. Pruning a
+// root while another root that shares its nodes is read is still unsafe, as
+// pruning assumes the nodes it removes are not referenced by roots in use.
+type readGuard struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	reading    map[string]int
+	pruning    map[string]int
+	collecting map[*collection]struct{}
+}
+
+// collection is a garbage collection in progress, keeping the live roots.
+type collection struct {
+	live map[string]struct{}
+}
+
+func newReadGuard() *readGuard {
+	rg := &readGuard{
+		reading:    make(map[string]int),
+		pruning:    make(map[string]int),
+		collecting: make(map[*collection]struct{}),
+	}
+	rg.cond = sync.NewCond(&rg.mu)
+	return rg
+}
+
+// read holds root for a read, and returns a function releasing it.
+func (rg *readGuard) read(root []byte) func() {
+	key := string(root)
+	return rg.hold(key, rg.reading, func() bool {
+		return rg.pruning[key] > 0 || rg.collected(key)
+	})
+}
+
+// prune holds root for pruning, and returns a function releasing it.
+func (rg *readGuard) prune(root []byte) func() {
+	key := string(root)
+	return rg.hold(key, rg.pruning, func() bool {
+		return rg.reading[key] > 0
+	})
+}
+
+// collect holds every root but liveRoots for garbage collection, and returns a
+// function releasing them.
+func (rg *readGuard) collect(liveRoots [][]byte) func() {
+	c := &collection{live: make(map[string]struct{}, len(liveRoots))}
+	for _, root := range liveRoots {
+		c.live[string(root)] = struct{}{}
+	}
+
+	rg.mu.Lock()
+	for rg.readingOutside(c) {
+		rg.cond.Wait()
+	}
+	rg.collecting[c] = struct{}{}
+	rg.mu.Unlock()
+
+	return func() {
+		rg.mu.Lock()
+		delete(rg.collecting, c)
+		rg.cond.Broadcast()
+		rg.mu.Unlock()
+	}
+}
+
+// collected reports whether a collection in progress holds the root at key.
+func (rg *readGuard) collected(key string) bool {
+	for c := range rg.collecting {
+		if _, ok := c.live[key]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readingOutside reports whether a root not kept by c is being read.
+func (rg *readGuard) readingOutside(c *collection) bool {
+	for key := range rg.reading {
+		if _, ok := c.live[key]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hold waits until blocked returns false, then adds a holder of the root at key
+// to holders.
+func (rg *readGuard) hold(key string, holders map[string]int, blocked func() bool) func() {
+	rg.mu.Lock()
+	for blocked() {
+		rg.cond.Wait()
+	}
+	holders[key]++
+	rg.mu.Unlock()
+
+	return func() {
+		rg.mu.Lock()
+		if holders[key]--; holders[key] == 0 {
+			delete(holders, key)
+			rg.cond.Broadcast()
+		}
+		rg.mu.Unlock()
+	}
+}
@@ -0,0 +1,236 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingStore is a thread-safe MapStore whose next Get call waits on a
+// channel when one is set.
+type blockingStore struct {
+	mu    sync.Mutex
+	sm    *SimpleMap
+	block chan struct{}
+}
+
+func (s *blockingStore) wait() {
+	s.mu.Lock()
+	block := s.block
+	s.block = nil
+	s.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+}
+
+func (s *blockingStore) Put(key []byte, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.Put(key, value)
+}
+
+func (s *blockingStore) Get(key []byte) ([]byte, error) {
+	s.wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.Get(key)
+}
+
+func (s *blockingStore) Has(key []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.Has(key)
+}
+
+func (s *blockingStore) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.Delete(key)
+}
+
+func (s *blockingStore) ForEachKey(fn func(key []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sm.ForEachKey(fn)
+}
+
+func (s *blockingStore) Close() error {
+	return nil
+}
+
+// Test that pruning a root waits for reads in progress at that root.
+func TestPruneWaitsForReads(t *testing.T) {
+	nodes, values := &blockingStore{sm: NewSimpleMap()}, &blockingStore{sm: NewSimpleMap()}
+	guard := WithReadGuard()
+	smt := NewSparseMerkleTree(nodes, values, sha256.New(), guard)
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	oldRoot := smt.Root()
+	smt.Update([]byte("testKey3"), []byte("testValue3b"))
+
+	// Hold a read at the old root in its first store access.
+	block := make(chan struct{})
+	nodes.block = block
+	read := make(chan []byte)
+	go func() {
+		value, err := smt.GetFromRoot([]byte("testKey3"), oldRoot)
+		if err != nil {
+			t.Errorf("returned error when reading pruned root: %v", err)
+		}
+		read <- value
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	pruned := make(chan struct{})
+	go func() {
+		pruner := ImportSparseMerkleTree(nodes, values, sha256.New(), smt.Root(), guard)
+		if err := pruner.RemovePath([]byte("testKey3"), oldRoot, smt.Root()); err != nil {
+			t.Errorf("returned error when pruning root: %v", err)
+		}
+		close(pruned)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-pruned:
+		t.Fatal("pruning did not wait for the read in progress")
+	default:
+	}
+
+	close(block)
+	if value := <-read; !bytes.Equal(value, []byte("testValue3")) {
+		t.Error("did not get correct value at the pruned root")
+	}
+	<-pruned
+
+	// Once pruned, the old value is gone but the current one is intact.
+	if _, err := smt.GetFromRoot([]byte("testKey3"), oldRoot); err == nil {
+		t.Error("did not return error when reading pruned path")
+	}
+	value, err := smt.Get([]byte("testKey3"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue3b")) {
+		t.Error("did not get correct value after pruning")
+	}
+}
+
+// Test concurrent reads at roots that are being pruned.
+func TestConcurrentReadsAndPruning(t *testing.T) {
+	nodes, values := &blockingStore{sm: NewSimpleMap()}, &blockingStore{sm: NewSimpleMap()}
+	guard := WithReadGuard()
+	smt := NewSparseMerkleTree(nodes, values, sha256.New(), guard)
+	var roots [][]byte
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+		roots = append(roots, smt.Root())
+	}
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte("updated"))
+		roots = append(roots, smt.Root())
+	}
+
+	// A background pruner drops old roots in order, while readers read them.
+	// Reads complete with the value at their root until pruning of the root
+	// starts; they never fail halfway through.
+	var pruning [20]atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pruner := ImportSparseMerkleTree(nodes, values, sha256.New(), smt.Root(), guard)
+		for i := 0; i < 20; i++ {
+			pruning[i].Store(true)
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			if err := pruner.RemovePath(key, roots[49+i], roots[50+i]); err != nil {
+				t.Errorf("returned error when pruning root: %v", err)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			reader := ImportSparseMerkleTree(nodes, values, sha256.New(), roots[49+i], guard)
+			for j := 0; j < 10; j++ {
+				value, err := reader.Get(key)
+				if pruning[i].Load() {
+					return
+				}
+				if err != nil {
+					t.Errorf("returned error when reading root: %v", err)
+				}
+				if !bytes.Equal(value, []byte(fmt.Sprintf("testValue%d", i))) {
+					t.Error("did not get correct value at root")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Test that garbage collection waits for reads in progress at the roots it
+// drops.
+func TestGarbageCollectWaitsForReads(t *testing.T) {
+	nodes, values := &blockingStore{sm: NewSimpleMap()}, &blockingStore{sm: NewSimpleMap()}
+	guard := WithReadGuard()
+	smt := NewSparseMerkleTree(nodes, values, sha256.New(), guard)
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	oldRoot := smt.Root()
+	smt.Update([]byte("testKey3"), []byte("testValue3b"))
+
+	// Hold a read at the old root in its first store access.
+	block := make(chan struct{})
+	nodes.block = block
+	read := make(chan []byte)
+	go func() {
+		value, err := smt.GetFromRoot([]byte("testKey3"), oldRoot)
+		if err != nil {
+			t.Errorf("returned error when reading collected root: %v", err)
+		}
+		read <- value
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	collected := make(chan struct{})
+	go func() {
+		collector := ImportSparseMerkleTree(nodes, values, sha256.New(), smt.Root(), guard)
+		if _, err := collector.GarbageCollect([][]byte{smt.Root()}); err != nil {
+			t.Errorf("returned error when collecting garbage: %v", err)
+		}
+		close(collected)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-collected:
+		t.Fatal("garbage collection did not wait for the read in progress")
+	default:
+	}
+
+	close(block)
+	if value := <-read; !bytes.Equal(value, []byte("testValue3")) {
+		t.Error("did not get correct value at the collected root")
+	}
+	<-collected
+
+	// Once collected, the old value is gone but the current one is intact.
+	if _, err := smt.GetFromRoot([]byte("testKey3"), oldRoot); err == nil {
+		t.Error("did not return error when reading collected root")
+	}
+	value, err := smt.Get([]byte("testKey3"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue3b")) {
+		t.Error("did not get correct value after collecting garbage")
+	}
+}
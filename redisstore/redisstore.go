@@ -0,0 +1,161 @@
+// Package redisstore provides an smt.MapStore backed by Redis.
+package redisstore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/memoio/smt"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPutScript stores the value and bumps its reference count in one step.
+var redisPutScript = redis.NewScript(`
+redis.call("SET", KEYS[1], ARGV[1])
+return redis.call("INCR", KEYS[2])
+`)
+
+// redisDeleteScript drops one reference to a key, removing the value once no
+// references remain. It returns -1 if the key does not exist.
+var redisDeleteScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+local count = redis.call("DECR", KEYS[2])
+if count <= 0 then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	return 0
+end
+return count
+`)
+
+// Store is an smt.MapStore backed by Redis, so that several tree instances can
+// share the same storage.
+//
+// Every key is stored as a Redis string under its hex encoding, prepended with
+// a configurable prefix. Like smt.SimpleMap, the store keeps a reference count
+// per key: Put increments it and Delete only removes the value once it drops to
+// zero. Both are applied atomically with Lua scripts.
+//
+// Every call is a network round trip and a tree operation performs one per
+// level it touches, so latency adds up quickly. Read-heavy deployments should
+// put a caching MapStore in front of it.
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// New creates a new Store over the given client, storing keys under the given
+// prefix.
+func New(client redis.UniversalClient, prefix string) *Store {
+	return &Store{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// valueKey wraps the encoded key in a hash tag so that the value and its
+// reference count land in the same slot on a Redis Cluster.
+func (rs *Store) valueKey(key []byte) string {
+	return rs.prefix + "{" + hex.EncodeToString(key) + "}"
+}
+
+func (rs *Store) countKey(key []byte) string {
+	return rs.valueKey(key) + ":refs"
+}
+
+// Get gets the value for a key.
+func (rs *Store) Get(key []byte) ([]byte, error) {
+	value, err := rs.client.Get(context.Background(), rs.valueKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return value, err
+}
+
+// Put updates the value for a key.
+func (rs *Store) Put(key []byte, value []byte) error {
+	keys := []string{rs.valueKey(key), rs.countKey(key)}
+	return redisPutScript.Run(context.Background(), rs.client, keys, value).Err()
+}
+
+// Has returns true if the key exists in the store.
+func (rs *Store) Has(key []byte) (bool, error) {
+	n, err := rs.client.Exists(context.Background(), rs.valueKey(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Delete deletes a key.
+func (rs *Store) Delete(key []byte) error {
+	keys := []string{rs.valueKey(key), rs.countKey(key)}
+	count, err := redisDeleteScript.Run(context.Background(), rs.client, keys).Int64()
+	if err != nil {
+		return err
+	}
+	if count < 0 {
+		return &smt.InvalidKeyError{Key: key}
+	}
+	return nil
+}
+
+// ForEachKey calls fn for every key in the store, stopping at the first error
+// returned by fn. Keys are found with SCAN, on every master of a Redis
+// Cluster, so keys written or deleted concurrently may or may not be seen.
+func (rs *Store) ForEachKey(fn func(key []byte) error) error {
+	var (
+		mu   sync.Mutex
+		keys [][]byte
+	)
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, redisGlobEscaper.Replace(rs.prefix)+"{*}", 0).Iterator()
+		for iter.Next(ctx) {
+			key, err := rs.parseValueKey(iter.Val())
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			keys = append(keys, key)
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+
+	ctx := context.Background()
+	var err error
+	if cluster, ok := rs.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	} else {
+		err = scan(ctx, rs.client)
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redisGlobEscaper escapes the special characters of the patterns of SCAN.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// parseValueKey returns the key stored under the Redis key of a value.
+func (rs *Store) parseValueKey(valueKey string) ([]byte, error) {
+	encoded := strings.TrimSuffix(strings.TrimPrefix(valueKey, rs.prefix+"{"), "}")
+	return hex.DecodeString(encoded)
+}
+
+// Close releases the connection pool of the underlying client.
+func (rs *Store) Close() error {
+	return rs.client.Close()
+}
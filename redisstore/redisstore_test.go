@@ -0,0 +1,134 @@
+package redisstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/memoio/smt"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisMapStore(t *testing.T, mr *miniredis.Miniredis, prefix string) *Store {
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	rs := New(client, prefix)
+	t.Cleanup(func() { rs.Close() })
+	return rs
+}
+
+func TestRedisMapStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rs := newTestRedisMapStore(t, mr, "tree:")
+
+	// Tests for Get.
+	_, err := rs.Get([]byte("key"))
+	var invalidKeyError *smt.InvalidKeyError
+	if !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return an smt.InvalidKeyError when getting a non-existent key: %v", err)
+	}
+
+	// Tests for Put.
+	if err := rs.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	value, err := rs.Get([]byte("key"))
+	if err != nil {
+		t.Errorf("getting a key returned an error: %v", err)
+	}
+	if !bytes.Equal(value, []byte("hello")) {
+		t.Error("failed to update key")
+	}
+	has, err := rs.Has([]byte("key"))
+	if err != nil || !has {
+		t.Error("did not find an existing key")
+	}
+
+	// Tests for Delete with reference counting.
+	if err := rs.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if err := rs.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if _, err := rs.Get([]byte("key")); err != nil {
+		t.Error("key was deleted while still referenced")
+	}
+	if err := rs.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if _, err := rs.Get([]byte("key")); err == nil {
+		t.Error("failed to delete key")
+	}
+	if len(mr.Keys()) != 0 {
+		t.Errorf("expected no keys left in redis, got %v", mr.Keys())
+	}
+	if err := rs.Delete([]byte("nonexistent")); !errors.As(err, &invalidKeyError) {
+		t.Error("deleting a key did not return an error on a non-existent key")
+	}
+
+	// Keys are namespaced by the prefix.
+	other := newTestRedisMapStore(t, mr, "other:")
+	if err := rs.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if has, _ := other.Has([]byte("key")); has {
+		t.Error("key leaked across prefixes")
+	}
+}
+
+func TestRedisMapStoreTree(t *testing.T) {
+	mr := miniredis.RunT(t)
+	tree := smt.NewSparseMerkleTree(newTestRedisMapStore(t, mr, "nodes:"), newTestRedisMapStore(t, mr, "values:"), sha256.New())
+
+	for _, key := range []string{"testKey1", "testKey2", "testKey3"} {
+		if _, err := tree.Update([]byte(key), []byte("value of "+key)); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+
+	// A second tree sharing the same redis sees the same state.
+	smt2 := smt.ImportSparseMerkleTree(newTestRedisMapStore(t, mr, "nodes:"), newTestRedisMapStore(t, mr, "values:"), sha256.New(), tree.Root())
+	for _, key := range []string{"testKey1", "testKey2", "testKey3"} {
+		value, err := smt2.Get([]byte(key))
+		if err != nil {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, []byte("value of "+key)) {
+			t.Error("did not get correct value from shared store")
+		}
+	}
+}
+
+func TestRedisMapStoreGarbageCollect(t *testing.T) {
+	rs := newTestRedisMapStore(t, miniredis.RunT(t), "smt[0]:")
+	tree := smt.NewSparseMerkleTree(rs, rs, sha256.New())
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		tree.Update([]byte(key), []byte(key+"Value"))
+	}
+	tree.Update([]byte("testKey"), []byte("newValue"))
+
+	countKeys := func() int {
+		t.Helper()
+		count := 0
+		if err := rs.ForEachKey(func(key []byte) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatalf("returned error when iterating keys: %v", err)
+		}
+		return count
+	}
+	count := countKeys()
+	freed, err := tree.GarbageCollect([][]byte{tree.Root()})
+	if err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	if freed == 0 || countKeys() != count-freed {
+		t.Errorf("freed %d of %d keys, %d left", freed, count, countKeys())
+	}
+	if value, err := tree.Get([]byte("testKey")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Error("did not keep the current values")
+	}
+}
@@ -0,0 +1,65 @@
+package smt
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// LeafKV is a leaf of a tree, given by its path and value.
+type LeafKV struct {
+	Path  []byte
+	Value []byte
+}
+
+// RootFromLeaves computes the root of a tree holding exactly the given leaves,
+// without a store. It is the root that a tree created with the same hasher and
+// options would have after updating every leaf by path. Leaves with a default
+// value are treated as absent. Paths must be unique and of the size of the
+// hasher, otherwise ErrInvalidPath is returned.
+func RootFromLeaves(leaves []LeafKV, hasher hash.Hash, options ...Option) ([]byte, error) {
+	th := treeHasherWithOptions(hasher, options)
+
+	sorted := make([]LeafKV, 0, len(leaves))
+	for _, leaf := range leaves {
+		if len(leaf.Path) != th.pathSize() {
+			return nil, ErrInvalidPath
+		}
+		if th.isDefault(leaf.Value) {
+			continue
+		}
+		sorted = append(sorted, leaf)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Path, sorted[j].Path) < 0
+	})
+	for i := 1; i < len(sorted); i++ {
+		if bytes.Equal(sorted[i-1].Path, sorted[i].Path) {
+			return nil, fmt.Errorf("%w: duplicate path %x", ErrInvalidPath, sorted[i].Path)
+		}
+	}
+
+	return rootFromSortedLeaves(th, sorted, 0), nil
+}
+
+// rootFromSortedLeaves folds leaves sorted by path, all sharing their first
+// height bits, into the root of their subtree. A lone leaf is the root of its
+// subtree, since the tree does not keep nodes with a single leaf beneath them.
+func rootFromSortedLeaves(th *treeHasher, leaves []LeafKV, height int) []byte {
+	switch len(leaves) {
+	case 0:
+		return th.placeholder()
+	case 1:
+		hash, _ := th.digestLeaf(leaves[0].Path, th.digestValue(leaves[0].Value))
+		return hash
+	}
+
+	split := sort.Search(len(leaves), func(i int) bool {
+		return getBitAtFromMSB(leaves[i].Path, height) == right
+	})
+	leftHash := rootFromSortedLeaves(th, leaves[:split], height+1)
+	rightHash := rootFromSortedLeaves(th, leaves[split:], height+1)
+	hash, _ := th.digestNode(leftHash, rightHash)
+	return hash
+}
@@ -0,0 +1,50 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRootFromLeaves(t *testing.T) {
+	root, err := RootFromLeaves(nil, sha256.New())
+	if err != nil {
+		t.Errorf("returned error when computing empty root: %v", err)
+	}
+	if !bytes.Equal(root, EmptyRoot(sha256.New())) {
+		t.Error("root of no leaves is not the empty root")
+	}
+
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var leaves []LeafKV
+	for i := 0; i < 100; i++ {
+		key, value := []byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i))
+		smt.Update(key, value)
+		leaves = append(leaves, LeafKV{Path: smt.th.path(key), Value: value})
+
+		root, err := RootFromLeaves(leaves, sha256.New())
+		if err != nil {
+			t.Errorf("returned error when computing root: %v", err)
+		}
+		if !bytes.Equal(root, smt.Root()) {
+			t.Fatalf("root of %d leaves does not match the tree root", len(leaves))
+		}
+	}
+
+	// Default values are treated as absent.
+	smt.Delete([]byte("testKey3"))
+	leaves[3].Value = defaultValue
+	root, _ = RootFromLeaves(leaves, sha256.New())
+	if !bytes.Equal(root, smt.Root()) {
+		t.Error("root with a default leaf does not match the tree root")
+	}
+
+	if _, err := RootFromLeaves(append(leaves, leaves[0]), sha256.New()); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("did not reject duplicate paths: %v", err)
+	}
+	if _, err := RootFromLeaves([]LeafKV{{Path: []byte("short"), Value: []byte("v")}}, sha256.New()); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("did not reject invalid path: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// ErrInvalidShardIndex is returned when a shard index is negative.
+var ErrInvalidShardIndex = errors.New("invalid shard index")
+
+// ShardKey returns the key under which the root of the shard with the given
+// index is stored in a top-level tree over shard roots, i.e. its index as a
+// big-endian uint64.
+func ShardKey(shardIndex int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(shardIndex))
+}
+
+// ShardedProof is a Merkle proof for a key in a sharded tree, where every
+// shard is a separate tree and a top-level tree maps ShardKey(i) to the root
+// of shard i.
+type ShardedProof struct {
+	// ShardIndex is the index of the shard holding the key.
+	ShardIndex int
+
+	// ShardProof is the Merkle proof of the key in the shard tree.
+	ShardProof SparseMerkleProof
+
+	// TopProof is the Merkle proof of the shard root in the top-level tree.
+	TopProof SparseMerkleProof
+}
+
+// ProveSharded generates a Merkle proof for a key in shardTree, at its current
+// root, composed with a Merkle proof of that root at ShardKey(shardIndex) in
+// topTree.
+func ProveSharded(key []byte, shardTree, topTree *SparseMerkleTree, shardIndex int) (*ShardedProof, error) {
+	if shardIndex < 0 {
+		return nil, ErrInvalidShardIndex
+	}
+	shardProof, err := shardTree.Prove(key)
+	if err != nil {
+		return nil, err
+	}
+	topProof, err := topTree.Prove(ShardKey(shardIndex))
+	if err != nil {
+		return nil, err
+	}
+	return &ShardedProof{
+		ShardIndex: shardIndex,
+		ShardProof: shardProof,
+		TopProof:   topProof,
+	}, nil
+}
+
+// VerifyShardedProof verifies a sharded Merkle proof against the root of the
+// top-level tree, by recomputing the shard root from the shard proof and then
+// the top-level root from it. The options must match the ones both trees were
+// built with.
+//
+// The proof only binds the key to the shard at proof.ShardIndex. Callers
+// assigning keys to shards by their paths should check that it is the shard
+// the key belongs to.
+func VerifyShardedProof(proof *ShardedProof, topRoot []byte, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	if proof.ShardIndex < 0 {
+		return false
+	}
+	th := treeHasherWithOptions(hasher, options)
+	if th.checkValueSize(value) != nil {
+		return false
+	}
+	shardRoot, _, ok := rootFromProof(proof.ShardProof, key, value, th)
+	if !ok || bytes.Equal(shardRoot, th.placeholder()) {
+		// Empty shards have no root in the top-level tree.
+		return false
+	}
+	result, _ := verifyProofWithUpdates(proof.TopProof, topRoot, ShardKey(proof.ShardIndex), shardRoot, th)
+	return result
+}
@@ -0,0 +1,62 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func TestShardedProof(t *testing.T) {
+	// Shard keys by the top 2 bits of their paths.
+	var shards [4]*SparseMerkleTree
+	for i := range shards {
+		shards[i] = NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	}
+	shardOf := func(key []byte) int {
+		return int(shards[0].th.path(key)[0] >> 6)
+	}
+	for i := 0; i < 40; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		shards[shardOf(key)].Update(key, []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	top := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i, shard := range shards {
+		top.Update(ShardKey(i), shard.Root())
+	}
+
+	key, value := []byte("testKey5"), []byte("testValue5")
+	shard := shardOf(key)
+	proof, err := ProveSharded(key, shards[shard], top, shard)
+	if err != nil {
+		t.Fatalf("returned error when proving key: %v", err)
+	}
+	if !VerifyShardedProof(proof, top.Root(), key, value, sha256.New()) {
+		t.Error("valid sharded proof failed to verify")
+	}
+	if VerifyShardedProof(proof, top.Root(), key, []byte("wrong"), sha256.New()) {
+		t.Error("sharded proof verified with wrong value")
+	}
+	if VerifyShardedProof(proof, shards[shard].Root(), key, value, sha256.New()) {
+		t.Error("sharded proof verified against the shard root")
+	}
+
+	// Non-membership within a shard.
+	absent := []byte("absent")
+	proof, err = ProveSharded(absent, shards[shardOf(absent)], top, shardOf(absent))
+	if err != nil {
+		t.Fatalf("returned error when proving key: %v", err)
+	}
+	if !VerifyShardedProof(proof, top.Root(), absent, defaultValue, sha256.New()) {
+		t.Error("valid sharded non-membership proof failed to verify")
+	}
+
+	// A proof for the wrong shard index does not verify.
+	proof, _ = ProveSharded(key, shards[shard], top, shard)
+	proof.ShardIndex = (shard + 1) % len(shards)
+	if VerifyShardedProof(proof, top.Root(), key, value, sha256.New()) {
+		t.Error("sharded proof verified with wrong shard index")
+	}
+	if _, err := ProveSharded(key, shards[shard], top, -1); err != ErrInvalidShardIndex {
+		t.Error("did not return ErrInvalidShardIndex for negative shard index")
+	}
+}
@@ -3,9 +3,12 @@ package smt
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"hash"
+	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -16,24 +19,76 @@ var defaultValue = []byte{}
 
 var errKeyAlreadyEmpty = errors.New("key already empty")
 
+// ErrInvalidPath is returned when a path does not have the size of the
+// digests of the tree hasher.
+var ErrInvalidPath = errors.New("invalid path length")
+
+// InvalidKeyLengthError is returned when a key does not have the length set
+// with WithFixedKeyLength.
+type InvalidKeyLengthError struct {
+	Key    []byte
+	Length int
+}
+
+func (e *InvalidKeyLengthError) Error() string {
+	return fmt.Sprintf("invalid key length: got %d, want %d", len(e.Key), e.Length)
+}
+
 // SparseMerkleTree is a Sparse Merkle tree.
+//
+// A tree is safe for concurrent use: reads, such as Get and Prove, run
+// concurrently with each other, while updates, such as Update and Delete, run
+// one at a time and exclusively of reads.
 type SparseMerkleTree struct {
+	mu            sync.RWMutex
 	th            treeHasher
 	nodes, values MapStore
-	root          []byte
+	root          atomic.Pointer[[]byte]
+	keyLength     int
+	reads         *readGuard
+	tracer        Tracer
+	tracedNodes   *countingStore
+	keyIndex      MapStore
+	observer      Observer
+	existence     *existenceCache
+	// inlineValueSize is the size up to which values are inlined in leaves.
+	inlineValueSize int
+	// detectCollisions makes the tree check keys against the key index.
+	detectCollisions bool
 }
 
 // NewSparseMerkleTree creates a new Sparse Merkle tree on an empty MapStore.
+//
+// The stores are not written to until the first update. Empty subtrees are
+// represented by a placeholder digest and are never materialized in the node
+// store, so stores only ever hold nodes that are reachable from a root.
+//
+// Digests are computed one at a time with hasher, which is shared by all
+// operations of the tree. Use NewSparseMerkleTreeWithHasherFunc for concurrent
+// operations to compute digests in parallel.
 func NewSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, options ...Option) *SparseMerkleTree {
+	return newSparseMerkleTree(nodes, values, newTreeHasher(hasher), options)
+}
+
+// NewSparseMerkleTreeWithHasherFunc is like NewSparseMerkleTree, but digests
+// with a fresh hasher from newHasher every time, e.g. sha256.New, instead of a
+// shared hasher.
+func NewSparseMerkleTreeWithHasherFunc(nodes, values MapStore, newHasher func() hash.Hash, options ...Option) *SparseMerkleTree {
+	return newSparseMerkleTree(nodes, values, newTreeHasherFunc(newHasher), options)
+}
+
+func newSparseMerkleTree(nodes, values MapStore, th *treeHasher, options []Option) *SparseMerkleTree {
 	smt := SparseMerkleTree{
-		th:     *newTreeHasher(hasher),
+		th:     *th,
 		nodes:  nodes,
 		values: values,
+		reads:  newReadGuard(),
 	}
 
 	for _, option := range options {
 		option(&smt)
 	}
+	smt.traceNodes()
 
 	smt.SetRoot(smt.th.placeholder())
 
@@ -41,105 +96,450 @@ func NewSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, options ...Op
 }
 
 // ImportSparseMerkleTree imports a Sparse Merkle tree from a non-empty MapStore.
-func ImportSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, root []byte) *SparseMerkleTree {
+func ImportSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) *SparseMerkleTree {
+	return importSparseMerkleTree(nodes, values, newTreeHasher(hasher), root, options)
+}
+
+// ImportSparseMerkleTreeWithHasherFunc is like ImportSparseMerkleTree, but
+// digests with a fresh hasher from newHasher every time, like
+// NewSparseMerkleTreeWithHasherFunc.
+func ImportSparseMerkleTreeWithHasherFunc(nodes, values MapStore, newHasher func() hash.Hash, root []byte, options ...Option) *SparseMerkleTree {
+	return importSparseMerkleTree(nodes, values, newTreeHasherFunc(newHasher), root, options)
+}
+
+func importSparseMerkleTree(nodes, values MapStore, th *treeHasher, root []byte, options []Option) *SparseMerkleTree {
 	smt := SparseMerkleTree{
-		th:     *newTreeHasher(hasher),
+		th:     *th,
 		nodes:  nodes,
 		values: values,
-		root:   root,
+		reads:  newReadGuard(),
+	}
+	smt.SetRoot(root)
+
+	for _, option := range options {
+		option(&smt)
 	}
+	smt.traceNodes()
+
 	return &smt
 }
 
-// Root gets the root of the tree.
+// ErrRootNotFound is returned by OpenSparseMerkleTree when the root is not in
+// the node store.
+var ErrRootNotFound = errors.New("root not found")
+
+// OpenSparseMerkleTree reopens a Sparse Merkle tree persisted in a MapStore at
+// a known root. Unlike ImportSparseMerkleTree, it checks that the root node is
+// in the node store, returning ErrRootNotFound otherwise. The empty root needs
+// no node.
+func OpenSparseMerkleTree(nodes, values MapStore, hasher hash.Hash, root []byte, options ...Option) (*SparseMerkleTree, error) {
+	smt := ImportSparseMerkleTree(nodes, values, hasher, root, options...)
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return smt, nil
+	}
+	has, err := smt.nodes.Has(root)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return nil, fmt.Errorf("%w: %x", ErrRootNotFound, root)
+	}
+	return smt, nil
+}
+
+// EmptyRoot returns the root of an empty tree using the given hasher. Deleting
+// every key from a tree always brings its root back to this value.
+func EmptyRoot(hasher hash.Hash) []byte {
+	return newTreeHasher(hasher).placeholder()
+}
+
+// Root gets the root of the tree. The returned slice is a copy, so it may be
+// modified by the caller.
 func (smt *SparseMerkleTree) Root() []byte {
-	return smt.root
+	return append([]byte(nil), *smt.root.Load()...)
 }
 
-// SetRoot sets the root of the tree.
+// SetRoot sets the root of the tree to a copy of root. Concurrent calls to
+// Root return either the old or the new root.
 func (smt *SparseMerkleTree) SetRoot(root []byte) {
-	smt.root = root
+	root = append([]byte(nil), root...)
+	smt.root.Store(&root)
 }
 
 func (smt *SparseMerkleTree) depth() int {
 	return smt.th.pathSize() * 8
 }
 
-// Get gets the value of a key from the tree.
+// Path returns the path of a key in the tree, the digest of the key by the
+// path hasher, as used by UpdateByPath, GetByPath and proofs.
+//
+// Paths are read bit by bit from the root down, starting with the most
+// significant bit of the first byte: bit i, (path[i/8] >> (7 - i%8)) & 1,
+// gives the child taken at depth i, 0 for the left one and 1 for the right
+// one. The side nodes of a proof are ordered from the leaf up, so side node j
+// of a proof with n side nodes is next to bit n-1-j.
+func (smt *SparseMerkleTree) Path(key []byte) []byte {
+	return smt.th.path(key)
+}
+
+// keyPath checks that a key is acceptable to the tree and returns its path.
+func (smt *SparseMerkleTree) keyPath(key []byte) ([]byte, error) {
+	if smt.keyLength > 0 && len(key) != smt.keyLength {
+		return nil, &InvalidKeyLengthError{Key: key, Length: smt.keyLength}
+	}
+	return smt.th.path(key), nil
+}
+
+// ErrKeyNotFound is returned when getting a key that has no value, unlike
+// errors of the stores, which are returned as they are.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Get gets the value of a key from the tree, returning ErrKeyNotFound if the
+// key has no value.
 func (smt *SparseMerkleTree) Get(key []byte) ([]byte, error) {
+	return smt.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get, but gives up with the error of ctx once it is done,
+// checking it before reading every level of the tree.
+func (smt *SparseMerkleTree) GetContext(ctx context.Context, key []byte) ([]byte, error) {
 	// Get tree's root
-	return smt.GetFromRoot(key, smt.root)
+	return smt.getFromRoot(ctx, key, smt.Root())
 }
 
-// Get gets the value of a key from the tree.
+// GetFromRoot gets the value of a key from the tree at a specific root. This
+// works for any root whose nodes are still in the node store; if a node on the
+// way to the key is missing, e.g. because the root was pruned, the error of
+// the store is returned, an InvalidKeyError for the stores of this package.
+//
+// Pruning of the root, with RemovePath, RemovePathForRoot or
+// RemovePathsForRoot, waits for reads in progress at the root to complete, so
+// reading historical roots is safe while they are pruned concurrently. See
+// WithReadGuard for pruning by other trees.
 func (smt *SparseMerkleTree) GetFromRoot(key, root []byte) ([]byte, error) {
+	return smt.getFromRoot(context.Background(), key, root)
+}
+
+func (smt *SparseMerkleTree) getFromRoot(ctx context.Context, key, root []byte) ([]byte, error) {
+	span := smt.startSpan("smt.Get", len(key))
+	defer span.end()
+	ctx = span.context(ctx)
+
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	smt.mu.RLock()
+	err = smt.checkCollision(path, key)
+	smt.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return smt.getForPath(ctx, path, root)
+}
+
+// GetByPath gets the value of a leaf from the tree by its path, skipping the
+// hashing of the key. The caller is responsible for the path being the digest
+// of the key, as returned by the path hasher.
+func (smt *SparseMerkleTree) GetByPath(path []byte) ([]byte, error) {
+	span := smt.startSpan("smt.GetByPath", -1)
+	defer span.end()
+
+	if len(path) != smt.th.pathSize() {
+		return nil, ErrInvalidPath
+	}
+	return smt.getForPath(span.context(context.Background()), path, smt.Root())
+}
+
+func (smt *SparseMerkleTree) getForPath(ctx context.Context, path, root []byte) ([]byte, error) {
 	if bytes.Equal(root, smt.th.placeholder()) {
-		// The tree is empty, return the default value.
-		return defaultValue, nil
+		// The tree is empty.
+		return nil, ErrKeyNotFound
 	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
 
-	path := smt.th.path(key)
-	_, _, leafData, _, err := smt.sideNodesForRoot(path, root, false)
+	_, _, leafData, inlineValue, _, err := smt.sideNodesAndValueForRoot(ctx, path, root, false)
 	if err != nil {
-		var invalidKeyError *InvalidKeyError
-
-		if errors.As(err, &invalidKeyError) {
-			// If key isn't found, return default value
-			return defaultValue, nil
-		} else {
-			// Otherwise percolate up any other error
-			return nil, err
-		}
+		return nil, err
 	}
 	if leafData == nil {
-		return defaultValue, nil
+		return nil, ErrKeyNotFound
 	}
 
-	keyHash, _, kvHash := smt.th.parseLeaf(leafData)
+	keyHash, _, _ := smt.th.parseLeaf(leafData)
 	if !bytes.Equal(keyHash, path) {
-		return defaultValue, nil
+		return nil, ErrKeyNotFound
 	}
 
-	kv := smt.th.digest(kvHash)
-	value, err := smt.values.Get(kv)
+	return smt.leafValue(leafData, inlineValue)
+}
+
+// Has returns true if the value at the given key is non-default, false
+// otherwise. Unlike Get, it only walks the node store to the leaf of the key,
+// and never reads the value.
+func (smt *SparseMerkleTree) Has(key []byte) (bool, error) {
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return false, err
+	}
+	root := smt.Root()
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return false, nil
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	if err := smt.checkCollision(path, key); err != nil {
+		return false, err
+	}
+	if present, ok := smt.existence.lookup(root, path); ok {
+		return present, nil
+	}
+	_, _, leafData, _, err := smt.sideNodesForRoot(context.Background(), path, root, false)
+	if err != nil {
+		return false, err
+	}
+	present := false
+	if leafData != nil {
+		leafPath, _, _ := smt.th.parseLeaf(leafData)
+		present = bytes.Equal(leafPath, path)
+	}
+	smt.existence.add(root, path, present)
+	return present, nil
+}
+
+// GetValueHash returns the digest of the value of a key, as kept in its leaf,
+// returning ErrKeyNotFound if the key has no value. Like Has, it only walks the
+// node store to the leaf of the key, and never reads the value.
+func (smt *SparseMerkleTree) GetValueHash(key []byte) ([]byte, error) {
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	root := smt.Root()
+	if bytes.Equal(root, smt.th.placeholder()) {
+		return nil, ErrKeyNotFound
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	if err := smt.checkCollision(path, key); err != nil {
+		return nil, err
+	}
+	_, _, leafData, _, err := smt.sideNodesForRoot(context.Background(), path, root, false)
 	if err != nil {
 		return nil, err
 	}
-	return value, nil
+	if leafData == nil {
+		return nil, ErrKeyNotFound
+	}
+	leafPath, valueHash, _ := smt.th.parseLeaf(leafData)
+	if !bytes.Equal(leafPath, path) {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte(nil), valueHash...), nil
 }
 
-// Has returns true if the value at the given key is non-default, false
-// otherwise.
-func (smt *SparseMerkleTree) Has(key []byte) (bool, error) {
-	val, err := smt.Get(key)
-	return !bytes.Equal(defaultValue, val), err
+// WalkPath returns the hashes of the nodes on the path of a key, from the
+// current root down to the leaf of the key. For keys without a value, it ends
+// with the unrelated leaf or the placeholder in the way of the key. Prove
+// returns the side nodes of the same path.
+func (smt *SparseMerkleTree) WalkPath(key []byte) ([][]byte, error) {
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	root := smt.Root()
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	_, pathNodes, _, _, err := smt.sideNodesForRoot(context.Background(), path, root, false)
+	if err != nil {
+		return nil, err
+	}
+	return reverseByteSlices(pathNodes), nil
+}
+
+// ShareSubtree returns true if the paths of two keys have the same first
+// atDepth bits, that is if both keys are in the same subtree at that depth.
+// Such keys share the nodes above that subtree, and the side nodes of their
+// proofs above it.
+func (smt *SparseMerkleTree) ShareSubtree(keyA, keyB []byte, atDepth int) bool {
+	return smt.ShareSubtreeByPath(smt.th.path(keyA), smt.th.path(keyB), atDepth)
+}
+
+// ShareSubtreeByPath is like ShareSubtree, but takes the paths of the keys
+// instead of the keys. It returns false if a path does not have the size of
+// the digests of the tree hasher.
+func (smt *SparseMerkleTree) ShareSubtreeByPath(pathA, pathB []byte, atDepth int) bool {
+	if len(pathA) != smt.th.pathSize() || len(pathB) != smt.th.pathSize() {
+		return false
+	}
+	return countCommonPrefix(pathA, pathB) >= atDepth
 }
 
 // Update sets a new value for a key in the tree, and sets and returns the new root of the tree.
+//
+// A nil value deletes the key, like Delete, whatever the default value: nil
+// means no value. Any other value is stored, including the empty value, unless
+// it is the default value, which the empty value is unless set otherwise with
+// WithDefaultValue.
 func (smt *SparseMerkleTree) Update(key []byte, value []byte) ([]byte, error) {
-	newRoot, err := smt.UpdateForRoot(key, value, smt.Root())
+	return smt.UpdateContext(context.Background(), key, value)
+}
+
+// UpdateContext is like Update, but gives up with the error of ctx once it is
+// done, checking it before reading every level of the tree. The tree is left
+// unchanged if it gives up.
+func (smt *SparseMerkleTree) UpdateContext(ctx context.Context, key []byte, value []byte) ([]byte, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	return smt.update(ctx, key, value)
+}
+
+// ErrRootMismatch is returned by CompareAndUpdate when the tree is not at the
+// expected root.
+var ErrRootMismatch = errors.New("root mismatch")
+
+// CompareAndUpdate sets a new value for a key in the tree like Update, but
+// only if the root of the tree is expectedRoot, returning ErrRootMismatch
+// otherwise. The check and the update are atomic, so writers that computed
+// their updates against a root can detect that another writer got in first.
+func (smt *SparseMerkleTree) CompareAndUpdate(expectedRoot, key, value []byte) ([]byte, error) {
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	if root := smt.Root(); !bytes.Equal(root, expectedRoot) {
+		return nil, fmt.Errorf("%w: expected %x, got %x", ErrRootMismatch, expectedRoot, root)
+	}
+	return smt.update(context.Background(), key, value)
+}
+
+// SimulateUpdate returns the root that the tree would have after setting a new
+// value for a key with Update, without changing the tree or writing to its
+// stores. The nodes the update would write are only kept in memory.
+func (smt *SparseMerkleTree) SimulateUpdate(key, value []byte) ([]byte, error) {
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	root := smt.Root()
+	defer smt.reads.read(root)()
+
+	simulated := &SparseMerkleTree{
+		th:     smt.th,
+		nodes:  NewBatchStore(smt.nodes),
+		values: NewBatchStore(smt.values),
+		reads:  smt.reads,
+
+		inlineValueSize: smt.inlineValueSize,
+	}
+	return simulated.updateForPath(context.Background(), path, value, root)
+}
+
+// update sets a new value for a key at the current root, and sets and returns
+// the new root. The tree must be write-locked.
+func (smt *SparseMerkleTree) update(ctx context.Context, key []byte, value []byte) ([]byte, error) {
+	defer smt.observeUpdate()()
+	span := smt.startSpan("smt.Update", len(key))
+	defer span.end()
+	defer smt.countUpdate(span)()
+
+	path, err := smt.keyPath(key)
 	if err != nil {
 		return nil, err
 	}
+	return smt.updatePath(ctx, path, key, value)
+}
+
+// updatePath sets a new value for the key at path at the current root, keeping
+// the key index and the existence cache in step, and sets and returns the new
+// root. key is nil for updates by path, whose key is not known and thus not
+// checked for collisions. The tree must be write-locked.
+func (smt *SparseMerkleTree) updatePath(ctx context.Context, path, key, value []byte) ([]byte, error) {
+	if key != nil {
+		if err := smt.checkCollision(path, key); err != nil {
+			return nil, err
+		}
+	}
+	newRoot, err := smt.updateForPath(ctx, path, value, smt.Root())
+	if err != nil {
+		return nil, err
+	}
+	if err := smt.indexKey(path, key, value); err != nil {
+		return nil, err
+	}
+	smt.existence.moved(smt.Root(), newRoot, path)
 	smt.SetRoot(newRoot)
 	return newRoot, nil
 }
 
 // Delete deletes a value from tree. It returns the new root of the tree.
 func (smt *SparseMerkleTree) Delete(key []byte) ([]byte, error) {
-	return smt.Update(key, defaultValue)
+	return smt.Update(key, smt.th.defaultValue)
+}
+
+// UpdateByPath sets a new value for a leaf in the tree by its path, skipping
+// the hashing of the key, and sets and returns the new root of the tree. The
+// caller is responsible for the path being the digest of the key, as returned
+// by the path hasher.
+//
+// As the key is not known, it is not checked for collisions, nor added to the
+// key index when set; it is removed from the index when deleted.
+func (smt *SparseMerkleTree) UpdateByPath(path []byte, value []byte) ([]byte, error) {
+	defer smt.observeUpdate()()
+	span := smt.startSpan("smt.UpdateByPath", -1)
+	defer span.end()
+
+	if len(path) != smt.th.pathSize() {
+		return nil, ErrInvalidPath
+	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	defer smt.countUpdate(span)()
+
+	return smt.updatePath(context.Background(), path, nil, value)
 }
 
 // UpdateForRoot sets a new value for a key in the tree at a specific root, and returns the new root.
 func (smt *SparseMerkleTree) UpdateForRoot(key []byte, value []byte, root []byte) ([]byte, error) {
-	path := smt.th.path(key)
-	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(path, root, false)
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	return smt.updateForRoot(context.Background(), key, value, root)
+}
+
+func (smt *SparseMerkleTree) updateForRoot(ctx context.Context, key []byte, value []byte, root []byte) ([]byte, error) {
+	span := smt.startSpan("smt.Update", len(key))
+	defer span.end()
+	defer smt.countUpdate(span)()
+
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return smt.updateForPath(ctx, path, value, root)
+}
+
+func (smt *SparseMerkleTree) updateForPath(ctx context.Context, path []byte, value []byte, root []byte) ([]byte, error) {
+	if err := smt.th.checkValueSize(value); err != nil {
+		return nil, err
+	}
+	sideNodes, pathNodes, oldLeafData, _, err := smt.sideNodesForRoot(ctx, path, root, false)
 	if err != nil {
 		return nil, fmt.Errorf("trail sidenodes fail: %w", err)
 	}
 
 	var newRoot []byte
-	if bytes.Equal(value, defaultValue) {
+	if smt.th.isDefault(value) {
 		// Delete operation.
 		newRoot, err = smt.deleteWithSideNodes(path, sideNodes, pathNodes, oldLeafData)
 		if errors.Is(err, errKeyAlreadyEmpty) {
@@ -147,32 +547,45 @@ func (smt *SparseMerkleTree) UpdateForRoot(key []byte, value []byte, root []byte
 			return root, nil
 		}
 	} else {
+		if oldLeafData != nil {
+			// Nothing is written if the key already has the value.
+			oldPath, oldValueHash, _ := smt.th.parseLeaf(oldLeafData)
+			if bytes.Equal(oldPath, path) && bytes.Equal(oldValueHash, smt.th.digestValue(value)) {
+				return root, nil
+			}
+		}
 		// Insert or update operation.
-		newRoot, err = smt.updateWithSideNodes(path, key, value, sideNodes, pathNodes, oldLeafData)
+		newRoot, err = smt.updateWithSideNodes(path, value, sideNodes, pathNodes, oldLeafData)
 	}
 	return newRoot, err
 }
 
 // DeleteForRoot deletes a value from tree at a specific root. It returns the new root of the tree.
 func (smt *SparseMerkleTree) DeleteForRoot(key, root []byte) ([]byte, error) {
-	return smt.UpdateForRoot(key, defaultValue, root)
+	return smt.UpdateForRoot(key, smt.th.defaultValue, root)
 }
 
 func (smt *SparseMerkleTree) RemovePathForRoot(key, root []byte) error {
-	path := smt.th.path(key)
-	_, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, root, false)
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return err
+	}
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	defer smt.reads.prune(root)()
+
+	_, pathNodes, leafData, _, err := smt.sideNodesForRoot(context.Background(), path, root, false)
 	if err != nil {
 		return err
 	}
 
 	for i, node := range pathNodes {
 		if i == 0 && leafData != nil {
-			actualPath, _, actualKV := smt.th.parseLeaf(leafData)
+			actualPath, _, _ := smt.th.parseLeaf(leafData)
 			if !bytes.Equal(actualPath, path) {
 				continue
 			}
-			kv := smt.th.digest(actualKV)
-			if err := smt.values.Delete(kv); err != nil {
+			if err := smt.deleteLeafValue(node, leafData); err != nil {
 				return err
 			}
 		}
@@ -187,12 +600,24 @@ func (smt *SparseMerkleTree) RemovePathForRoot(key, root []byte) error {
 }
 
 func (smt *SparseMerkleTree) RemovePath(key, removeRoot, keepRoot []byte) error {
-	path := smt.th.path(key)
-	_, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, removeRoot, false)
+	path, err := smt.keyPath(key)
 	if err != nil {
 		return err
 	}
-	_, kpathNodes, _, _, err := smt.sideNodesForRoot(path, keepRoot, false)
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+
+	// Read the kept path before holding the removed root for pruning, so that
+	// pruning never waits on other pruning while holding a root.
+	release := smt.reads.read(keepRoot)
+	_, kpathNodes, _, _, err := smt.sideNodesForRoot(context.Background(), path, keepRoot, false)
+	release()
+	if err != nil {
+		return err
+	}
+	defer smt.reads.prune(removeRoot)()
+
+	_, pathNodes, leafData, _, err := smt.sideNodesForRoot(context.Background(), path, removeRoot, false)
 	if err != nil {
 		return err
 	}
@@ -204,12 +629,11 @@ func (smt *SparseMerkleTree) RemovePath(key, removeRoot, keepRoot []byte) error
 
 	for i, node := range pathNodes {
 		if i == 0 && leafData != nil {
-			actualPath, _, actualKV := smt.th.parseLeaf(leafData)
+			actualPath, _, _ := smt.th.parseLeaf(leafData)
 			if _, ok := smap[string(pathNodes[0])]; !bytes.Equal(actualPath, path) || ok {
 				continue
 			}
-			kv := smt.th.digest(actualKV)
-			if err := smt.values.Delete(kv); err != nil {
+			if err := smt.deleteLeafValue(node, leafData); err != nil {
 				return err
 			}
 		}
@@ -226,21 +650,31 @@ func (smt *SparseMerkleTree) RemovePath(key, removeRoot, keepRoot []byte) error
 }
 
 func (smt *SparseMerkleTree) RemovePathsForRoot(keys [][]byte, root []byte) error {
+	span := smt.startSpan("smt.RemovePaths", -1)
+	span.setAttribute(SpanAttrKeys, len(keys))
+	defer span.end()
+	smt.mu.Lock()
+	defer smt.mu.Unlock()
+	defer smt.countUpdate(span)()
+	defer smt.reads.prune(root)()
+
 	var res [][]byte
 	tmpMap := map[string]struct{}{}
 	for _, key := range keys {
-		path := smt.th.path(key)
-		_, pathNodes, leafData, _, err := smt.sideNodesForRoot(path, root, false)
+		path, err := smt.keyPath(key)
+		if err != nil {
+			return err
+		}
+		_, pathNodes, leafData, _, err := smt.sideNodesForRoot(context.Background(), path, root, false)
 		if err != nil {
 			return err
 		}
 
 		if leafData != nil {
-			actualPath, _, actualKV := smt.th.parseLeaf(leafData)
+			actualPath, _, _ := smt.th.parseLeaf(leafData)
 			if bytes.Equal(actualPath, path) {
 				// remove leaf
-				kv := smt.th.digest(actualKV)
-				if err := smt.values.Delete(kv); err != nil {
+				if err := smt.deleteLeafValue(pathNodes[0], leafData); err != nil {
 					return err
 				}
 				if err := smt.nodes.Delete(pathNodes[0]); err != nil {
@@ -286,7 +720,7 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 	nonPlaceholderReached := false
 	for i, sideNode := range sideNodes {
 		if currentData == nil {
-			sideNodeValue, err := smt.nodes.Get(sideNode)
+			sideNodeValue, err := smt.getNode(sideNode)
 			if err != nil {
 				return nil, err
 			}
@@ -331,18 +765,10 @@ func (smt *SparseMerkleTree) deleteWithSideNodes(path []byte, sideNodes [][]byte
 	return currentHash, nil
 }
 
-func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, key []byte, value []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
-	valueHash := smt.th.digest(value)
+func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, value []byte, sideNodes [][]byte, pathNodes [][]byte, oldLeafData []byte) ([]byte, error) {
+	valueHash := smt.th.digestValue(value)
 	currentHash, currentData := smt.th.digestLeaf(path, valueHash)
-	if err := smt.nodes.Put(currentHash, currentData); err != nil {
-		return nil, err
-	}
-
-	kvHash := make([]byte, 0, len(path)+len(valueHash))
-	kvHash = append(kvHash, path...)
-	kvHash = append(kvHash, valueHash...)
-	kv := smt.th.digest(kvHash)
-	if err := smt.values.Put(kv, value); err != nil {
+	if err := smt.putLeaf(currentHash, currentData, value); err != nil {
 		return nil, err
 	}
 
@@ -355,12 +781,10 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, key []byte, value
 	// First, get the number of bits that the paths of the two leaf nodes share
 	// in common as a prefix.
 	var commonPrefixCount int
-	var oldValue []byte
 	if bytes.Equal(pathNodes[0], smt.th.placeholder()) {
 		commonPrefixCount = smt.depth()
 	} else {
-		var actualPath []byte
-		actualPath, oldValue, _ = smt.th.parseLeaf(oldLeafData)
+		actualPath, _, _ := smt.th.parseLeaf(oldLeafData)
 		commonPrefixCount = countCommonPrefix(path, actualPath)
 	}
 	if commonPrefixCount != smt.depth() {
@@ -376,11 +800,6 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, key []byte, value
 		}
 
 		currentData = currentHash
-	} else if oldValue != nil {
-		// Short-circuit if the same value is being set
-		if bytes.Equal(oldValue, valueHash) {
-			return smt.root, nil
-		}
 	}
 
 	// The offset from the bottom of the tree to the start of the side nodes.
@@ -419,12 +838,43 @@ func (smt *SparseMerkleTree) updateWithSideNodes(path []byte, key []byte, value
 	return currentHash, nil
 }
 
+// getNode gets the data of a node from the node store, checking that it can be
+// parsed as a leaf or inner node.
+func (smt *SparseMerkleTree) getNode(hash []byte) ([]byte, error) {
+	data, _, err := smt.getNodeWithValue(hash)
+	return data, err
+}
+
+// getNodeWithValue is like getNode, also returning the value inlined in the
+// node if it is a leaf with an inlined value, or nil.
+func (smt *SparseMerkleTree) getNodeWithValue(hash []byte) ([]byte, []byte, error) {
+	record, err := smt.nodes.Get(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, value := smt.splitNode(record)
+	if err := smt.th.checkNode(hash, data); err != nil {
+		return nil, nil, err
+	}
+	return data, value, nil
+}
+
 // Get all the sibling nodes (sidenodes) for a given path from a given root.
 // Returns an array of sibling nodes, the leaf hash found at that path, the
 // leaf data, and the sibling data.
 //
 // If the leaf is a placeholder, the leaf data is nil.
-func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSiblingData bool) ([][]byte, [][]byte, []byte, []byte, error) {
+//
+// It gives up with the error of ctx once it is done, checking it before
+// reading every level of the tree.
+func (smt *SparseMerkleTree) sideNodesForRoot(ctx context.Context, path []byte, root []byte, getSiblingData bool) ([][]byte, [][]byte, []byte, []byte, error) {
+	sideNodes, pathNodes, leafData, _, siblingData, err := smt.sideNodesAndValueForRoot(ctx, path, root, getSiblingData)
+	return sideNodes, pathNodes, leafData, siblingData, err
+}
+
+// sideNodesAndValueForRoot is like sideNodesForRoot, also returning the value
+// inlined in the leaf, if any, after the leaf data.
+func (smt *SparseMerkleTree) sideNodesAndValueForRoot(ctx context.Context, path []byte, root []byte, getSiblingData bool) ([][]byte, [][]byte, []byte, []byte, []byte, error) {
 	// Side nodes for the path. Nodes are inserted in reverse order, then the
 	// slice is reversed at the end.
 	sideNodes := make([][]byte, 0, smt.depth())
@@ -434,15 +884,19 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 	if bytes.Equal(root, smt.th.placeholder()) {
 		// If the root is a placeholder, there are no sidenodes to return.
 		// Let the "actual path" be the input path.
-		return sideNodes, pathNodes, nil, nil, nil
+		return sideNodes, pathNodes, nil, nil, nil, nil
 	}
 
-	currentData, err := smt.nodes.Get(root)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	countNodeAccess(ctx)
+	currentData, inlineValue, err := smt.getNodeWithValue(root)
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, err
 	} else if smt.th.isLeaf(currentData) {
 		// If the root is a leaf, there are also no sidenodes to return.
-		return sideNodes, pathNodes, currentData, nil, nil
+		return sideNodes, pathNodes, currentData, inlineValue, nil, nil
 	}
 
 	var nodeHash []byte
@@ -468,9 +922,13 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 			break
 		}
 
-		currentData, err = smt.nodes.Get(nodeHash)
+		if err := ctx.Err(); err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+		countNodeAccess(ctx)
+		currentData, inlineValue, err = smt.getNodeWithValue(nodeHash)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return nil, nil, nil, nil, nil, err
 		} else if smt.th.isLeaf(currentData) {
 			// If the node is a leaf, we've reached the end.
 			break
@@ -478,19 +936,21 @@ func (smt *SparseMerkleTree) sideNodesForRoot(path []byte, root []byte, getSibli
 	}
 
 	if getSiblingData {
+		countNodeAccess(ctx)
 		siblingData, err = smt.nodes.Get(sideNode)
 		if err != nil {
-			return nil, nil, nil, nil, err
+			return nil, nil, nil, nil, nil, err
 		}
+		siblingData, _ = smt.splitNode(siblingData)
 	}
-	return reverseByteSlices(sideNodes), reverseByteSlices(pathNodes), currentData, siblingData, nil
+	return reverseByteSlices(sideNodes), reverseByteSlices(pathNodes), currentData, inlineValue, siblingData, nil
 }
 
 func (smt *SparseMerkleTree) PrintSMT(root []byte) (uint64, error) {
 	fmt.Println("############################################")
 	fmt.Printf("begin at root[%x]\n", root)
 	var current, next [][]byte
-	currentData, err := smt.nodes.Get(root)
+	currentData, currentValue, err := smt.getNodeWithValue(root)
 	if err != nil {
 		return 0, err
 	}
@@ -498,8 +958,7 @@ func (smt *SparseMerkleTree) PrintSMT(root []byte) (uint64, error) {
 	var level = 1
 	fmt.Printf("--level-%d  ", level)
 	if smt.th.isLeaf(currentData) {
-		kv := smt.th.digest(currentData[1:])
-		value, _ := smt.values.Get(kv)
+		value, _ := smt.leafValue(currentData, currentValue)
 		fmt.Printf("(%s(leaf))\n", string(value))
 	} else {
 		fmt.Println("")
@@ -512,14 +971,12 @@ func (smt *SparseMerkleTree) PrintSMT(root []byte) (uint64, error) {
 		for _, data := range current {
 			left, right := smt.th.parseNode(data)
 			if !bytes.Equal(left, smt.th.placeholder()) {
-				leftData, err := smt.nodes.Get(left)
+				leftData, leftValue, err := smt.getNodeWithValue(left)
 				if err != nil {
 					continue
 				}
 				if smt.th.isLeaf(leftData) {
-					_, _, kvHash := smt.th.parseLeaf(leftData)
-					kv := smt.th.digest(kvHash)
-					value, _ := smt.values.Get(kv)
+					value, _ := smt.leafValue(leftData, leftValue)
 					fmt.Printf("(%s(leaf), ", string(value))
 				} else {
 					next = append(next, leftData)
@@ -529,14 +986,12 @@ func (smt *SparseMerkleTree) PrintSMT(root []byte) (uint64, error) {
 				fmt.Printf("(nil(left), ")
 			}
 			if !bytes.Equal(right, smt.th.placeholder()) {
-				rightData, err := smt.nodes.Get(right)
+				rightData, rightValue, err := smt.getNodeWithValue(right)
 				if err != nil {
 					continue
 				}
 				if smt.th.isLeaf(rightData) {
-					_, _, kvHash := smt.th.parseLeaf(rightData)
-					kv := smt.th.digest(kvHash)
-					value, _ := smt.values.Get(kv)
+					value, _ := smt.leafValue(rightData, rightValue)
 					fmt.Printf("%s(leaf))  ", string(value))
 				} else {
 					next = append(next, rightData)
@@ -586,12 +1041,56 @@ func (smt *SparseMerkleTree) ProveUpdatableForRoot(key []byte, root []byte) (Spa
 	return smt.doProveForRoot(key, root, true)
 }
 
-func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable bool) (SparseMerkleProof, error) {
-	path := smt.th.path(key)
-	sideNodes, pathNodes, leafData, siblingData, err := smt.sideNodesForRoot(path, root, isUpdatable)
+// ErrKeyPresent is returned by ProveNonMembership when the key has a value.
+var ErrKeyPresent = errors.New("key present")
+
+// ProveNonMembership generates a Merkle proof that a key has no value against
+// the current root, returning ErrKeyPresent if it has one. The proof is a
+// proof that the key has the default value, and can be verified with
+// VerifyNonMembershipProof.
+func (smt *SparseMerkleTree) ProveNonMembership(key []byte) (SparseMerkleProof, error) {
+	root := smt.Root()
+	proof, err := smt.ProveForRoot(key, root)
 	if err != nil {
 		return SparseMerkleProof{}, err
 	}
+	if result, _ := verifyProofWithUpdates(proof, root, key, smt.th.defaultValue, &smt.th); !result {
+		return SparseMerkleProof{}, ErrKeyPresent
+	}
+	return proof, nil
+}
+
+// ProveUpdatableWithValue generates an updatable Merkle proof for a key against
+// the current root, like ProveUpdatable, together with the value of the key,
+// or the default value if it has none. Both are read in the same traversal of
+// the tree, so they always agree, even with concurrent updates.
+func (smt *SparseMerkleTree) ProveUpdatableWithValue(key []byte) (SparseMerkleProof, []byte, error) {
+	return smt.proveForRoot(key, smt.Root(), true, true)
+}
+
+func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable bool) (SparseMerkleProof, error) {
+	proof, _, err := smt.proveForRoot(key, root, isUpdatable, false)
+	return proof, err
+}
+
+// proveForRoot generates a Merkle proof for a key against root, and reads the
+// value of the key if withValue is set.
+func (smt *SparseMerkleTree) proveForRoot(key []byte, root []byte, isUpdatable bool, withValue bool) (SparseMerkleProof, []byte, error) {
+	span := smt.startSpan("smt.Prove", len(key))
+	defer span.end()
+
+	path, err := smt.keyPath(key)
+	if err != nil {
+		return SparseMerkleProof{}, nil, err
+	}
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	sideNodes, pathNodes, leafData, inlineValue, siblingData, err := smt.sideNodesAndValueForRoot(span.context(context.Background()), path, root, isUpdatable)
+	if err != nil {
+		return SparseMerkleProof{}, nil, err
+	}
 
 	var nonEmptySideNodes [][]byte
 	for _, v := range sideNodes {
@@ -603,12 +1102,17 @@ func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable
 	// Deal with non-membership proofs. If the leaf hash is the placeholder
 	// value, we do not need to add anything else to the proof.
 	var nonMembershipLeafData []byte
+	value := smt.th.defaultValue
 	if !bytes.Equal(pathNodes[0], smt.th.placeholder()) {
 		actualPath, _, _ := smt.th.parseLeaf(leafData)
 		if !bytes.Equal(actualPath, path) {
 			// This is a non-membership proof that involves showing a different leaf.
 			// Add the leaf data to the proof.
 			nonMembershipLeafData = leafData
+		} else if withValue {
+			if value, err = smt.leafValue(leafData, inlineValue); err != nil {
+				return SparseMerkleProof{}, nil, err
+			}
 		}
 	}
 
@@ -618,7 +1122,7 @@ func (smt *SparseMerkleTree) doProveForRoot(key []byte, root []byte, isUpdatable
 		SiblingData:           siblingData,
 	}
 
-	return proof, err
+	return proof, value, nil
 }
 
 // ProveCompact generates a compacted Merkle proof for a key against the current root.
@@ -633,6 +1137,6 @@ func (smt *SparseMerkleTree) ProveCompactForRoot(key []byte, root []byte) (Spars
 	if err != nil {
 		return SparseCompactMerkleProof{}, err
 	}
-	compactedProof, err := CompactProof(proof, smt.th.hasher)
+	compactedProof, err := compactProof(proof, &smt.th)
 	return compactedProof, err
 }
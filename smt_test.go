@@ -3,11 +3,72 @@ package smt
 import (
 	"bytes"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash"
 	"math/rand"
+	"strconv"
+	"sync"
 	"testing"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
+func TestSparseMerkleTreeRoot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	if !bytes.Equal(smt.Root(), EmptyRoot(sha256.New())) {
+		t.Error("empty tree does not have the empty root")
+	}
+
+	root, _ := smt.Update([]byte("testKey"), []byte("testValue"))
+	if bytes.Equal(smt.Root(), EmptyRoot(sha256.New())) || !bytes.Equal(smt.Root(), root) {
+		t.Error("root did not change to the updated root")
+	}
+
+	// Modifying the returned roots does not affect the tree.
+	smt.Root()[0] ^= 0xff
+	expected := append([]byte(nil), root...)
+	root[0] ^= 0xff
+	if !bytes.Equal(smt.Root(), expected) {
+		t.Error("modifying the returned root changed the tree")
+	}
+}
+
+// Test that EmptyRoot is the root of a new tree for every hasher, without
+// touching a store.
+func TestEmptyRoot(t *testing.T) {
+	for name, newHasher := range map[string]func() hash.Hash{
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+		"sha3":   sha3.New256,
+	} {
+		smt := NewSparseMerkleTree(NullStore{}, NullStore{}, newHasher())
+		if !bytes.Equal(EmptyRoot(newHasher()), smt.Root()) {
+			t.Errorf("%s: empty root differs from the root of a new tree", name)
+		}
+		if len(EmptyRoot(newHasher())) != newHasher().Size() {
+			t.Errorf("%s: empty root is not the size of a digest", name)
+		}
+	}
+}
+
+// Test that making a tree writes nothing: empty subtrees are placeholders, not
+// default nodes in the store.
+func TestSparseMerkleTreeNewNoWrites(t *testing.T) {
+	nodes := &countingStore{MapStore: NewSimpleMap()}
+	values := &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(nodes, values, sha256.New())
+	if nodes.accesses.Load() != 0 || values.accesses.Load() != 0 {
+		t.Errorf("making a tree accessed the stores %d times", nodes.accesses.Load()+values.accesses.Load())
+	}
+	if _, err := smt.Prove([]byte("testKey")); err != nil || nodes.accesses.Load() != 0 {
+		t.Error("proving in an empty tree read from the node store")
+	}
+}
+
 // Test base case tree update operations with a few keys.
 func TestSparseMerkleTreeUpdateBasic(t *testing.T) {
 	smn, smv := NewSimpleMap(), NewSimpleMap()
@@ -17,12 +78,9 @@ func TestSparseMerkleTreeUpdateBasic(t *testing.T) {
 	var err error
 
 	// Test getting an empty key.
-	value, err = smt.Get([]byte("testKey"))
-	if err != nil {
-		t.Errorf("returned error when getting empty key: %v", err)
-	}
-	if !bytes.Equal(defaultValue, value) {
-		t.Error("did not get default value when getting empty key")
+	_, err = smt.Get([]byte("testKey"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting empty key: %v", err)
 	}
 	has, err = smt.Has([]byte("testKey"))
 	if err != nil {
@@ -290,12 +348,9 @@ func TestSparseMerkleTreeDeleteBasic(t *testing.T) {
 	if err != nil {
 		t.Errorf("returned error when deleting key: %v", err)
 	}
-	value, err := smt.Get([]byte("testKey"))
-	if err != nil {
-		t.Errorf("returned error when getting deleted key: %v", err)
-	}
-	if !bytes.Equal(defaultValue, value) {
-		t.Error("did not get default value when getting deleted key")
+	_, err = smt.Get([]byte("testKey"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting deleted key: %v", err)
 	}
 	has, err := smt.Has([]byte("testKey"))
 	if err != nil {
@@ -308,7 +363,7 @@ func TestSparseMerkleTreeDeleteBasic(t *testing.T) {
 	if err != nil {
 		t.Errorf("returned error when updating empty key: %v", err)
 	}
-	value, err = smt.Get([]byte("testKey"))
+	value, err := smt.Get([]byte("testKey"))
 	if err != nil {
 		t.Errorf("returned error when getting non-empty key: %v", err)
 	}
@@ -328,12 +383,9 @@ func TestSparseMerkleTreeDeleteBasic(t *testing.T) {
 	if err != nil {
 		t.Errorf("returned error when deleting key: %v", err)
 	}
-	value, err = smt.Get([]byte("testKey2"))
-	if err != nil {
-		t.Errorf("returned error when getting deleted key: %v", err)
-	}
-	if !bytes.Equal(defaultValue, value) {
-		t.Error("did not get default value when getting deleted key")
+	_, err = smt.Get([]byte("testKey2"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting deleted key: %v", err)
 	}
 	value, err = smt.Get([]byte("testKey"))
 	if err != nil {
@@ -361,12 +413,9 @@ func TestSparseMerkleTreeDeleteBasic(t *testing.T) {
 	if err != nil {
 		t.Errorf("returned error when deleting key: %v", err)
 	}
-	value, err = smt.Get([]byte("foo"))
-	if err != nil {
-		t.Errorf("returned error when getting deleted key: %v", err)
-	}
-	if !bytes.Equal(defaultValue, value) {
-		t.Error("did not get default value when getting deleted key")
+	_, err = smt.Get([]byte("foo"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting deleted key: %v", err)
 	}
 	value, err = smt.Get([]byte("testKey"))
 	if err != nil {
@@ -389,12 +438,9 @@ func TestSparseMerkleTreeDeleteBasic(t *testing.T) {
 	if err != nil {
 		t.Errorf("returned error when deleting key: %v", err)
 	}
-	value, err = smt.Get([]byte("testKey"))
-	if err != nil {
-		t.Errorf("returned error when getting deleted key: %v", err)
-	}
-	if !bytes.Equal(defaultValue, value) {
-		t.Error("did not get default value when getting deleted key")
+	_, err = smt.Get([]byte("testKey"))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting deleted key: %v", err)
 	}
 	has, err = smt.Has([]byte("testKey"))
 	if err != nil {
@@ -420,6 +466,984 @@ func TestSparseMerkleTreeDeleteBasic(t *testing.T) {
 
 }
 
+// Test updating and getting leaves by their precomputed paths.
+// Test that updating a key to the value it already has writes nothing.
+func TestSparseMerkleTreeUpdateUnchanged(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	root := smt.Root()
+	nodes, values := smn.Size(), smv.Size()
+
+	newRoot, err := smt.Update([]byte("testKey"), []byte("testValue"))
+	if err != nil {
+		t.Errorf("returned error when updating key to its value: %v", err)
+	}
+	if !bytes.Equal(newRoot, root) || !bytes.Equal(smt.Root(), root) {
+		t.Error("updating key to its value changed the root")
+	}
+	if smn.Size() != nodes || smv.Size() != values {
+		t.Error("updating key to its value changed the stores")
+	}
+
+	// Nothing was put again, so every key has a single reference.
+	for _, store := range []*SimpleMap{smn, smv} {
+		store.ForEachKey(func(key []byte) error {
+			if store.m[string(key)].count != 1 {
+				t.Error("updating key to its value put it again")
+			}
+			return nil
+		})
+	}
+}
+
+// Test that Update returns the new root.
+func TestSparseMerkleTreeUpdateReturnsRoot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i%15))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		proof, err := smt.ProveUpdatable(key)
+		if err != nil {
+			t.Fatalf("returned error when proving key: %v", err)
+		}
+		expected, err := ComputeRootFromProof(proof, key, value, sha256.New())
+		if err != nil {
+			t.Fatalf("returned error when computing root from proof: %v", err)
+		}
+
+		root, err := smt.Update(key, value)
+		if err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+		if !bytes.Equal(root, smt.Root()) {
+			t.Error("returned root differs from Root")
+		}
+		if !bytes.Equal(root, expected) {
+			t.Error("returned root differs from the root computed from a proof")
+		}
+	}
+}
+
+func TestSparseMerkleTreePath(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, key := range []string{"", "testKey", "testKey2"} {
+		expected := sha256.Sum256([]byte(key))
+		if !bytes.Equal(smt.Path([]byte(key)), expected[:]) {
+			t.Errorf("path of %q is not its sha256 digest", key)
+		}
+	}
+
+	// The side nodes of a proof follow the bits of the path from the leaf up.
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	path := smt.Path([]byte("testKey"))
+	proof, _ := smt.Prove([]byte("testKey"))
+	hash, _ := smt.th.digestLeaf(path, smt.th.digestValue([]byte("testValue")))
+	for j, sideNode := range proof.SideNodes {
+		i := len(proof.SideNodes) - 1 - j
+		if (path[i/8]>>(7-i%8))&1 == 1 {
+			hash, _ = smt.th.digestNode(sideNode, hash)
+		} else {
+			hash, _ = smt.th.digestNode(hash, sideNode)
+		}
+	}
+	if !bytes.Equal(hash, smt.Root()) {
+		t.Error("folding the proof along the path did not give the root")
+	}
+}
+
+func TestSparseMerkleTreeSimulateUpdate(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i%20))
+		value := []byte(fmt.Sprintf("testValue%d", i))
+		if i%7 == 6 {
+			value = defaultValue
+		}
+		root := smt.Root()
+		nodes, values := smn.Size(), smv.Size()
+
+		simulated, err := smt.SimulateUpdate(key, value)
+		if err != nil {
+			t.Fatalf("returned error when simulating update: %v", err)
+		}
+		if !bytes.Equal(smt.Root(), root) {
+			t.Error("simulating an update changed the root")
+		}
+		if smn.Size() != nodes || smv.Size() != values {
+			t.Error("simulating an update wrote to the stores")
+		}
+
+		updated, err := smt.Update(key, value)
+		if err != nil {
+			t.Fatalf("returned error when updating key: %v", err)
+		}
+		if !bytes.Equal(simulated, updated) {
+			t.Errorf("simulated root differs from the root of update %d", i)
+		}
+	}
+}
+
+func TestSparseMerkleTreeGetValueHash(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	if _, err := smt.GetValueHash([]byte("testKey")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound for a key of the empty tree: %v", err)
+	}
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+
+	// The value store is not read.
+	values := &countingStore{MapStore: smv}
+	smt.values = values
+	valueHash, err := smt.GetValueHash([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting value hash: %v", err)
+	}
+	expected := sha256.Sum256([]byte("testValue"))
+	if !bytes.Equal(valueHash, expected[:]) {
+		t.Error("value hash is not the digest of the value")
+	}
+	if _, err := smt.GetValueHash([]byte("testKey3")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound for an absent key: %v", err)
+	}
+	if values.accesses.Load() != 0 {
+		t.Error("read the value store")
+	}
+}
+
+func TestSparseMerkleTreeWalkPath(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	nodes, err := smt.WalkPath([]byte("testKey"))
+	if err != nil || len(nodes) != 1 || !bytes.Equal(nodes[0], smt.th.placeholder()) {
+		t.Errorf("did not walk the empty tree to its root: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	for i := 0; i < 20; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		nodes, err := smt.WalkPath(key)
+		if err != nil {
+			t.Fatalf("returned error when walking path: %v", err)
+		}
+		if !bytes.Equal(nodes[0], smt.Root()) {
+			t.Error("path does not start at the root")
+		}
+		leafHash, _ := smt.th.digestLeaf(smt.Path(key), smt.th.digestValue([]byte(fmt.Sprintf("testValue%d", i))))
+		if !bytes.Equal(nodes[len(nodes)-1], leafHash) {
+			t.Error("path does not end at the leaf of the key")
+		}
+		proof, _ := smt.Prove(key)
+		if len(nodes) != len(proof.SideNodes)+1 {
+			t.Errorf("got %d nodes on a path with %d side nodes", len(nodes), len(proof.SideNodes))
+		}
+	}
+}
+
+// Test that nil values delete keys, whatever the default value, and that the
+// empty value is only a deletion if it is the default value.
+func TestSparseMerkleTreeNilValue(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		defaultValue []byte
+	}{
+		{"empty default", []byte{}},
+		{"zero byte default", []byte{0}},
+	} {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDefaultValue(test.defaultValue))
+		for _, value := range [][]byte{nil, {}, {0}} {
+			isDefault := value == nil || bytes.Equal(value, test.defaultValue)
+
+			smt.Update([]byte("testKey"), []byte("testValue"))
+			root, err := smt.Update([]byte("testKey"), value)
+			if err != nil {
+				t.Fatalf("%s: returned error when updating to %v: %v", test.name, value, err)
+			}
+			has, _ := smt.Has([]byte("testKey"))
+			got, err := smt.Get([]byte("testKey"))
+			if isDefault {
+				if has || !errors.Is(err, ErrKeyNotFound) || !bytes.Equal(root, EmptyRoot(sha256.New())) {
+					t.Errorf("%s: did not delete key updated to %v", test.name, value)
+				}
+			} else if !has || err != nil || !bytes.Equal(got, value) {
+				t.Errorf("%s: did not store %v: got %v, %v", test.name, value, got, err)
+			}
+
+			// Batches and proofs agree with Update.
+			smt.Update([]byte("testKey"), []byte("testValue"))
+			batchRoot, _ := smt.UpdateBatch([][]byte{[]byte("testKey")}, [][]byte{value})
+			if !bytes.Equal(batchRoot, root) {
+				t.Errorf("%s: batch update to %v differs from update", test.name, value)
+			}
+			proof, _ := smt.Prove([]byte("testKey"))
+			if !VerifyProof(proof, root, []byte("testKey"), value, sha256.New(), WithDefaultValue(test.defaultValue)) {
+				t.Errorf("%s: could not verify proof of %v", test.name, value)
+			}
+			if isDefault != VerifyNonMembershipProof(proof, root, []byte("testKey"), sha256.New(), WithDefaultValue(test.defaultValue)) {
+				t.Errorf("%s: non-membership proof of %v does not match", test.name, value)
+			}
+		}
+	}
+}
+
+func TestSparseMerkleTreeCompareAndUpdate(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	staleRoot := smt.Root()
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	root := smt.Root()
+
+	_, err := smt.CompareAndUpdate(staleRoot, []byte("testKey2"), []byte("testValue2"))
+	if !errors.Is(err, ErrRootMismatch) {
+		t.Errorf("did not return ErrRootMismatch for a stale root: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("updated the tree at a stale root")
+	}
+	if has, _ := smt.Has([]byte("testKey2")); has {
+		t.Error("set the key at a stale root")
+	}
+
+	newRoot, err := smt.CompareAndUpdate(root, []byte("testKey2"), []byte("testValue2"))
+	if err != nil {
+		t.Errorf("returned error when updating at the current root: %v", err)
+	}
+	if !bytes.Equal(newRoot, smt.Root()) || bytes.Equal(newRoot, root) {
+		t.Error("did not update the root")
+	}
+	value, err := smt.Get([]byte("testKey2"))
+	if err != nil || !bytes.Equal(value, []byte("testValue2")) {
+		t.Error("did not get correct value after updating at the current root")
+	}
+}
+
+// Test that the roots of a known sequence of updates do not change, and that
+// updates do not modify the nodes already in the store.
+func TestSparseMerkleTreeStableRoot(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	for i := 0; i < 100; i += 3 {
+		smt.Delete([]byte(fmt.Sprintf("testKey%d", i)))
+	}
+	expected, _ := hex.DecodeString("27b73cb1033c625eb768ea3a9b85356273c4e8e7f8017b037b09a9967d34250b")
+	if !bytes.Equal(smt.Root(), expected) {
+		t.Errorf("got root %x, expected %x", smt.Root(), expected)
+	}
+
+	smn.ForEachKey(func(key []byte) error {
+		data, _ := smn.Get(key)
+		if !bytes.Equal(smt.th.digest(data), key) {
+			t.Errorf("node %x was modified", key)
+		}
+		return nil
+	})
+}
+
+// Test deleting keys in random order, comparing against trees that never had
+// the deleted keys.
+func TestSparseMerkleTreeDeleteRandomOrder(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var keys [][]byte
+	for i := 0; i < 30; i++ {
+		key := make([]byte, 8)
+		rand.Read(key)
+		keys = append(keys, key)
+		smt.Update(key, []byte("testValue"))
+	}
+
+	// Deleting a key that is not in the tree keeps the root.
+	root := smt.Root()
+	newRoot, err := smt.Delete([]byte("absent"))
+	if err != nil {
+		t.Errorf("returned error when deleting absent key: %v", err)
+	}
+	if !bytes.Equal(newRoot, root) || !bytes.Equal(smt.Root(), root) {
+		t.Error("deleting absent key changed the root")
+	}
+
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	for i, key := range keys {
+		if _, err := smt.Delete(key); err != nil {
+			t.Errorf("returned error when deleting key: %v", err)
+		}
+
+		fresh := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+		for _, remaining := range keys[i+1:] {
+			fresh.Update(remaining, []byte("testValue"))
+		}
+		if !bytes.Equal(smt.Root(), fresh.Root()) {
+			t.Fatalf("root after deleting %d keys does not match a tree without them", i+1)
+		}
+	}
+	if !bytes.Equal(smt.Root(), EmptyRoot(sha256.New())) {
+		t.Error("root after deleting all keys is not the empty root")
+	}
+}
+
+// Test reading values at old roots, before and after they are pruned.
+func TestSparseMerkleTreeGetFromRoot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	var roots [][]byte
+	for i := 0; i < 5; i++ {
+		smt.Update([]byte("testKey"), []byte{byte(i)})
+		smt.Update([]byte{byte(i)}, []byte("testValue"))
+		roots = append(roots, smt.Root())
+	}
+
+	for i, root := range roots {
+		value, err := smt.GetFromRoot([]byte("testKey"), root)
+		if err != nil {
+			t.Errorf("returned error when getting key at old root: %v", err)
+		}
+		if !bytes.Equal(value, []byte{byte(i)}) {
+			t.Errorf("did not get correct value at root %d", i)
+		}
+		value, _ = smt.GetFromRoot([]byte{byte(i + 1)}, root)
+		if !bytes.Equal(value, defaultValue) {
+			t.Errorf("got value of later key at root %d", i)
+		}
+	}
+
+	// Reading a pruned root fails instead of returning the default value.
+	if err := smt.RemovePath([]byte("testKey"), roots[0], roots[1]); err != nil {
+		t.Errorf("returned error when pruning root: %v", err)
+	}
+	var invalidKeyError *InvalidKeyError
+	if _, err := smt.GetFromRoot([]byte("testKey"), roots[0]); !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return InvalidKeyError when getting key at pruned root: %v", err)
+	}
+	value, err := smt.GetFromRoot([]byte("testKey"), roots[1])
+	if err != nil || !bytes.Equal(value, []byte{1}) {
+		t.Error("did not get correct value at kept root")
+	}
+}
+
+// Test that pruning superseded updates within a block keeps the node count
+// bounded, without affecting the previous block.
+func TestSparseMerkleTreeRemovePath(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte{byte(i)}, []byte("testValue"))
+	}
+	prevRoot := smt.Root()
+
+	// The first update of the block is kept, later ones supersede each other.
+	smt.Update([]byte("testKey"), []byte{0})
+	nodeCount, valueCount := len(smn.m), len(smv.m)
+	for i := 1; i < 20; i++ {
+		oldRoot := smt.Root()
+		smt.Update([]byte("testKey"), []byte{byte(i)})
+		if err := smt.RemovePath([]byte("testKey"), oldRoot, prevRoot); err != nil {
+			t.Errorf("returned error when removing path: %v", err)
+		}
+		if len(smn.m) != nodeCount || len(smv.m) != valueCount {
+			t.Fatalf("store grew to %d nodes and %d values after %d updates, expected %d and %d",
+				len(smn.m), len(smv.m), i+1, nodeCount, valueCount)
+		}
+	}
+
+	value, err := smt.Get([]byte("testKey"))
+	if err != nil || !bytes.Equal(value, []byte{19}) {
+		t.Error("did not get correct value after removing paths")
+	}
+	for i := 0; i < 10; i++ {
+		value, err := smt.GetFromRoot([]byte{byte(i)}, prevRoot)
+		if err != nil || !bytes.Equal(value, []byte("testValue")) {
+			t.Error("did not get correct value at previous root")
+		}
+	}
+	if value, _ := smt.GetFromRoot([]byte("testKey"), prevRoot); !bytes.Equal(value, defaultValue) {
+		t.Error("did not get default value at previous root")
+	}
+}
+
+// Test pruning a superseded update in the first block, where there is no
+// previous root to keep.
+func TestSparseMerkleTreeRemovePathForRoot(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte{byte(i)}, []byte("testValue"))
+	}
+	smt.Update([]byte("testKey"), []byte("testValue1"))
+	oldRoot := smt.Root()
+	smt.Update([]byte("testKey"), []byte("testValue2"))
+	if err := smt.RemovePathForRoot([]byte("testKey"), oldRoot); err != nil {
+		t.Errorf("returned error when removing path: %v", err)
+	}
+
+	// The nodes shared with the current root are kept.
+	for i := 0; i < 10; i++ {
+		value, err := smt.Get([]byte{byte(i)})
+		if err != nil || !bytes.Equal(value, []byte("testValue")) {
+			t.Error("did not get correct value after removing path")
+		}
+	}
+	value, err := smt.Get([]byte("testKey"))
+	if err != nil || !bytes.Equal(value, []byte("testValue2")) {
+		t.Error("did not get correct value of updated key after removing path")
+	}
+
+	// The stores are the same size as if the key had only been updated once.
+	fresh, freshValues := NewSimpleMap(), NewSimpleMap()
+	tree := NewSparseMerkleTree(fresh, freshValues, sha256.New())
+	for i := 0; i < 10; i++ {
+		tree.Update([]byte{byte(i)}, []byte("testValue"))
+	}
+	tree.Update([]byte("testKey"), []byte("testValue2"))
+	if len(smn.m) != len(fresh.m) || len(smv.m) != len(freshValues.m) {
+		t.Errorf("left %d nodes and %d values, expected %d and %d",
+			len(smn.m), len(smv.m), len(fresh.m), len(freshValues.m))
+	}
+}
+
+// Test that leaf values are kept in the value store and nodes in the node
+// store, and that both can be the same store.
+func TestSparseMerkleTreeSeparateStores(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	shared := NewSimpleMap()
+	sharedTree := NewSparseMerkleTree(shared, shared, sha256.New())
+
+	for i := 0; i < 10; i++ {
+		key, value := []byte{byte(i)}, bytes.Repeat([]byte{byte(i)}, 100)
+		smt.Update(key, value)
+		sharedTree.Update(key, value)
+	}
+	smt.Delete([]byte{3})
+	sharedTree.Delete([]byte{3})
+
+	for _, v := range smn.m {
+		if len(v.data) == 100 {
+			t.Error("found a value in the node store")
+		}
+	}
+	for _, v := range smv.m {
+		if len(v.data) != 100 {
+			t.Error("found a node in the value store")
+		}
+	}
+	if len(shared.m) != len(smn.m)+len(smv.m) {
+		t.Errorf("shared store holds %d entries, expected %d", len(shared.m), len(smn.m)+len(smv.m))
+	}
+
+	for i := 0; i < 10; i++ {
+		expected := bytes.Repeat([]byte{byte(i)}, 100)
+		if i == 3 {
+			expected = defaultValue
+		}
+		value, _ := sharedTree.Get([]byte{byte(i)})
+		if !bytes.Equal(value, expected) {
+			t.Error("did not get correct value from tree with a shared store")
+		}
+		proof, _ := sharedTree.Prove([]byte{byte(i)})
+		if !VerifyProof(proof, smt.Root(), []byte{byte(i)}, expected, sha256.New()) {
+			t.Error("proof from tree with a shared store failed to verify")
+		}
+	}
+}
+
+func TestOpenSparseMerkleTree(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+
+	empty, err := OpenSparseMerkleTree(smn, smv, sha256.New(), smt.Root())
+	if err != nil {
+		t.Errorf("returned error when opening empty tree: %v", err)
+	}
+	if !bytes.Equal(empty.Root(), smt.Root()) {
+		t.Error("opened empty tree at wrong root")
+	}
+
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte{byte(i)}, []byte{byte(i)})
+	}
+	reopened, err := OpenSparseMerkleTree(smn, smv, sha256.New(), smt.Root())
+	if err != nil {
+		t.Errorf("returned error when opening tree: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		value, err := reopened.Get([]byte{byte(i)})
+		if err != nil || !bytes.Equal(value, []byte{byte(i)}) {
+			t.Error("did not get correct value from reopened tree")
+		}
+	}
+
+	missing := sha256.Sum256([]byte("missing"))
+	if _, err := OpenSparseMerkleTree(smn, smv, sha256.New(), missing[:]); !errors.Is(err, ErrRootNotFound) {
+		t.Errorf("did not return ErrRootNotFound when opening missing root: %v", err)
+	}
+}
+
+func TestSparseMerkleTreeByPath(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt2 := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	for _, key := range []string{"testKey", "testKey2", "foo"} {
+		root, err := smt.Update([]byte(key), []byte("value of "+key))
+		if err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+		root2, err := smt2.UpdateByPath(smt2.th.path([]byte(key)), []byte("value of "+key))
+		if err != nil {
+			t.Errorf("returned error when updating path: %v", err)
+		}
+		if !bytes.Equal(root, root2) {
+			t.Error("updating by path did not produce the same root as updating by key")
+		}
+	}
+
+	value, err := smt2.GetByPath(smt2.th.path([]byte("foo")))
+	if err != nil {
+		t.Errorf("returned error when getting path: %v", err)
+	}
+	if !bytes.Equal([]byte("value of foo"), value) {
+		t.Error("did not get correct value when getting path")
+	}
+	_, err = smt2.GetByPath(smt2.th.path([]byte("bar")))
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting empty path: %v", err)
+	}
+
+	if _, err := smt2.GetByPath([]byte("foo")); !errors.Is(err, ErrInvalidPath) {
+		t.Error("did not return ErrInvalidPath when getting a short path")
+	}
+	if _, err := smt2.UpdateByPath([]byte("foo"), []byte("value")); !errors.Is(err, ErrInvalidPath) {
+		t.Error("did not return ErrInvalidPath when updating a short path")
+	}
+	if _, err := smt2.GetByPath(append(smt2.Path([]byte("foo")), 0)); !errors.Is(err, ErrInvalidPath) {
+		t.Error("did not return ErrInvalidPath when getting a long path")
+	}
+
+	// Paths from Path update and delete the same leaves as keys.
+	root, _ := smt.Update([]byte("testKey"), []byte("new value"))
+	root2, _ := smt2.UpdateByPath(smt2.Path([]byte("testKey")), []byte("new value"))
+	if !bytes.Equal(root, root2) {
+		t.Error("updating by path from Path did not produce the same root as updating by key")
+	}
+	root, _ = smt.Delete([]byte("foo"))
+	root2, _ = smt2.UpdateByPath(smt2.Path([]byte("foo")), nil)
+	if !bytes.Equal(root, root2) {
+		t.Error("deleting by path did not produce the same root as deleting by key")
+	}
+}
+
+func TestSparseMerkleTreeHas(t *testing.T) {
+	smn := NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, NewSimpleMap(), sha256.New())
+	for i := 0; i < 20; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, key)
+	}
+	for i := 0; i < 10; i++ {
+		smt.Delete([]byte(strconv.Itoa(i)))
+	}
+
+	// Presence is decided from the nodes only, without reading values.
+	smt = ImportSparseMerkleTree(smn, NewSimpleMap(), sha256.New(), smt.Root())
+	for i := 0; i < 30; i++ {
+		has, err := smt.Has([]byte(strconv.Itoa(i)))
+		if err != nil {
+			t.Errorf("returned error when checking presence of key: %v", err)
+		}
+		// Keys below 10 were deleted, and keys from 20 never inserted.
+		if has != (i >= 10 && i < 20) {
+			t.Errorf("got presence %v for key %d", has, i)
+		}
+	}
+}
+
+// failingStore is a MapStore whose writes fail, like a read-only database.
+type failingStore struct {
+	MapStore
+}
+
+var errReadOnly = errors.New("read-only store")
+
+func (fs failingStore) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+func (fs failingStore) Delete(key []byte) error {
+	return errReadOnly
+}
+
+func TestSparseMerkleTreeFailingStore(t *testing.T) {
+	// The constructor does not write to the stores, so it cannot fail.
+	smt := NewSparseMerkleTree(failingStore{NewSimpleMap()}, failingStore{NewSimpleMap()}, sha256.New())
+	root := smt.Root()
+
+	// The first write surfaces the error, and leaves the tree unchanged.
+	if _, err := smt.Update([]byte("testKey"), []byte("testValue")); !errors.Is(err, errReadOnly) {
+		t.Errorf("did not return the store error when updating key: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("failed update changed the root")
+	}
+	if _, err := smt.UpdateBatch([][]byte{[]byte("testKey")}, [][]byte{[]byte("testValue")}); !errors.Is(err, errReadOnly) {
+		t.Errorf("did not return the store error when updating batch: %v", err)
+	}
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("failed batch changed the root")
+	}
+}
+
+func TestSparseMerkleTreeShareSubtree(t *testing.T) {
+	h := newDummyHasher(sha256.New())
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), h)
+
+	// The dummy hasher uses keys prefixed with four bytes of 0 as their paths.
+	keyA := make([]byte, h.Size()+4)
+	keyB := make([]byte, h.Size()+4)
+	keyB[4] = 0x10 // Paths differ at bit 3.
+
+	for depth := 0; depth <= 3; depth++ {
+		if !smt.ShareSubtree(keyA, keyB, depth) {
+			t.Errorf("keys did not share subtree at depth %d", depth)
+		}
+	}
+	if smt.ShareSubtree(keyA, keyB, 4) {
+		t.Error("keys shared subtree past their common prefix")
+	}
+	if !smt.ShareSubtree(keyA, keyA, smt.depth()) {
+		t.Error("key did not share subtree with itself at full depth")
+	}
+
+	pathA, pathB := keyA[4:], keyB[4:]
+	if !smt.ShareSubtreeByPath(pathA, pathB, 3) || smt.ShareSubtreeByPath(pathA, pathB, 4) {
+		t.Error("paths did not share subtree like their keys")
+	}
+	if smt.ShareSubtreeByPath(pathA, []byte("short"), 0) {
+		t.Error("short path shared subtree")
+	}
+}
+
+// Test that malformed node data in the store produces errors instead of panics.
+func TestSparseMerkleTreeConcurrentUse(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i)))
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(strconv.Itoa(i))
+				value, err := smt.Get(key)
+				if err != nil {
+					t.Errorf("returned error when getting key: %v", err)
+				}
+				if len(value) == 0 {
+					t.Error("did not get value of key never deleted")
+				}
+				if _, err := smt.Prove(key); err != nil {
+					t.Errorf("returned error when proving key: %v", err)
+				}
+			}
+		}()
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(strconv.Itoa(i))
+				if _, err := smt.Update(key, []byte(strconv.Itoa(g))); err != nil {
+					t.Errorf("returned error when updating key: %v", err)
+				}
+				if _, err := smt.Delete([]byte(strconv.Itoa(1000*(g+1) + i))); err != nil {
+					t.Errorf("returned error when deleting key: %v", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		value, _ := smt.Get(key)
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), key, value, sha256.New()) {
+			t.Error("proof of key not valid after concurrent updates")
+		}
+	}
+}
+
+func TestSparseMerkleTreeWithHasherFunc(t *testing.T) {
+	smt := NewSparseMerkleTreeWithHasherFunc(NewSimpleMap(), NewSimpleMap(), sha256.New)
+	shared := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	// Digests with fresh hashers run concurrently without data races.
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				data := []byte(strconv.Itoa(i))
+				expected := sha256.Sum256(data)
+				if !bytes.Equal(smt.th.digest(data), expected[:]) {
+					t.Error("did not get correct digest")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		smt.Update(key, key)
+		shared.Update(key, key)
+	}
+	if !bytes.Equal(smt.Root(), shared.Root()) {
+		t.Error("did not get the same root as with a shared hasher")
+	}
+
+	imported := ImportSparseMerkleTreeWithHasherFunc(smt.nodes, smt.values, sha256.New, smt.Root())
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				key := []byte(strconv.Itoa(i))
+				value, err := imported.Get(key)
+				if err != nil {
+					t.Errorf("returned error when getting key: %v", err)
+				}
+				if !bytes.Equal(value, key) {
+					t.Error("did not get correct value when getting key")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSparseMerkleTreeHashers(t *testing.T) {
+	newBlake2b := func() hash.Hash {
+		hasher, _ := blake2b.New256(nil)
+		return hasher
+	}
+	hashers := map[string]func() hash.Hash{
+		"sha256":    sha256.New,
+		"blake2b":   newBlake2b,
+		"keccak256": sha3.NewLegacyKeccak256,
+	}
+
+	roots := make(map[string]string)
+	for name, newHasher := range hashers {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), newHasher())
+		for i := 0; i < 20; i++ {
+			key := []byte(strconv.Itoa(i))
+			smt.Update(key, key)
+		}
+		if other, ok := roots[string(smt.Root())]; ok {
+			t.Errorf("%s and %s trees have the same root", name, other)
+		}
+		roots[string(smt.Root())] = name
+
+		for i := 0; i < 20; i++ {
+			key := []byte(strconv.Itoa(i))
+			proof, err := smt.Prove(key)
+			if err != nil {
+				t.Errorf("%s: returned error when proving key: %v", name, err)
+			}
+			if !VerifyProof(proof, smt.Root(), key, key, newHasher()) {
+				t.Errorf("%s: valid proof failed to verify", name)
+			}
+			if VerifyProof(proof, smt.Root(), key, key, sha256.New()) != (name == "sha256") {
+				t.Errorf("%s: proof verified with the wrong hasher", name)
+			}
+		}
+	}
+}
+
+func TestSparseMerkleTreeWideHasher(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha512.New())
+	if smt.th.pathSize() != 64 {
+		t.Errorf("got path size %d, want 64", smt.th.pathSize())
+	}
+	if smt.depth() != 8*smt.th.pathSize() {
+		t.Errorf("got depth %d, want %d", smt.depth(), 8*smt.th.pathSize())
+	}
+	if len(smt.Root()) != 64 || len(smt.th.path([]byte("testKey"))) != 64 {
+		t.Error("root and paths do not have the size of the hasher")
+	}
+
+	for i := 0; i < 20; i++ {
+		key := []byte(strconv.Itoa(i))
+		if _, err := smt.Update(key, key); err != nil {
+			t.Errorf("returned error when updating key: %v", err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		key := []byte(strconv.Itoa(i))
+		value, err := smt.Get(key)
+		if err != nil || !bytes.Equal(value, key) {
+			t.Error("did not get correct value")
+		}
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), key, key, sha512.New()) {
+			t.Error("valid proof failed to verify")
+		}
+		if len(proof.SideNodes) > smt.depth() {
+			t.Errorf("got %d side nodes, at most %d expected", len(proof.SideNodes), smt.depth())
+		}
+		compactProof, err := smt.ProveCompact(key)
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyCompactProof(compactProof, smt.Root(), key, key, sha512.New()) {
+			t.Error("valid compact proof failed to verify")
+		}
+	}
+
+	for i := 0; i < 20; i += 2 {
+		key := []byte(strconv.Itoa(i))
+		if _, err := smt.Delete(key); err != nil {
+			t.Errorf("returned error when deleting key: %v", err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		key := []byte(strconv.Itoa(i))
+		expected := key
+		if i%2 == 0 {
+			expected = defaultValue
+		}
+		proof, err := smt.Prove(key)
+		if err != nil {
+			t.Errorf("returned error when proving key: %v", err)
+		}
+		if !VerifyProof(proof, smt.Root(), key, expected, sha512.New()) {
+			t.Error("valid proof failed to verify after deletions")
+		}
+	}
+	remaining := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha512.New())
+	for i := 1; i < 20; i += 2 {
+		key := []byte(strconv.Itoa(i))
+		remaining.Update(key, key)
+	}
+	if !bytes.Equal(smt.Root(), remaining.Root()) {
+		t.Error("deleting keys gave a different root than never setting them")
+	}
+
+	// A path of the full depth fits in a proof.
+	th := newTreeHasher(sha512.New())
+	proof := SparseMerkleProof{SideNodes: make([][]byte, smt.depth())}
+	for i := range proof.SideNodes {
+		proof.SideNodes[i] = th.placeholder()
+	}
+	if !proof.sanityCheck(th) {
+		t.Error("proof with a side node at every height failed the sanity check")
+	}
+}
+
+func TestSparseMerkleTreeCorruptNode(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+
+	// Poison the root, an inner node, by dropping its last byte.
+	root := smt.Root()
+	value := smn.m[string(root)]
+	value.data = value.data[:len(value.data)-1]
+	smn.m[string(root)] = value
+
+	got, err := smt.Get([]byte("testKey1"))
+	var corrupt *CorruptNodeError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("did not return a CorruptNodeError when getting key: %v", err)
+	}
+	if got != nil {
+		t.Error("returned a value from a corrupt tree")
+	}
+	want := len(nodePrefix) + 2*sha256.Size
+	if !bytes.Equal(corrupt.Hash, root) || corrupt.Got != want-1 || corrupt.Want != want {
+		t.Errorf("got corruption error %+v, expected node %x of %d bytes instead of %d", corrupt, root, want-1, want)
+	}
+	if !errors.Is(err, ErrMalformedNode) {
+		t.Error("corruption error does not wrap ErrMalformedNode")
+	}
+}
+
+func TestSparseMerkleTreeMalformedNodes(t *testing.T) {
+	th := newTreeHasher(sha256.New())
+	for _, data := range [][]byte{
+		nil,
+		leafPrefix,
+		nodePrefix,
+		append(append([]byte{}, leafPrefix...), make([]byte, th.pathSize())...),
+		append(append([]byte{}, nodePrefix...), make([]byte, th.pathSize())...),
+		append(append([]byte{}, nodePrefix...), make([]byte, 2*th.pathSize()+1)...),
+		{2},
+	} {
+		if err := th.checkNode(nil, data); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not reject malformed node data %x", data)
+		}
+	}
+
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey2"), []byte("testValue2"))
+	smt.Update([]byte("testKey3"), []byte("testValue3"))
+	if err := th.checkNode(smt.Root(), smn.m[string(smt.Root())].data); err != nil {
+		t.Errorf("rejected valid node data: %v", err)
+	}
+
+	for _, corrupt := range [][]byte{nil, nodePrefix, leafPrefix} {
+		for key, value := range smn.m {
+			value.data = corrupt
+			smn.m[key] = value
+		}
+
+		if _, err := smt.Get([]byte("testKey1")); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when getting key: %v", err)
+		}
+		if _, err := smt.Update([]byte("testKey4"), []byte("testValue4")); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when updating key: %v", err)
+		}
+		if _, err := smt.Prove([]byte("testKey1")); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when proving key: %v", err)
+		}
+		if _, err := smt.GetDescend([]byte("testKey1")); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when descending: %v", err)
+		}
+		err := smt.IterateWithProofs(func(path []byte, value []byte, proof SparseMerkleProof) bool { return true })
+		if !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when iterating: %v", err)
+		}
+		if _, err := smt.ProveMulti([][]byte{[]byte("testKey1")}); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when proving keys: %v", err)
+		}
+		if _, err := smt.Diff(smt.Root(), EmptyRoot(sha256.New())); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when diffing roots: %v", err)
+		}
+		if _, err := smt.Stats(); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when getting stats: %v", err)
+		}
+		if _, err := smt.SimulateUpdate([]byte("testKey4"), []byte("testValue4")); !errors.Is(err, ErrMalformedNode) {
+			t.Errorf("did not return ErrMalformedNode when simulating update: %v", err)
+		}
+	}
+}
+
 // dummyHasher is a dummy hasher for tests, where the digest of keys is equivalent to the preimage.
 type dummyHasher struct {
 	baseHasher hash.Hash
@@ -613,4 +1637,36 @@ func (h *dummyHasher) BlockSize() int {
 // 			t.Errorf("returned error when updating non-empty key: %v", err)
 // 		}
 // 	})
-// }
\ No newline at end of file
+// }
+func TestSparseMerkleTreeGetKeyNotFound(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithDefaultValue([]byte("absent")))
+	if _, err := smt.Get([]byte("testKey")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting key of empty tree: %v", err)
+	}
+	smt.Update([]byte("testKey"), []byte{})
+	smt.Update([]byte("testKey2"), []byte("testValue"))
+	root := smt.Root()
+	smt.Delete([]byte("testKey2"))
+
+	value, err := smt.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting key with empty value: %v", err)
+	}
+	if value == nil || len(value) != 0 {
+		t.Error("did not get empty value")
+	}
+	if _, err := smt.Get([]byte("testKey2")); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return ErrKeyNotFound when getting deleted key: %v", err)
+	}
+
+	// Errors of the store are returned as they are.
+	missing := sha256.Sum256([]byte("missing"))
+	_, err = smt.GetFromRoot([]byte("testKey"), missing[:])
+	var invalidKey *InvalidKeyError
+	if !errors.As(err, &invalidKey) || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("did not return store error when getting key from missing root: %v", err)
+	}
+	if value, err := smt.GetFromRoot([]byte("testKey2"), root); err != nil || !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get value of older root")
+	}
+}
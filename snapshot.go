@@ -0,0 +1,296 @@
+package smt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ErrBadSnapshot is returned when a snapshot is not a consistent tree with the
+// claimed root.
+var ErrBadSnapshot = errors.New("bad snapshot")
+
+// ExportSnapshot writes the nodes of the tree at its current root, with the
+// values of its leaves, to w.
+//
+// A snapshot is a sequence of node records in depth-first order, parents
+// before children and left before right. Each record holds the hash and data
+// of the node and, for leaves, the value, each prefixed with its length as a
+// uvarint. The snapshot of an empty tree has no records.
+func (smt *SparseMerkleTree) ExportSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	err := smt.snapshotRecords(func(record []byte) error {
+		_, err := bw.Write(record)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// StateDigest returns a digest of the snapshot of the tree at its current
+// root, as written by ExportSnapshot. It is computed as a chain of digests,
+// one per record of the snapshot, starting from the placeholder.
+//
+// Unlike the root, which only commits to the digests of the values, the state
+// digest covers the values themselves, and it can only be computed if every
+// node and value is present in the stores. Replicas with the same root and
+// consistent stores thus have the same state digest, and it is a cheap key for
+// caching the exported state. It is not a substitute for the root: it is not
+// checked by proofs, and computing it reads the whole tree.
+func (smt *SparseMerkleTree) StateDigest() ([]byte, error) {
+	digest := smt.th.placeholder()
+	err := smt.snapshotRecords(func(record []byte) error {
+		data := make([]byte, 0, len(digest)+len(record))
+		data = append(data, digest...)
+		data = append(data, record...)
+		digest = smt.th.digest(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// snapshotRecords calls fn with every record of the snapshot of the tree at its
+// current root, in order. The record is only valid until fn returns.
+func (smt *SparseMerkleTree) snapshotRecords(fn func(record []byte) error) error {
+	var buf []byte
+	return smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		buf = appendBytes(buf[:0], hash)
+		buf = appendBytes(buf, data)
+		if smt.th.isLeaf(data) {
+			value, err := smt.leafValue(data, inlineValue)
+			if err != nil {
+				return err
+			}
+			buf = appendBytes(buf, value)
+		}
+		return fn(buf)
+	})
+}
+
+// VerifySnapshot reads a snapshot written by ExportSnapshot from r, and checks
+// that it is a consistent tree with root claimedRoot: every node hash is the
+// digest of its data, every leaf value matches its digest, every node is
+// reachable from the root and every node referenced is present. It returns
+// false with an error wrapping ErrBadSnapshot describing the first
+// inconsistency found, or another error if reading fails. With
+// WithMaxValueSize, larger values are rejected with ErrValueTooLarge before
+// being read. The options must match the ones the tree was built with.
+//
+// The snapshot is streamed, so memory use is bounded by the depth of the tree
+// rather than its size.
+func VerifySnapshot(r io.Reader, claimedRoot []byte, hasher hash.Hash, options ...Option) (bool, error) {
+	th := treeHasherWithOptions(hasher, options)
+	if _, err := scanSnapshot(r, claimedRoot, th, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ImportSnapshot reads a snapshot written by ExportSnapshot from r into the
+// stores, and returns the tree at the root of the snapshot, the hash of its
+// first node. The snapshot is checked like with VerifySnapshot as it is read,
+// and records are written as soon as they are read, so on error the records
+// read so far stay in the stores. The nodes and values may share a store. The
+// options must match the ones the tree was built with.
+//
+// The root of the snapshot is not known in advance, so callers should check
+// the root of the tree against the one they expect.
+func ImportSnapshot(nodes, values MapStore, r io.Reader, hasher hash.Hash, options ...Option) (*SparseMerkleTree, error) {
+	smt := NewSparseMerkleTree(nodes, values, hasher, options...)
+	root, err := scanSnapshot(r, nil, &smt.th, func(hash, data, value []byte) error {
+		if smt.th.isLeaf(data) {
+			return smt.putLeaf(hash, data, value)
+		}
+		return smt.nodes.Put(hash, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	smt.SetRoot(root)
+	return smt, nil
+}
+
+// scanSnapshot reads a snapshot from r and checks it like VerifySnapshot,
+// calling fn, if not nil, with every record, the value being nil for inner
+// nodes. Without fn, values are digested without being held. If claimedRoot is
+// nil, the first node is taken as the root. It returns the root.
+func scanSnapshot(r io.Reader, claimedRoot []byte, th *treeHasher, fn func(hash, data, value []byte) error) ([]byte, error) {
+	br := bufio.NewReader(r)
+	maxDataSize := len(nodePrefix) + 2*th.pathSize()
+	if leafSize := len(leafPrefix) + th.pathSize() + th.valueSize(); leafSize > maxDataSize {
+		maxDataSize = leafSize
+	}
+
+	// The hashes of the nodes still expected, the next one last. As records
+	// come in depth-first order, this holds at most two nodes per level.
+	var expected [][]byte
+	root := claimedRoot
+	if root != nil && !bytes.Equal(root, th.placeholder()) {
+		expected = append(expected, root)
+	}
+
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+		hash, err := readSnapshotBytes(br, th.pathSize())
+		if err != nil {
+			return nil, err
+		}
+		data, err := readSnapshotBytes(br, maxDataSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if root == nil {
+			root = hash
+			expected = append(expected, root)
+		}
+		if len(expected) == 0 {
+			return nil, fmt.Errorf("%w: unreachable node %x", ErrBadSnapshot, hash)
+		}
+		next := expected[len(expected)-1]
+		expected = expected[:len(expected)-1]
+		if !bytes.Equal(hash, next) {
+			return nil, fmt.Errorf("%w: unexpected node %x in place of %x", ErrBadSnapshot, hash, next)
+		}
+		if !bytes.Equal(th.digest(data), hash) {
+			return nil, fmt.Errorf("%w: node %x does not match its data", ErrBadSnapshot, hash)
+		}
+		if err := th.checkNode(hash, data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+		}
+
+		if th.isLeaf(data) {
+			_, valueHash, _ := th.parseLeaf(data)
+			var value, digest []byte
+			if fn == nil {
+				digest, err = digestSnapshotValue(br, th)
+			} else if value, err = readSnapshotValue(br, th); err == nil {
+				digest = th.digestValue(value)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(digest, valueHash) {
+				return nil, fmt.Errorf("%w: value of leaf %x does not match its digest", ErrBadSnapshot, hash)
+			}
+			if fn != nil {
+				if err := fn(hash, data, value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if fn != nil {
+			if err := fn(hash, data, nil); err != nil {
+				return nil, err
+			}
+		}
+		leftNode, rightNode := th.parseNode(data)
+		if !bytes.Equal(rightNode, th.placeholder()) {
+			expected = append(expected, rightNode)
+		}
+		if !bytes.Equal(leftNode, th.placeholder()) {
+			expected = append(expected, leftNode)
+		}
+	}
+
+	if len(expected) > 0 {
+		return nil, fmt.Errorf("%w: dangling reference to node %x", ErrBadSnapshot, expected[len(expected)-1])
+	}
+	if root == nil {
+		// The snapshot of an empty tree has no records.
+		root = th.placeholder()
+	}
+	return root, nil
+}
+
+// readSnapshotLength reads a uvarint length of at most max from a snapshot.
+func readSnapshotLength(r *bufio.Reader, max uint64) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+	if n > max {
+		return 0, fmt.Errorf("%w: length %d too large", ErrBadSnapshot, n)
+	}
+	return n, nil
+}
+
+// readSnapshotBytes reads a length-prefixed byte slice of at most max bytes
+// from a snapshot.
+func readSnapshotBytes(r *bufio.Reader, max int) ([]byte, error) {
+	n, err := readSnapshotLength(r, uint64(max))
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readSnapshotValueLength reads the length of a value from a snapshot. It
+// returns ErrValueTooLarge if it exceeds the maximum value size.
+func readSnapshotValueLength(r *bufio.Reader, th *treeHasher) (uint64, error) {
+	n, err := readSnapshotLength(r, ^uint64(0)>>1)
+	if err != nil {
+		return 0, err
+	}
+	if th.maxValueSize > 0 && n > uint64(th.maxValueSize) {
+		return 0, fmt.Errorf("%w: %d bytes, at most %d", ErrValueTooLarge, n, th.maxValueSize)
+	}
+	return n, nil
+}
+
+// readSnapshotValue reads a length-prefixed value from a snapshot. The value
+// is read in chunks, so a corrupt length fails when the snapshot ends rather
+// than allocating it at once.
+func readSnapshotValue(r *bufio.Reader, th *treeHasher) ([]byte, error) {
+	n, err := readSnapshotValueLength(r, th)
+	if err != nil {
+		return nil, err
+	}
+	value, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(value)) != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return value, nil
+}
+
+// digestSnapshotValue reads a length-prefixed value from a snapshot and
+// returns its digest, streaming it into the value hasher rather than holding
+// it.
+func digestSnapshotValue(r *bufio.Reader, th *treeHasher) ([]byte, error) {
+	n, err := readSnapshotValueLength(r, th)
+	if err != nil {
+		return nil, err
+	}
+	hasher := th.valueDigester()
+	defer hasher.Reset()
+	if _, err := io.CopyN(hasher, r, int64(n)); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
@@ -0,0 +1,214 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	var buf bytes.Buffer
+	if err := smt.ExportSnapshot(&buf); err != nil {
+		t.Errorf("returned error when exporting empty tree: %v", err)
+	}
+	if ok, err := VerifySnapshot(&buf, smt.Root(), sha256.New()); !ok || err != nil {
+		t.Errorf("empty snapshot failed to verify: %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	buf.Reset()
+	if err := smt.ExportSnapshot(&buf); err != nil {
+		t.Errorf("returned error when exporting tree: %v", err)
+	}
+	snapshot := buf.Bytes()
+
+	if ok, err := VerifySnapshot(bytes.NewReader(snapshot), smt.Root(), sha256.New()); !ok || err != nil {
+		t.Errorf("valid snapshot failed to verify: %v", err)
+	}
+	if ok, err := VerifySnapshot(bytes.NewReader(snapshot), EmptyRoot(sha256.New()), sha256.New()); ok || !errors.Is(err, ErrBadSnapshot) {
+		t.Error("snapshot verified against the empty root")
+	}
+	wrongRoot := sha256.Sum256([]byte("wrong"))
+	if ok, err := VerifySnapshot(bytes.NewReader(snapshot), wrongRoot[:], sha256.New()); ok || !errors.Is(err, ErrBadSnapshot) {
+		t.Error("snapshot verified against a wrong root")
+	}
+
+	// Tampering with any byte, or dropping records, fails verification.
+	for i := 0; i < len(snapshot); i += 7 {
+		tampered := append([]byte{}, snapshot...)
+		tampered[i] ^= 1
+		if ok, _ := VerifySnapshot(bytes.NewReader(tampered), smt.Root(), sha256.New()); ok {
+			t.Fatalf("snapshot tampered at byte %d verified", i)
+		}
+	}
+	if ok, err := VerifySnapshot(bytes.NewReader(snapshot[:len(snapshot)/2]), smt.Root(), sha256.New()); ok || err == nil {
+		t.Error("truncated snapshot verified")
+	}
+
+	// A snapshot missing the last leaf has a dangling reference.
+	var partial bytes.Buffer
+	var records int
+	smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		records++
+		return nil
+	})
+	smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		if records--; records == 0 {
+			return errStopWalk
+		}
+		buf := appendBytes(appendBytes(nil, hash), data)
+		if smt.th.isLeaf(data) {
+			value, _ := smt.values.Get(smt.th.valueKey(data))
+			buf = appendBytes(buf, value)
+		}
+		partial.Write(buf)
+		return nil
+	})
+	if ok, err := VerifySnapshot(&partial, smt.Root(), sha256.New()); ok || !errors.Is(err, ErrBadSnapshot) {
+		t.Errorf("snapshot with dangling reference verified: %v", err)
+	}
+
+	// Extra records are unreachable.
+	extra := append(append([]byte{}, snapshot...), snapshot...)
+	if ok, err := VerifySnapshot(bytes.NewReader(extra), smt.Root(), sha256.New()); ok || !errors.Is(err, ErrBadSnapshot) {
+		t.Errorf("snapshot with unreachable nodes verified: %v", err)
+	}
+	if _, err := VerifySnapshot(bytes.NewReader(snapshot[:1]), smt.Root(), sha256.New()); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("did not return io.ErrUnexpectedEOF for short snapshot: %v", err)
+	}
+}
+
+func TestImportSnapshot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	smt.Delete([]byte("testKey0"))
+
+	var buf bytes.Buffer
+	if err := smt.ExportSnapshot(&buf); err != nil {
+		t.Fatalf("returned error when exporting tree: %v", err)
+	}
+	snapshot := buf.Bytes()
+	store := NewSimpleMap()
+	imported, err := ImportSnapshot(store, store, bytes.NewReader(snapshot), sha256.New())
+	if err != nil {
+		t.Fatalf("returned error when importing snapshot: %v", err)
+	}
+	if !bytes.Equal(imported.Root(), smt.Root()) {
+		t.Error("imported tree has a different root")
+	}
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		expected, err := smt.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		value, err := imported.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key from imported tree: %v", err)
+		}
+		if !bytes.Equal(value, expected) {
+			t.Errorf("imported tree has a different value for key %d", i)
+		}
+	}
+
+	// The empty snapshot imports the empty tree.
+	empty, err := ImportSnapshot(NewSimpleMap(), NewSimpleMap(), bytes.NewReader(nil), sha256.New())
+	if err != nil || !bytes.Equal(empty.Root(), EmptyRoot(sha256.New())) {
+		t.Errorf("did not import the empty tree: %v", err)
+	}
+
+	// Tampered snapshots are rejected.
+	for i := 0; i < len(snapshot); i += 11 {
+		tampered := append([]byte{}, snapshot...)
+		tampered[i] ^= 1
+		if _, err := ImportSnapshot(NewSimpleMap(), NewSimpleMap(), bytes.NewReader(tampered), sha256.New()); err == nil {
+			t.Fatalf("imported snapshot tampered at byte %d", i)
+		}
+	}
+}
+
+func TestSnapshotMaxValueSize(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	smt.Update([]byte("testKey"), []byte("testValue"))
+	smt.Update([]byte("testKey2"), bytes.Repeat([]byte("a"), 100))
+	var buf bytes.Buffer
+	smt.ExportSnapshot(&buf)
+	snapshot := buf.Bytes()
+
+	options := []Option{WithMaxValueSize(99)}
+	if _, err := VerifySnapshot(bytes.NewReader(snapshot), smt.Root(), sha256.New(), options...); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("did not return ErrValueTooLarge when verifying: %v", err)
+	}
+	if _, err := ImportSnapshot(NewSimpleMap(), NewSimpleMap(), bytes.NewReader(snapshot), sha256.New(), options...); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("did not return ErrValueTooLarge when importing: %v", err)
+	}
+	options = []Option{WithMaxValueSize(100)}
+	if _, err := ImportSnapshot(NewSimpleMap(), NewSimpleMap(), bytes.NewReader(snapshot), sha256.New(), options...); err != nil {
+		t.Errorf("returned error when importing values of the maximum size: %v", err)
+	}
+}
+
+func TestSnapshotWithValueHasher(t *testing.T) {
+	options := []Option{WithValueHasher(sha512.New())}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), options...)
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	var buf bytes.Buffer
+	smt.ExportSnapshot(&buf)
+	if ok, err := VerifySnapshot(&buf, smt.Root(), sha256.New(), options...); !ok || err != nil {
+		t.Errorf("valid snapshot failed to verify: %v", err)
+	}
+}
+
+func TestStateDigest(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	replica := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	emptyDigest, err := smt.StateDigest()
+	if err != nil {
+		t.Errorf("returned error when digesting empty tree: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	// The replica reaches the same state in another order.
+	for i := 19; i >= 0; i-- {
+		replica.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	replica.Update([]byte("extra"), []byte("value"))
+	replica.Delete([]byte("extra"))
+
+	digest, err := smt.StateDigest()
+	if err != nil {
+		t.Errorf("returned error when digesting tree: %v", err)
+	}
+	replicaDigest, _ := replica.StateDigest()
+	if !bytes.Equal(digest, replicaDigest) {
+		t.Error("replicas with the same root have different state digests")
+	}
+	if bytes.Equal(digest, emptyDigest) || bytes.Equal(digest, smt.Root()) {
+		t.Error("state digest did not change with the state")
+	}
+
+	// A store that lost a value no longer digests.
+	value, _ := smt.Get([]byte("testKey3"))
+	path := smt.th.path([]byte("testKey3"))
+	_, leafData := smt.th.digestLeaf(path, smt.th.digestValue(value))
+	smv.Delete(smt.th.valueKey(leafData))
+	if _, err := smt.StateDigest(); err == nil {
+		t.Error("did not return error when digesting tree with a lost value")
+	}
+}
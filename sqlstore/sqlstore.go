@@ -0,0 +1,253 @@
+// Package sqlstore provides an smt.MapStore backed by an SQL table.
+//
+// The module requires Go 1.23, rather than the Go 1.19 of smt, as does
+// modernc.org/sqlite, the pure Go SQLite driver its tests run on. The package
+// itself imports no driver.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/memoio/smt"
+)
+
+// Store is an smt.MapStore backed by a single SQL table of the form
+// (hash BYTEA PRIMARY KEY, value BYTEA, refcount INT).
+//
+// Like smt.SimpleMap, the store keeps a reference count per key: Put increments
+// it and Delete only removes the row once it drops to zero. The queries use
+// PostgreSQL syntax; the caller brings its own driver and connection pool.
+//
+// Each call runs as its own statement unless a transaction was started with
+// Begin, in which case every call joins it until Commit or Discard. Wrapping a
+// tree operation in a transaction saves a round trip to the disk per node.
+// The store is safe for concurrent use.
+type Store struct {
+	db *sql.DB
+
+	mu      sync.RWMutex
+	tx      *sql.Tx
+	txStmts map[*sql.Stmt]*sql.Stmt // Statements bound to tx, by statement.
+
+	getStmt, hasStmt, putStmt, decrStmt, deleteStmt, keysStmt *sql.Stmt
+}
+
+// New creates a new Store on the given table, creating the table if it does not
+// exist yet. The table name is used verbatim in the queries and must not come
+// from untrusted input.
+func New(db *sql.DB, table string) (*Store, error) {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		hash BYTEA PRIMARY KEY,
+		value BYTEA NOT NULL,
+		refcount INT NOT NULL
+	)`, table))
+	if err != nil {
+		return nil, err
+	}
+
+	ss := Store{db: db}
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&ss.getStmt, `SELECT value FROM %s WHERE hash = $1`},
+		{&ss.hasStmt, `SELECT COUNT(*) FROM %s WHERE hash = $1`},
+		{&ss.putStmt, `INSERT INTO %[1]s (hash, value, refcount) VALUES ($1, $2, 1)
+			ON CONFLICT (hash) DO UPDATE SET value = excluded.value, refcount = %[1]s.refcount + 1`},
+		{&ss.decrStmt, `UPDATE %s SET refcount = refcount - 1 WHERE hash = $1`},
+		{&ss.deleteStmt, `DELETE FROM %s WHERE hash = $1 AND refcount <= 0`},
+		{&ss.keysStmt, `SELECT hash FROM %s`},
+	}
+	for _, q := range queries {
+		stmt, err := db.Prepare(fmt.Sprintf(q.query, table))
+		if err != nil {
+			ss.Close()
+			return nil, err
+		}
+		*q.stmt = stmt
+	}
+
+	return &ss, nil
+}
+
+// stmt returns the prepared statement bound to the current transaction, if any.
+// ss.mu must be held.
+func (ss *Store) stmt(stmt *sql.Stmt) *sql.Stmt {
+	if ss.tx != nil {
+		return ss.txStmts[stmt]
+	}
+	return stmt
+}
+
+// Get gets the value for a key.
+func (ss *Store) Get(key []byte) ([]byte, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	var value []byte
+	err := ss.stmt(ss.getStmt).QueryRow(key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &smt.InvalidKeyError{Key: key}
+	}
+	return value, err
+}
+
+// Put updates the value for a key.
+func (ss *Store) Put(key []byte, value []byte) error {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	_, err := ss.stmt(ss.putStmt).Exec(key, value)
+	return err
+}
+
+// Has returns true if the key exists in the store.
+func (ss *Store) Has(key []byte) (bool, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	var count int
+	if err := ss.stmt(ss.hasStmt).QueryRow(key).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Delete deletes a key.
+func (ss *Store) Delete(key []byte) error {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	if ss.tx != nil {
+		return ss.delete(ss.stmt(ss.decrStmt), ss.stmt(ss.deleteStmt), key)
+	}
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := ss.delete(tx.Stmt(ss.decrStmt), tx.Stmt(ss.deleteStmt), key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// delete decrements the reference count of a key with decrStmt, and deletes it
+// with deleteStmt once it drops to zero.
+func (ss *Store) delete(decrStmt, deleteStmt *sql.Stmt, key []byte) error {
+	res, err := decrStmt.Exec(key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return &smt.InvalidKeyError{Key: key}
+	}
+	_, err = deleteStmt.Exec(key)
+	return err
+}
+
+// ForEachKey calls fn for every key in the store, including the keys written
+// by the transaction in progress, if any, stopping at the first error returned
+// by fn.
+func (ss *Store) ForEachKey(fn func(key []byte) error) error {
+	keys, err := ss.keys()
+	if err != nil {
+		return err
+	}
+	// fn is called once the rows are closed, so that it can read the store.
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *Store) keys() ([][]byte, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	rows, err := ss.stmt(ss.keysStmt).Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys [][]byte
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Begin starts a transaction that all following calls join until Commit or
+// Discard. The prepared statements are bound to the transaction once, and
+// released with it.
+func (ss *Store) Begin() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.tx != nil {
+		return smt.ErrTransactionInProgress
+	}
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	ss.tx = tx
+	ss.txStmts = make(map[*sql.Stmt]*sql.Stmt)
+	for _, stmt := range ss.stmts() {
+		ss.txStmts[stmt] = tx.Stmt(stmt)
+	}
+	return nil
+}
+
+// Commit commits the transaction started by Begin.
+func (ss *Store) Commit() error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.tx == nil {
+		return smt.ErrNoTransaction
+	}
+	err := ss.tx.Commit()
+	ss.tx, ss.txStmts = nil, nil
+	return err
+}
+
+// Discard rolls back the transaction started by Begin, if any.
+func (ss *Store) Discard() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.tx != nil {
+		ss.tx.Rollback()
+		ss.tx, ss.txStmts = nil, nil
+	}
+}
+
+// stmts returns the prepared statements of the store.
+func (ss *Store) stmts() []*sql.Stmt {
+	return []*sql.Stmt{ss.getStmt, ss.hasStmt, ss.putStmt, ss.decrStmt, ss.deleteStmt, ss.keysStmt}
+}
+
+// Close discards any pending transaction and releases the prepared
+// statements. The database handle is owned by the caller and stays open.
+func (ss *Store) Close() error {
+	ss.Discard()
+	for _, stmt := range ss.stmts() {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return nil
+}
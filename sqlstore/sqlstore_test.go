@@ -0,0 +1,192 @@
+package sqlstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/memoio/smt"
+	_ "modernc.org/sqlite"
+)
+
+func newTestSQLMapStore(t *testing.T, db *sql.DB, table string) *Store {
+	ss, err := New(db, table)
+	if err != nil {
+		t.Fatalf("failed to create SQL store: %v", err)
+	}
+	t.Cleanup(func() { ss.Close() })
+	return ss
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "tree.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLMapStore(t *testing.T) {
+	ss := newTestSQLMapStore(t, openTestDB(t), "nodes")
+
+	// Tests for Get.
+	_, err := ss.Get([]byte("key"))
+	var invalidKeyError *smt.InvalidKeyError
+	if !errors.As(err, &invalidKeyError) {
+		t.Errorf("did not return an smt.InvalidKeyError when getting a non-existent key: %v", err)
+	}
+
+	// Tests for Put.
+	if err := ss.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	value, err := ss.Get([]byte("key"))
+	if err != nil {
+		t.Errorf("getting a key returned an error: %v", err)
+	}
+	if !bytes.Equal(value, []byte("hello")) {
+		t.Error("failed to update key")
+	}
+	has, err := ss.Has([]byte("key"))
+	if err != nil || !has {
+		t.Error("did not find an existing key")
+	}
+
+	// Tests for Delete with reference counting.
+	if err := ss.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if err := ss.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if _, err := ss.Get([]byte("key")); err != nil {
+		t.Error("key was deleted while still referenced")
+	}
+	if err := ss.Delete([]byte("key")); err != nil {
+		t.Errorf("deleting a key returned an error: %v", err)
+	}
+	if has, _ := ss.Has([]byte("key")); has {
+		t.Error("failed to delete key")
+	}
+	if err := ss.Delete([]byte("nonexistent")); !errors.As(err, &invalidKeyError) {
+		t.Error("deleting a key did not return an error on a non-existent key")
+	}
+}
+
+func TestSQLMapStoreTransaction(t *testing.T) {
+	ss := newTestSQLMapStore(t, openTestDB(t), "nodes")
+
+	// A discarded transaction leaves the table untouched.
+	if err := ss.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	if err := ss.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Errorf("updating a key returned an error: %v", err)
+	}
+	if has, _ := ss.Has([]byte("key")); !has {
+		t.Error("transaction did not see its own write")
+	}
+	ss.Discard()
+	if has, _ := ss.Has([]byte("key")); has {
+		t.Error("discarded write is visible")
+	}
+
+	// A committed transaction applies all of its writes.
+	tree := smt.NewSparseMerkleTree(ss, ss, sha256.New())
+	if err := ss.Begin(); err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	if _, err := tree.Update([]byte("testKey"), []byte("testValue")); err != nil {
+		t.Errorf("returned error when updating key: %v", err)
+	}
+	if err := ss.Commit(); err != nil {
+		t.Errorf("failed to commit transaction: %v", err)
+	}
+	if err := ss.Commit(); err == nil {
+		t.Error("did not return an error when committing without a transaction")
+	}
+	value, err := tree.Get([]byte("testKey"))
+	if err != nil {
+		t.Errorf("returned error when getting key: %v", err)
+	}
+	if !bytes.Equal(value, []byte("testValue")) {
+		t.Error("did not get correct value after commit")
+	}
+}
+
+// Test that calls made while transactions begin and end use the store or the
+// transaction consistently. Run with -race.
+func TestSQLMapStoreConcurrentTransactions(t *testing.T) {
+	ss := newTestSQLMapStore(t, openTestDB(t), "nodes")
+	if err := ss.Put([]byte("key"), []byte("hello")); err != nil {
+		t.Fatalf("updating a key returned an error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if value, err := ss.Get([]byte("key")); err != nil || !bytes.Equal(value, []byte("hello")) {
+					t.Errorf("did not get the value during transactions: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := ss.Begin(); err != nil {
+			t.Fatalf("failed to begin transaction: %v", err)
+		}
+		key := []byte("key" + strconv.Itoa(i))
+		if err := ss.Put(key, []byte("hello")); err != nil {
+			t.Errorf("updating a key returned an error: %v", err)
+		}
+		if err := ss.Delete(key); err != nil {
+			t.Errorf("deleting a key returned an error: %v", err)
+		}
+		if err := ss.Commit(); err != nil {
+			t.Errorf("failed to commit transaction: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestSQLMapStoreGarbageCollect(t *testing.T) {
+	ss := newTestSQLMapStore(t, openTestDB(t), "nodes")
+	tree := smt.NewSparseMerkleTree(ss, ss, sha256.New())
+	for _, key := range []string{"testKey", "testKey2", "testKey3"} {
+		tree.Update([]byte(key), []byte(key+"Value"))
+	}
+	tree.Update([]byte("testKey"), []byte("newValue"))
+
+	countKeys := func() int {
+		t.Helper()
+		count := 0
+		if err := ss.ForEachKey(func(key []byte) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatalf("returned error when iterating keys: %v", err)
+		}
+		return count
+	}
+	count := countKeys()
+	freed, err := tree.GarbageCollect([][]byte{tree.Root()})
+	if err != nil {
+		t.Fatalf("returned error when collecting garbage: %v", err)
+	}
+	if freed == 0 || countKeys() != count-freed {
+		t.Errorf("freed %d of %d keys, %d left", freed, count, countKeys())
+	}
+	if value, err := tree.Get([]byte("testKey")); err != nil || !bytes.Equal(value, []byte("newValue")) {
+		t.Error("did not keep the current values")
+	}
+}
@@ -0,0 +1,69 @@
+package smt
+
+// TreeStats describes the size and shape of a tree.
+type TreeStats struct {
+	// NodeCount is the number of nodes in the node store, including those of
+	// older roots, if the store is a SimpleMap or a Sizer. Otherwise it is the
+	// number of nodes of the current root, and NodeCountWalked is set.
+	NodeCount       int64
+	NodeCountWalked bool
+
+	// LeafCount is the number of leaves of the current root, that is the
+	// number of keys set in the tree.
+	LeafCount int64
+
+	// Depth is the height of the deepest leaf of the current root, which is 0
+	// for a tree holding at most one leaf.
+	Depth int
+
+	// HashSize is the size in bytes of the hashes of the tree.
+	HashSize int
+}
+
+// Stats walks the current root of the tree and returns its TreeStats.
+func (smt *SparseMerkleTree) Stats() (TreeStats, error) {
+	stats := TreeStats{HashSize: smt.th.pathSize()}
+	var walked int64
+	err := smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		walked++
+		if smt.th.isLeaf(data) {
+			stats.LeafCount++
+			if len(sideNodes) > stats.Depth {
+				stats.Depth = len(sideNodes)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return TreeStats{}, err
+	}
+
+	switch store := unwrapStore(smt.nodes).(type) {
+	case Sizer:
+		if stats.NodeCount, err = store.Size(); err != nil {
+			return TreeStats{}, err
+		}
+	case *SimpleMap:
+		stats.NodeCount = store.Size()
+	default:
+		stats.NodeCount, stats.NodeCountWalked = walked, true
+	}
+	return stats, nil
+}
+
+// CountLeaves returns the number of leaves of the current root, that is the
+// number of keys set in the tree, like the LeafCount of Stats. It only walks
+// the nodes of the tree, skipping empty subtrees, and never reads the values.
+func (smt *SparseMerkleTree) CountLeaves() (int, error) {
+	count := 0
+	err := smt.walk(smt.Root(), func(hash []byte, data []byte, inlineValue []byte, sideNodes [][]byte) error {
+		if smt.th.isLeaf(data) {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
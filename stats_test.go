@@ -0,0 +1,114 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"strconv"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	smn, smv := NewSimpleMap(), NewSimpleMap()
+	smt := NewSparseMerkleTree(smn, smv, sha256.New())
+	stats, err := smt.Stats()
+	if err != nil {
+		t.Fatalf("returned error when getting stats: %v", err)
+	}
+	if stats != (TreeStats{HashSize: sha256.Size}) {
+		t.Errorf("got stats %+v for an empty tree", stats)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i % 60))
+		smt.Update(key, []byte(strconv.Itoa(i)))
+	}
+	smt.Delete([]byte("0"))
+
+	stats, err = smt.Stats()
+	if err != nil {
+		t.Fatalf("returned error when getting stats: %v", err)
+	}
+	if stats.LeafCount != 59 {
+		t.Errorf("got %d leaves, expected 59", stats.LeafCount)
+	}
+	if stats.NodeCount != smn.Size() || stats.NodeCountWalked {
+		t.Error("did not take the node count from the store")
+	}
+	if stats.Depth < 6 || stats.Depth > smt.depth() {
+		t.Errorf("got unexpected depth %d", stats.Depth)
+	}
+
+	// Stores without a size are walked, which only counts the current nodes.
+	walked := ImportSparseMerkleTree(failingStore{smn}, smv, sha256.New(), smt.Root())
+	walkedStats, err := walked.Stats()
+	if err != nil {
+		t.Fatalf("returned error when getting stats: %v", err)
+	}
+	if !walkedStats.NodeCountWalked {
+		t.Error("did not walk the node count")
+	}
+	if walkedStats.NodeCount < 2*59-1 || walkedStats.NodeCount >= smn.Size() {
+		t.Errorf("got unexpected walked node count %d", walkedStats.NodeCount)
+	}
+	if walkedStats.LeafCount != stats.LeafCount || walkedStats.Depth != stats.Depth {
+		t.Error("walked stats differ")
+	}
+
+	// Sizers report the size of the store.
+	bs := sizedStore{NewSimpleMap()}
+	sized := NewSparseMerkleTree(bs, smv, sha256.New())
+	for i := 0; i < 10; i++ {
+		sized.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+	sizedStats, err := sized.Stats()
+	if err != nil {
+		t.Fatalf("returned error when getting stats: %v", err)
+	}
+	size, _ := bs.Size()
+	if sizedStats.NodeCount != size || sizedStats.NodeCountWalked {
+		t.Error("did not take the node count from the Sizer")
+	}
+}
+
+// sizedStore is a SimpleMap implementing Sizer. It reports one key more than
+// it has, to tell its size from a walked count.
+type sizedStore struct {
+	*SimpleMap
+}
+
+func (ss sizedStore) Size() (int64, error) {
+	return ss.SimpleMap.Size() + 1, nil
+}
+
+func TestCountLeaves(t *testing.T) {
+	values := &countingStore{MapStore: NewSimpleMap()}
+	smt := NewSparseMerkleTree(NewSimpleMap(), values, sha256.New())
+	if count, err := smt.CountLeaves(); err != nil || count != 0 {
+		t.Errorf("got %d, %v leaves in empty tree", count, err)
+	}
+
+	keys := map[string]struct{}{}
+	for i := 0; i < 200; i++ {
+		key := strconv.Itoa(i % 70)
+		if i%3 == 0 {
+			smt.Delete([]byte(key))
+			delete(keys, key)
+		} else {
+			smt.Update([]byte(key), []byte("testValue"+strconv.Itoa(i)))
+			keys[key] = struct{}{}
+		}
+	}
+	// Deleting keys without a value changes nothing.
+	smt.Delete([]byte("missing"))
+
+	values.accesses.Store(0)
+	count, err := smt.CountLeaves()
+	if err != nil || count != len(keys) {
+		t.Errorf("got %d, %v leaves, expected %d", count, err, len(keys))
+	}
+	if values.accesses.Load() != 0 {
+		t.Error("read values when counting leaves")
+	}
+	if stats, _ := smt.Stats(); stats.LeafCount != int64(count) {
+		t.Errorf("counted %d leaves, but stats have %d", count, stats.LeafCount)
+	}
+}
@@ -0,0 +1,188 @@
+package smt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash"
+)
+
+// SubtreeProof is a Merkle proof that a subtree root is committed under the
+// root of a tree, for the subtree of the nodes whose paths start with a given
+// prefix.
+type SubtreeProof struct {
+	// SideNodes are the sibling nodes on the path of the prefix, from the
+	// subtree up to the root.
+	SideNodes [][]byte
+
+	// LeafData is the data of the leaf ending the path of the prefix, if the
+	// path ends at a leaf above the subtree. The subtree is then this leaf or
+	// empty, depending on whether its path starts with the prefix. Otherwise,
+	// it is nil.
+	LeafData []byte
+}
+
+// SubtreeRoot returns the root of the subtree of the nodes whose paths start
+// with the first bits bits of pathPrefix. Like the tree, a subtree holding a
+// single leaf has the leaf as its root, and an empty subtree the placeholder.
+// With zero bits, it is the root of the tree.
+func (smt *SparseMerkleTree) SubtreeRoot(pathPrefix []byte, bits int) ([]byte, error) {
+	subtreeRoot, _, err := smt.subtree(pathPrefix, bits)
+	return subtreeRoot, err
+}
+
+// ProveSubtreeInclusion generates a Merkle proof that the root of the subtree
+// of the nodes whose paths start with the first bits bits of pathPrefix, as
+// returned by SubtreeRoot, is committed under the current root.
+func (smt *SparseMerkleTree) ProveSubtreeInclusion(pathPrefix []byte, bits int) (SubtreeProof, error) {
+	_, proof, err := smt.subtree(pathPrefix, bits)
+	return proof, err
+}
+
+// ProveInSubtree generates a Merkle proof for a key against the root of the
+// subtree holding it at a depth of bits, as returned by SubtreeRoot for the
+// path of the key. It is the proof of Prove without the side nodes above the
+// subtree.
+func (smt *SparseMerkleTree) ProveInSubtree(key []byte, bits int) (SparseMerkleProof, error) {
+	if err := checkSubtree(smt.Path(key), bits, &smt.th); err != nil {
+		return SparseMerkleProof{}, err
+	}
+	proof, err := smt.Prove(key)
+	if err != nil {
+		return SparseMerkleProof{}, err
+	}
+	n := len(proof.SideNodes)
+	if n > bits {
+		proof.SideNodes = proof.SideNodes[:n-bits]
+	} else {
+		proof.SideNodes = nil
+	}
+	if n < bits && proof.NonMembershipLeafData != nil {
+		// The unrelated leaf is only in the subtree if it shares its prefix.
+		leafPath, _, _ := smt.th.parseLeaf(proof.NonMembershipLeafData)
+		if !hasPathPrefix(leafPath, smt.Path(key), bits) {
+			proof.NonMembershipLeafData = nil
+		}
+	}
+	return proof, nil
+}
+
+// subtree returns the root of the subtree at the first bits bits of
+// pathPrefix, and the proof of its inclusion under the current root.
+func (smt *SparseMerkleTree) subtree(pathPrefix []byte, bits int) ([]byte, SubtreeProof, error) {
+	if err := checkSubtree(pathPrefix, bits, &smt.th); err != nil {
+		return nil, SubtreeProof{}, err
+	}
+	path := make([]byte, smt.th.pathSize())
+	copy(path, pathPrefix[:(bits+7)/8])
+	root := smt.Root()
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+	defer smt.reads.read(root)()
+
+	sideNodes, pathNodes, leafData, _, err := smt.sideNodesForRoot(context.Background(), path, root, false)
+	if err != nil {
+		return nil, SubtreeProof{}, err
+	}
+	// The path of the prefix ends at a depth of n, at a leaf or placeholder.
+	n := len(sideNodes)
+	if n >= bits {
+		return pathNodes[n-bits], SubtreeProof{SideNodes: sideNodes[n-bits:]}, nil
+	}
+	proof := SubtreeProof{SideNodes: sideNodes, LeafData: leafData}
+	if leafData == nil {
+		return smt.th.placeholder(), proof, nil
+	}
+	leafPath, _, _ := smt.th.parseLeaf(leafData)
+	if !hasPathPrefix(leafPath, path, bits) {
+		return smt.th.placeholder(), proof, nil
+	}
+	return pathNodes[0], proof, nil
+}
+
+// VerifySubtreeInclusion verifies a Merkle proof that subtreeRoot is the root
+// of the subtree of the nodes whose paths start with the first bits bits of
+// pathPrefix, in the tree at root. The options must match the ones the tree
+// was built with.
+func VerifySubtreeInclusion(proof SubtreeProof, root []byte, subtreeRoot []byte, pathPrefix []byte, bits int, hasher hash.Hash, options ...Option) bool {
+	th := treeHasherWithOptions(hasher, options)
+	if checkSubtree(pathPrefix, bits, th) != nil {
+		return false
+	}
+	n := len(proof.SideNodes)
+	if n > bits || (n == bits && proof.LeafData != nil) {
+		return false
+	}
+	for _, sideNode := range proof.SideNodes {
+		if len(sideNode) != th.hasher.Size() {
+			return false
+		}
+	}
+
+	currentHash := subtreeRoot
+	if n < bits {
+		// The path ends above the subtree, so the subtree is either the leaf
+		// there or empty.
+		if proof.LeafData == nil {
+			if !bytes.Equal(subtreeRoot, th.placeholder()) {
+				return false
+			}
+		} else {
+			if len(proof.LeafData) != len(leafPrefix)+th.pathSize()+th.valueSize() || !th.isLeaf(proof.LeafData) {
+				return false
+			}
+			leafPath, valueHash, _ := th.parseLeaf(proof.LeafData)
+			currentHash, _ = th.digestLeaf(leafPath, valueHash)
+			expected := th.placeholder()
+			if hasPathPrefix(leafPath, pathPrefix, bits) {
+				expected = currentHash
+			}
+			if !bytes.Equal(subtreeRoot, expected) {
+				return false
+			}
+		}
+	}
+
+	for i, sideNode := range proof.SideNodes {
+		if getBitAtFromMSB(pathPrefix, n-1-i) == right {
+			currentHash, _ = th.digestNode(sideNode, currentHash)
+		} else {
+			currentHash, _ = th.digestNode(currentHash, sideNode)
+		}
+	}
+	return bytes.Equal(currentHash, root)
+}
+
+// VerifyProofInSubtree verifies a Merkle proof for a key against the root of
+// the subtree holding it at a depth of bits, as generated by ProveInSubtree.
+// It does not check that the subtree is the one of the key: the caller must
+// check that the path of the key starts with the prefix of the subtree. The
+// options must match the ones the tree was built with.
+func VerifyProofInSubtree(proof SparseMerkleProof, subtreeRoot []byte, bits int, key []byte, value []byte, hasher hash.Hash, options ...Option) bool {
+	th := treeHasherWithOptions(hasher, options)
+	if bits < 0 || bits > th.pathSize()*8 {
+		return false
+	}
+	computedRoot, _, ok := rootFromProofAt(proof, key, value, bits, th)
+	return ok && bytes.Equal(computedRoot, subtreeRoot)
+}
+
+// checkSubtree returns ErrInvalidPath unless bits is a depth of the tree and
+// pathPrefix holds at least bits bits.
+func checkSubtree(pathPrefix []byte, bits int, th *treeHasher) error {
+	if bits < 0 || bits > th.pathSize()*8 || len(pathPrefix)*8 < bits {
+		return fmt.Errorf("%w: prefix of %d bits out of %d", ErrInvalidPath, bits, len(pathPrefix)*8)
+	}
+	return nil
+}
+
+// hasPathPrefix returns whether the first bits bits of path and prefix are
+// the same.
+func hasPathPrefix(path []byte, prefix []byte, bits int) bool {
+	for i := 0; i < bits; i++ {
+		if getBitAtFromMSB(path, i) != getBitAtFromMSB(prefix, i) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSubtreeRoot(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for _, bits := range []int{0, 3, 256} {
+		subtreeRoot, err := smt.SubtreeRoot(smt.Path([]byte("testKey")), bits)
+		if err != nil || !bytes.Equal(subtreeRoot, smt.th.placeholder()) {
+			t.Errorf("did not get placeholder for subtree of %d bits of empty tree: %v", bits, err)
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	root := smt.Root()
+	for _, bits := range []int{0, 1, 2, 4, 6, 8, 12, 256} {
+		for i := 0; i < 60; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			value := defaultValue
+			if i < 50 {
+				value = []byte(fmt.Sprintf("testValue%d", i))
+			}
+			prefix := smt.Path(key)
+
+			subtreeRoot, err := smt.SubtreeRoot(prefix, bits)
+			if err != nil {
+				t.Fatalf("returned error when getting subtree root: %v", err)
+			}
+			if bits == 0 && !bytes.Equal(subtreeRoot, root) {
+				t.Error("subtree of no bits is not the tree")
+			}
+			proof, err := smt.ProveSubtreeInclusion(prefix, bits)
+			if err != nil {
+				t.Fatalf("returned error when proving subtree: %v", err)
+			}
+			if !VerifySubtreeInclusion(proof, root, subtreeRoot, prefix, bits, sha256.New()) {
+				t.Errorf("could not verify subtree of %d bits of key %d", bits, i)
+			}
+			if VerifySubtreeInclusion(proof, root, smt.th.digest(subtreeRoot), prefix, bits, sha256.New()) {
+				t.Errorf("verified wrong subtree of %d bits of key %d", bits, i)
+			}
+			// The key verifies against the subtree root.
+			keyProof, err := smt.ProveInSubtree(key, bits)
+			if err != nil {
+				t.Fatalf("returned error when proving key in subtree: %v", err)
+			}
+			if !VerifyProofInSubtree(keyProof, subtreeRoot, bits, key, value, sha256.New()) {
+				t.Errorf("could not verify key %d in subtree of %d bits", i, bits)
+			}
+			if VerifyProofInSubtree(keyProof, subtreeRoot, bits, key, []byte("badValue"), sha256.New()) {
+				t.Errorf("verified wrong value of key %d in subtree of %d bits", i, bits)
+			}
+		}
+	}
+
+	if _, err := smt.SubtreeRoot(smt.Path([]byte("testKey")), 257); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("did not return ErrInvalidPath for a subtree too deep: %v", err)
+	}
+	if _, err := smt.ProveSubtreeInclusion([]byte{0xff}, 9); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("did not return ErrInvalidPath for a prefix too short: %v", err)
+	}
+	if _, err := smt.ProveInSubtree([]byte("testKey"), -1); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("did not return ErrInvalidPath for a negative depth: %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+package smt
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Tracer starts spans for the operations of a tree, such as those of a
+// distributed tracing system. It is set with WithTracer.
+type Tracer interface {
+	// StartSpan starts a span for the operation with the given name.
+	StartSpan(name string) Span
+}
+
+// Span is a traced operation of a tree, started by a Tracer.
+type Span interface {
+	// SetAttribute tags the span with an attribute.
+	SetAttribute(key string, value int)
+	// End ends the span.
+	End()
+}
+
+// Attributes that spans are tagged with.
+const (
+	// SpanAttrKeySize is the size of the key of the operation, when it is
+	// given a key.
+	SpanAttrKeySize = "smt.key_size"
+	// SpanAttrKeys is the number of keys of a batch operation.
+	SpanAttrKeys = "smt.keys"
+	// SpanAttrNodeAccesses is the number of node store accesses made by the
+	// operation, including the ones served by the cache of WithNodeCache.
+	SpanAttrNodeAccesses = "smt.node_accesses"
+)
+
+// WithTracer makes the tree trace its Update, Get, Prove and batch operations
+// with tracer. Spans are named after the operation, e.g. "smt.Update", and
+// tagged with the Span* attributes.
+//
+// Node accesses are counted in front of the node store as the tree sees it,
+// whatever the order of the options, so accesses served by the cache of
+// WithNodeCache are counted too.
+func WithTracer(tracer Tracer) Option {
+	return func(smt *SparseMerkleTree) {
+		smt.tracer = tracer
+	}
+}
+
+// traceNodes wraps the node store of a tree with a tracer to count the node
+// accesses of its updates. It is called once the options are applied, so that
+// the wrapper is in front of any other.
+func (smt *SparseMerkleTree) traceNodes() {
+	if smt.tracer == nil {
+		return
+	}
+	smt.tracedNodes = &countingStore{MapStore: smt.nodes}
+	smt.nodes = smt.tracedNodes
+}
+
+// countingStore is a MapStore counting the accesses made to it.
+type countingStore struct {
+	MapStore
+	accesses atomic.Int64
+	// update, if set, also counts the accesses of the update in progress.
+	update atomic.Pointer[atomic.Int64]
+}
+
+func (cs *countingStore) count() {
+	cs.accesses.Add(1)
+	if update := cs.update.Load(); update != nil {
+		update.Add(1)
+	}
+}
+
+func (cs *countingStore) Put(key []byte, value []byte) error {
+	cs.count()
+	return cs.MapStore.Put(key, value)
+}
+
+func (cs *countingStore) Get(key []byte) ([]byte, error) {
+	cs.count()
+	return cs.MapStore.Get(key)
+}
+
+func (cs *countingStore) Has(key []byte) (bool, error) {
+	cs.count()
+	return cs.MapStore.Has(key)
+}
+
+func (cs *countingStore) Delete(key []byte) error {
+	cs.count()
+	return cs.MapStore.Delete(key)
+}
+
+func (cs *countingStore) Unwrap() MapStore {
+	return cs.MapStore
+}
+
+// traceSpan is the span of an operation of a tree. The zero value is the span
+// of an untraced operation, and does nothing.
+type traceSpan struct {
+	span     Span
+	accesses *atomic.Int64 // Node accesses of the operation.
+}
+
+// startSpan starts a span for an operation of the tree if it has a tracer,
+// tagging it with the size of the key of the operation unless it is negative.
+//
+// Reads run concurrently, so their node accesses are counted through the
+// context returned by the context method of the span. Updates run exclusively,
+// so theirs are counted by the node store between countUpdate and the call of
+// the function it returns.
+func (smt *SparseMerkleTree) startSpan(name string, keySize int) traceSpan {
+	if smt.tracer == nil {
+		return traceSpan{}
+	}
+	span := smt.tracer.StartSpan(name)
+	if keySize >= 0 {
+		span.SetAttribute(SpanAttrKeySize, keySize)
+	}
+	return traceSpan{span: span, accesses: new(atomic.Int64)}
+}
+
+func (s traceSpan) setAttribute(key string, value int) {
+	if s.span != nil {
+		s.span.SetAttribute(key, value)
+	}
+}
+
+// end tags the span with the number of node accesses of the operation, and
+// ends it.
+func (s traceSpan) end() {
+	if s.span == nil {
+		return
+	}
+	s.span.SetAttribute(SpanAttrNodeAccesses, int(s.accesses.Load()))
+	s.span.End()
+}
+
+// nodeAccessesKey is the context key of the node access counter of a read.
+type nodeAccessesKey struct{}
+
+// context returns ctx carrying the node access counter of the span, for
+// countNodeAccess to count the accesses of a read.
+func (s traceSpan) context(ctx context.Context) context.Context {
+	if s.span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, nodeAccessesKey{}, s.accesses)
+}
+
+// countNodeAccess counts an access to the node store by the read of ctx, if it
+// is traced.
+func countNodeAccess(ctx context.Context) {
+	if accesses, ok := ctx.Value(nodeAccessesKey{}).(*atomic.Int64); ok {
+		accesses.Add(1)
+	}
+}
+
+// countUpdate makes the node store count its accesses toward the span of an
+// update until the returned function is called. The tree must be write-locked,
+// so that every access in the meantime is made by the update.
+func (smt *SparseMerkleTree) countUpdate(s traceSpan) func() {
+	if s.span == nil {
+		return func() {}
+	}
+	smt.tracedNodes.update.Store(s.accesses)
+	return func() {
+		smt.tracedNodes.update.Store(nil)
+	}
+}
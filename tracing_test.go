@@ -0,0 +1,155 @@
+package smt
+
+import (
+	"crypto/sha256"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	name       string
+	attributes map[string]int
+	ended      bool
+}
+
+func (s *recordedSpan) SetAttribute(key string, value int) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+// recordingTracer is a Tracer recording the spans it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (rt *recordingTracer) StartSpan(name string) Span {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	span := &recordedSpan{name: name, attributes: make(map[string]int)}
+	rt.spans = append(rt.spans, span)
+	return span
+}
+
+func TestWithTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), WithTracer(tracer))
+
+	smt.Update([]byte("testKey1"), []byte("testValue1"))
+	smt.Update([]byte("testKey22"), []byte("testValue2"))
+	smt.Get([]byte("testKey1"))
+	smt.Prove([]byte("testKey1"))
+	smt.RemovePathsForRoot([][]byte{[]byte("testKey1"), []byte("testKey22")}, smt.Root())
+
+	expected := []struct {
+		name    string
+		keySize int
+	}{
+		{"smt.Update", 8},
+		{"smt.Update", 9},
+		{"smt.Get", 8},
+		{"smt.Prove", 8},
+		{"smt.RemovePaths", -1},
+	}
+	if len(tracer.spans) != len(expected) {
+		t.Fatalf("got %d spans, expected %d", len(tracer.spans), len(expected))
+	}
+	for i, span := range tracer.spans {
+		if span.name != expected[i].name {
+			t.Errorf("got span %q, expected %q", span.name, expected[i].name)
+		}
+		if !span.ended {
+			t.Errorf("span %q was not ended", span.name)
+		}
+		if keySize, ok := span.attributes[SpanAttrKeySize]; expected[i].keySize >= 0 && keySize != expected[i].keySize {
+			t.Errorf("span %q has key size %d, expected %d", span.name, keySize, expected[i].keySize)
+		} else if expected[i].keySize < 0 && ok {
+			t.Errorf("span %q has a key size", span.name)
+		}
+		if span.attributes[SpanAttrNodeAccesses] == 0 {
+			t.Errorf("span %q has no node accesses", span.name)
+		}
+	}
+	if keys := tracer.spans[4].attributes[SpanAttrKeys]; keys != 2 {
+		t.Errorf("batch span has %d keys, expected 2", keys)
+	}
+
+	// Inserting into an empty tree only writes the leaf.
+	if accesses := tracer.spans[0].attributes[SpanAttrNodeAccesses]; accesses != 1 {
+		t.Errorf("first update made %d node accesses, expected 1", accesses)
+	}
+}
+
+// Test that concurrent reads only count their own node accesses.
+func TestTracerConcurrentReads(t *testing.T) {
+	tracer := &recordingTracer{}
+	smt := NewSparseMerkleTree(yieldingStore{NewSimpleMap()}, NewSimpleMap(), sha256.New(), WithTracer(tracer))
+	for i := 0; i < 100; i++ {
+		smt.Update([]byte(strconv.Itoa(i)), []byte("testValue"))
+	}
+	smt.Get([]byte("1"))
+	smt.Prove([]byte("1"))
+	spans := tracer.spans[len(tracer.spans)-2:]
+	getAccesses, proveAccesses := spans[0].attributes[SpanAttrNodeAccesses], spans[1].attributes[SpanAttrNodeAccesses]
+	tracer.spans = nil
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				smt.Get([]byte("1"))
+				smt.Prove([]byte("1"))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, span := range tracer.spans {
+		expected := getAccesses
+		if span.name == "smt.Prove" {
+			expected = proveAccesses
+		}
+		if accesses := span.attributes[SpanAttrNodeAccesses]; accesses != expected {
+			t.Fatalf("span %q has %d node accesses, expected %d", span.name, accesses, expected)
+		}
+	}
+}
+
+// yieldingStore is a MapStore yielding the processor on every read, so that
+// concurrent reads interleave.
+type yieldingStore struct {
+	MapStore
+}
+
+func (ys yieldingStore) Get(key []byte) ([]byte, error) {
+	runtime.Gosched()
+	return ys.MapStore.Get(key)
+}
+
+// Test that node accesses are counted the same whatever the order of the
+// options.
+func TestTracerOptionOrder(t *testing.T) {
+	var accesses [2]int
+	for i, options := range [][]Option{
+		{WithNodeCache(100), WithTracer(&recordingTracer{})},
+		{WithTracer(&recordingTracer{}), WithNodeCache(100)},
+	} {
+		smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New(), options...)
+		for j := 0; j < 10; j++ {
+			smt.Update([]byte(strconv.Itoa(j)), []byte("testValue"))
+		}
+		smt.Get([]byte("1"))
+		tracer := smt.tracer.(*recordingTracer)
+		accesses[i] = tracer.spans[len(tracer.spans)-1].attributes[SpanAttrNodeAccesses]
+	}
+	if accesses[0] == 0 || accesses[0] != accesses[1] {
+		t.Errorf("got %d and %d node accesses depending on the order of the options", accesses[0], accesses[1])
+	}
+}
@@ -2,35 +2,119 @@ package smt
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"hash"
+	"sync"
 )
 
 var leafPrefix = []byte{0}
 var nodePrefix = []byte{1}
 
+// ErrValueTooLarge is returned when a value exceeds the size set with
+// WithMaxValueSize.
+var ErrValueTooLarge = errors.New("value too large")
+
+// ErrMalformedNode is returned when the data of a node read from the store is
+// neither a valid leaf nor a valid inner node, e.g. because it was truncated.
+var ErrMalformedNode = errors.New("malformed node")
+
 type treeHasher struct {
-	hasher    hash.Hash
-	zeroValue []byte
+	hasher      hash.Hash
+	newHasher   func() hash.Hash // Builds fresh hashers for digests, if not nil.
+	pathHasher  hash.Hash        // Derives leaf paths from keys; defaults to hasher.
+	valueHasher hash.Hash        // Digests leaf values; defaults to hasher.
+	zeroValue   []byte
+
+	// mu serializes digests with shared hashers, as they are not safe for
+	// concurrent use. It is shared by copies of the tree hasher.
+	mu *sync.Mutex
+
+	maxValueSize int    // Maximum size of values, if positive.
+	defaultValue []byte // Value of keys that are not set.
 }
 
 func newTreeHasher(hasher hash.Hash) *treeHasher {
-	th := treeHasher{hasher: hasher}
+	th := treeHasher{hasher: hasher, mu: new(sync.Mutex), defaultValue: defaultValue}
 	th.zeroValue = make([]byte, th.pathSize())
 
 	return &th
 }
 
-func (th *treeHasher) digest(data []byte) []byte {
-	th.hasher.Write(data)
-	sum := th.hasher.Sum(nil)
-	th.hasher.Reset()
+// newTreeHasherFunc returns a tree hasher digesting with a fresh hasher from
+// newHasher every time, so that digests need no locking.
+func newTreeHasherFunc(newHasher func() hash.Hash) *treeHasher {
+	th := newTreeHasher(newHasher())
+	th.newHasher = newHasher
+	return th
+}
+
+// sum digests data with a shared hasher.
+func (th *treeHasher) sum(hasher hash.Hash, data []byte) []byte {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	hasher.Write(data)
+	sum := hasher.Sum(nil)
+	hasher.Reset()
 	return sum
 }
 
+func (th *treeHasher) digest(data []byte) []byte {
+	if th.newHasher != nil {
+		hasher := th.newHasher()
+		hasher.Write(data)
+		return hasher.Sum(nil)
+	}
+	return th.sum(th.hasher, data)
+}
+
 func (th *treeHasher) path(key []byte) []byte {
+	if th.pathHasher != nil {
+		return th.sum(th.pathHasher, key)
+	}
 	return th.digest(key)
 }
 
+// valueDigester returns the hasher used to digest leaf values.
+func (th *treeHasher) valueDigester() hash.Hash {
+	if th.valueHasher != nil {
+		return th.valueHasher
+	}
+	return th.hasher
+}
+
+// checkValueSize checks that value does not exceed the maximum value size.
+func (th *treeHasher) checkValueSize(value []byte) error {
+	if th.maxValueSize > 0 && len(value) > th.maxValueSize {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrValueTooLarge, len(value), th.maxValueSize)
+	}
+	return nil
+}
+
+// isDefault returns whether value is nil or the default value, either of which
+// means that a key has no value.
+func (th *treeHasher) isDefault(value []byte) bool {
+	return value == nil || bytes.Equal(value, th.defaultValue)
+}
+
+func (th *treeHasher) digestValue(value []byte) []byte {
+	if th.valueHasher != nil {
+		return th.sum(th.valueHasher, value)
+	}
+	return th.digest(value)
+}
+
+func (th *treeHasher) valueSize() int {
+	return th.valueDigester().Size()
+}
+
+// valueKey returns the key under which the value of a leaf is kept in the
+// value store.
+func (th *treeHasher) valueKey(leafData []byte) []byte {
+	return th.digest(leafData[len(leafPrefix):])
+}
+
 func (th *treeHasher) digestLeaf(path []byte, leafData []byte) ([]byte, []byte) {
 	value := make([]byte, 0, len(leafPrefix)+len(path)+len(leafData))
 	value = append(value, leafPrefix...)
@@ -46,6 +130,38 @@ func (th *treeHasher) parseLeaf(data []byte) ([]byte, []byte, []byte) {
 	return data[len(leafPrefix) : th.pathSize()+len(leafPrefix)], data[len(leafPrefix)+th.pathSize():], data[len(leafPrefix):]
 }
 
+// CorruptNodeError is returned when the data of the node at Hash, read from
+// the store, is neither a valid leaf nor a valid inner node. Got is the size
+// of the data, and Want the size expected for its prefix; data with neither
+// prefix is expected to be a leaf. It wraps ErrMalformedNode.
+type CorruptNodeError struct {
+	Hash      []byte
+	Got, Want int
+}
+
+func (e *CorruptNodeError) Error() string {
+	return fmt.Sprintf("%v %x: got %d bytes, want %d", ErrMalformedNode, e.Hash, e.Got, e.Want)
+}
+
+func (e *CorruptNodeError) Unwrap() error {
+	return ErrMalformedNode
+}
+
+// checkNode checks that data, the data of the node at hash, has the size of
+// leaf data if it has the leaf prefix, or of inner node data if it has the
+// node prefix, so that it can be parsed with parseLeaf or parseNode.
+func (th *treeHasher) checkNode(hash, data []byte) error {
+	want := len(leafPrefix) + th.pathSize() + th.valueSize()
+	prefixed := bytes.HasPrefix(data, leafPrefix)
+	if bytes.HasPrefix(data, nodePrefix) {
+		want, prefixed = len(nodePrefix)+2*th.pathSize(), true
+	}
+	if !prefixed || len(data) != want {
+		return &CorruptNodeError{Hash: hash, Got: len(data), Want: want}
+	}
+	return nil
+}
+
 func (th *treeHasher) isLeaf(data []byte) bool {
 	return bytes.Equal(data[:len(leafPrefix)], leafPrefix)
 }
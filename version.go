@@ -0,0 +1,59 @@
+package smt
+
+// Snapshot returns the current root of the tree as a handle to the current
+// version, for use with GetAtVersion and ProveAtVersion.
+//
+// A version stays readable as long as its nodes are in the node store, even
+// after the tree is updated. Pruning another version with RemovePath keeps the
+// nodes shared with the kept version, so a version is only lost once its
+// unique nodes are pruned.
+func (smt *SparseMerkleTree) Snapshot() []byte {
+	return smt.Root()
+}
+
+// GetAtVersion gets the value of a key at a version returned by Snapshot. It
+// is the same as GetFromRoot.
+func (smt *SparseMerkleTree) GetAtVersion(root, key []byte) ([]byte, error) {
+	return smt.GetFromRoot(key, root)
+}
+
+// ProveAtVersion generates a Merkle proof for a key at a version returned by
+// Snapshot. It is the same as ProveForRoot.
+func (smt *SparseMerkleTree) ProveAtVersion(root, key []byte) (SparseMerkleProof, error) {
+	return smt.ProveForRoot(key, root)
+}
+
+// Clone returns a tree at the current root of the tree, sharing its stores and
+// options, that can be updated without changing the root of the tree. Nodes
+// are addressed by their digests, so updates of either tree add new nodes
+// instead of changing the nodes they share.
+//
+// The clone has no key index, as the index follows a single root, and trees
+// and their clones do not lock each other, so stores used by both at once
+// must be safe for concurrent use. Deleting from either tree with RemovePath
+// may remove nodes the other still uses.
+func (smt *SparseMerkleTree) Clone() *SparseMerkleTree {
+	smt.mu.RLock()
+	defer smt.mu.RUnlock()
+
+	nodes := smt.nodes
+	if smt.tracedNodes != nil {
+		// The clone counts the node accesses of its own updates.
+		nodes = smt.tracedNodes.MapStore
+	}
+	clone := &SparseMerkleTree{
+		th:        smt.th,
+		nodes:     nodes,
+		values:    smt.values,
+		keyLength: smt.keyLength,
+		reads:     smt.reads,
+		tracer:    smt.tracer,
+		observer:  smt.observer,
+		existence: smt.existence.clone(),
+
+		inlineValueSize: smt.inlineValueSize,
+	}
+	clone.traceNodes()
+	clone.SetRoot(smt.Root())
+	return clone
+}
@@ -0,0 +1,124 @@
+package smt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+
+	// Take a snapshot after every round of updates.
+	var versions [][]byte
+	for v := 0; v < 5; v++ {
+		for i := 0; i < 10; i++ {
+			smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d-%d", i, v)))
+		}
+		smt.Delete([]byte(fmt.Sprintf("testKey%d", v)))
+		versions = append(versions, smt.Snapshot())
+	}
+
+	for v, root := range versions {
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("testKey%d", i))
+			expected := []byte(fmt.Sprintf("testValue%d-%d", i, v))
+			if i == v {
+				expected = defaultValue
+			}
+			value, err := smt.GetAtVersion(root, key)
+			if err != nil && !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("returned error when getting key at version %d: %v", v, err)
+			}
+			if !bytes.Equal(value, expected) {
+				t.Errorf("did not get correct value at version %d", v)
+			}
+			proof, err := smt.ProveAtVersion(root, key)
+			if err != nil {
+				t.Errorf("returned error when proving key at version %d: %v", v, err)
+			}
+			if !VerifyProof(proof, root, key, expected, sha256.New()) {
+				t.Errorf("proof at version %d failed to verify", v)
+			}
+		}
+	}
+
+	// Pruning a version does not affect the version it is kept for.
+	if err := smt.RemovePath([]byte("testKey5"), versions[0], versions[4]); err != nil {
+		t.Errorf("returned error when pruning version: %v", err)
+	}
+	if _, err := smt.GetAtVersion(versions[0], []byte("testKey5")); err == nil {
+		t.Error("did not return an error when getting key at pruned version")
+	}
+	for i := 0; i < 10; i++ {
+		value, err := smt.GetAtVersion(versions[4], []byte(fmt.Sprintf("testKey%d", i)))
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key at kept version: %v", err)
+		}
+		expected := []byte(fmt.Sprintf("testValue%d-4", i))
+		if i == 4 {
+			expected = defaultValue
+		}
+		if !bytes.Equal(value, expected) {
+			t.Error("did not get correct value at kept version")
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	smt := NewSparseMerkleTree(NewSimpleMap(), NewSimpleMap(), sha256.New())
+	for i := 0; i < 10; i++ {
+		smt.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("testValue%d", i)))
+	}
+	root := smt.Root()
+
+	clone := smt.Clone()
+	if !bytes.Equal(clone.Root(), root) {
+		t.Error("clone has a different root")
+	}
+	for i := 5; i < 15; i++ {
+		clone.Update([]byte(fmt.Sprintf("testKey%d", i)), []byte(fmt.Sprintf("newValue%d", i)))
+	}
+	clone.Delete([]byte("testKey0"))
+
+	if !bytes.Equal(smt.Root(), root) {
+		t.Error("updating the clone changed the root of the tree")
+	}
+	for i := 0; i < 15; i++ {
+		key := []byte(fmt.Sprintf("testKey%d", i))
+		expected := []byte(fmt.Sprintf("testValue%d", i))
+		if i >= 10 {
+			expected = defaultValue
+		}
+		value, err := smt.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key: %v", err)
+		}
+		if !bytes.Equal(value, expected) {
+			t.Errorf("updating the clone changed the value of key %d", i)
+		}
+
+		expected = []byte(fmt.Sprintf("newValue%d", i))
+		if i == 0 {
+			expected = defaultValue
+		} else if i < 5 {
+			expected = []byte(fmt.Sprintf("testValue%d", i))
+		}
+		value, err = clone.Get(key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("returned error when getting key from clone: %v", err)
+		}
+		if !bytes.Equal(value, expected) {
+			t.Errorf("did not get correct value for key %d from clone", i)
+		}
+	}
+
+	// Updating the tree leaves the clone unchanged too.
+	cloneRoot := clone.Root()
+	smt.Update([]byte("testKey7"), []byte("otherValue"))
+	if !bytes.Equal(clone.Root(), cloneRoot) {
+		t.Error("updating the tree changed the root of the clone")
+	}
+}